@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	stdlog "log"
+	"math"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jonson/tsgrok/internal/funnel"
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// consoleLog prints a line for every proxied request, following the server's
+// events in place of the TUI.
+type consoleLog struct {
+	funnelRegistry *funnel.FunnelRegistry
+	out            io.Writer
+	errOut         io.Writer
+}
+
+func (l *consoleLog) follow(event util.Event) {
+	switch e := event.(type) {
+	case funnel.ProxyRequestMsg:
+		l.printRequest(e)
+	case funnel.ErrorMsg:
+		fmt.Fprintf(l.errOut, "Error: %v\n", e.Err)
+	case util.EventsDropped:
+		fmt.Fprintf(l.errOut, "%d events dropped, the console fell behind\n", e.Count)
+	}
+}
+
+func (l *consoleLog) printRequest(msg funnel.ProxyRequestMsg) {
+	f, err := l.funnelRegistry.GetFunnel(msg.FunnelId)
+	if err != nil || f.Requests == nil {
+		return
+	}
+
+	req := f.Requests.Find(msg.RequestId)
+	if req == nil {
+		return
+	}
+
+	fmt.Fprintf(l.out, "%s %-7s %3d %8s %s",
+		req.Timestamp.Format("15:04:05"),
+		req.Method(),
+		req.StatusCode(),
+		req.DurationLabel(),
+		req.Path(),
+	)
+	if req.Error != "" {
+		fmt.Fprintf(l.out, "  (%s)", req.ErrorKind.Description())
+	}
+	fmt.Fprintln(l.out)
+}
+
+// runHttpCommand exposes a single local target through a funnel without the TUI,
+// printing each proxied request until SIGINT/SIGTERM is received.  It returns the
+// process exit code.
+func runHttpCommand(args []string, logger *stdlog.Logger) int {
+	fs := flag.NewFlagSet("http", flag.ContinueOnError)
+	name := fs.String("name", util.ProgramName, "tailscale node name for the funnel")
+	remotePort := fs.Uint("remote-port", 443, "public port for the funnel, one of 443, 8443 or 10000")
+	inspect := fs.Bool("inspect", true, "capture requests through the inspection proxy, use --inspect=false to pass traffic straight through")
+	caCert := fs.String("ca-cert", "", "PEM bundle of extra CAs to trust for an https:// target")
+	errorPage := fs.String("error-page", "", "html/template returned to the public client when the target can't be reached")
+	maxBodyBytes := fs.Int64("max-body-bytes", util.DefaultMaxBodyBytes, "bytes of each request and response body to capture, larger bodies are streamed but truncated in the inspector")
+	maxRequests := fs.Int("max-requests", util.GetMaxRequests(), "captured requests to keep, the oldest are dropped first (env "+util.MaxRequestsEnvVar+")")
+	maxCaptureBytes := fs.Int64("max-capture-bytes", util.GetMaxCaptureBytes(), "memory for captured requests before the oldest are dropped, 0 for no limit (env "+util.MaxCaptureBytesEnvVar+")")
+	storeOpts := addStoreFlags(fs)
+	harPath := fs.String("har", "", "write the captured requests to this HAR file when the funnel stops")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s http <target> [flags]\n\n", util.ProgramName)
+		fmt.Fprintf(fs.Output(), "<target> is a port (8080), host:port or URL (http://localhost:8080, https://localhost:8443,\nhttps+insecure://localhost:8443 for self-signed certificates)\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+	target := positional[0]
+
+	if *remotePort > math.MaxUint16 || funnel.ValidateRemotePort(uint16(*remotePort)) != nil {
+		fmt.Fprintf(os.Stderr, "Invalid remote port %d, must be one of 443, 8443 or 10000\n", *remotePort)
+		return 2
+	}
+	if *maxBodyBytes < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid --max-body-bytes %d, cannot be negative\n", *maxBodyBytes)
+		return 2
+	}
+	if err := validateCaptureLimits(*maxRequests, *maxCaptureBytes); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if err := storeOpts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	requireAuthKey()
+
+	funnelRegistry := funnel.NewFunnelRegistry()
+	funnelRegistry.CaptureBudget().SetLimit(*maxCaptureBytes)
+	events := util.NewBus()
+	console := &consoleLog{funnelRegistry: funnelRegistry, out: os.Stdout, errOut: os.Stderr}
+	defer events.Subscribe(console.follow)()
+
+	httpServer, err := funnel.NewHttpServer(util.GetProxyHttpPort(), events, funnelRegistry, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating HTTP server: %v\n", err)
+		return 1
+	}
+	defer httpServer.Close()
+	store, err := openCaptureStore(storeOpts, funnelRegistry, httpServer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening capture store: %v\n", err)
+		return 1
+	}
+	if store != nil {
+		defer store.Close()
+		fmt.Printf("Saving captures to %s\n", store.Dir())
+	}
+	if err := httpServer.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting HTTP server: %v\n", err)
+		return 1
+	}
+
+	// register for signals before the (slow) node startup so an early ctrl+c still
+	// cleans up, abandoning the startup
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Creating funnel %s for %s...\n", *name, target)
+	f, err := funnel.CreateEphemeralFunnel(ctx, funnel.EphemeralFunnelOptions{
+		Name:         *name,
+		Target:       target,
+		RemotePort:   uint16(*remotePort),
+		Inspect:      *inspect,
+		CACertFile:   *caCert,
+		MaxRequests:  *maxRequests,
+		MaxBodyBytes: *maxBodyBytes,
+		ErrorPage:    *errorPage,
+	}, logger)
+	if err != nil && ctx.Err() != nil {
+		fmt.Println()
+		return 0 // interrupted before the funnel was up
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating funnel: %v\n", err)
+		return 1
+	}
+	funnelRegistry.AddFunnel(f)
+	defer destroyFunnels(funnelRegistry)
+
+	fmt.Printf("Forwarding %s -> %s\n", f.RemoteTarget(), f.LocalTarget())
+	fmt.Printf("Press ctrl+c to stop\n\n")
+
+	<-ctx.Done()
+	fmt.Println()
+	if *harPath != "" {
+		if err := writeHARFile(*harPath, f); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing HAR: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// writeHARFile exports the funnel's captured requests to a HAR file at path.
+func writeHARFile(path string, f funnel.Funnel) error {
+	captures := f.Requests.All()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := funnel.NewHAR(f, captures).Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d requests to %s\n", len(captures), path)
+	return nil
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments (e.g. `http 8080 --name foo`), returning the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
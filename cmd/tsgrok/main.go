@@ -1,17 +1,32 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/joho/godotenv"
+	"github.com/jonson/tsgrok/internal/config"
 	"github.com/jonson/tsgrok/internal/funnel"
 	"github.com/jonson/tsgrok/internal/tui"
 	"github.com/jonson/tsgrok/internal/util"
 )
 
+const commands = `Usage:
+  tsgrok [flags]                  start the interactive terminal UI
+  tsgrok http <target> [flags]    expose a single local target without the UI
+  tsgrok view <file.har>...       browse exported HAR files, no auth key needed
+
+Run 'tsgrok http -h' for the flags accepted by the http command.
+`
+
+const usage = commands + `
+Flags:
+`
+
 func main() {
 	serverErrorLog := util.NewServerErrorLog()
 
@@ -20,20 +35,81 @@ func main() {
 		serverErrorLog.Println("Error loading .env file")
 	}
 
-	if util.GetAuthKey() == "" {
-		fmt.Printf("Missing env var %s, please set it and try again.\n", util.AuthKeyEnvVar)
+	// subcommands run without the TUI, flags fall through to the interactive mode
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "http":
+			os.Exit(runHttpCommand(os.Args[2:], serverErrorLog))
+		case "view":
+			os.Exit(runViewCommand(os.Args[2:], serverErrorLog))
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], commands)
+			os.Exit(2)
+		}
+	}
+
+	fs := flag.NewFlagSet(util.ProgramName, flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a config file of funnels to create at launch (default: tsgrok.yaml in the current directory, if present)")
+	maxRequests := fs.Int("max-requests", util.GetMaxRequests(), "captured requests to keep per funnel, the oldest are dropped first (env "+util.MaxRequestsEnvVar+")")
+	maxCaptureBytes := fs.Int64("max-capture-bytes", util.GetMaxCaptureBytes(), "memory for captured requests across all funnels before the oldest are dropped, 0 for no limit (env "+util.MaxCaptureBytesEnvVar+")")
+	storeOpts := addStoreFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(os.Args[1:]) // ExitOnError
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := validateCaptureLimits(*maxRequests, *maxCaptureBytes); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	if err := storeOpts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
+	// the config is validated up front so mistakes are reported before any tsnet node is started
+	startupFunnels, err := loadStartupFunnels(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+	// funnels from the config file without their own max_requests use the flag
+	for i := range startupFunnels {
+		if startupFunnels[i].MaxRequests == 0 {
+			startupFunnels[i].MaxRequests = *maxRequests
+		}
+	}
 
-	messageBus := &util.MessageBusImpl{}
+	requireAuthKey()
+
+	events := util.NewBus()
 	funnelRegistry := funnel.NewFunnelRegistry()
-	httpServer, err := funnel.NewHttpServer(util.GetProxyHttpPort(), messageBus, funnelRegistry, serverErrorLog)
+	funnelRegistry.CaptureBudget().SetLimit(*maxCaptureBytes)
+	httpServer, err := funnel.NewHttpServer(util.GetProxyHttpPort(), events, funnelRegistry, serverErrorLog)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating HTTP server: %v\n", err)
 		os.Exit(1)
 	}
+	defer httpServer.Close()
+	httpServer.EnableFunnelCreation(funnel.EphemeralFunnelOptions{MaxRequests: *maxRequests})
+	store, err := openCaptureStore(storeOpts, funnelRegistry, httpServer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening capture store: %v\n", err)
+		os.Exit(1)
+	}
+	if store != nil {
+		defer store.Close()
+	}
 
-	m := tui.InitialModel(funnelRegistry, serverErrorLog)
+	m := tui.InitialModel(funnelRegistry, serverErrorLog, tui.Options{
+		StartupFunnels: startupFunnels,
+		MaxRequests:    *maxRequests,
+		Server:         httpServer,
+	})
 
 	go func() {
 		err := httpServer.Start()
@@ -43,39 +119,88 @@ func main() {
 		}
 	}()
 
-	cleanup := func() {
-		if len(funnelRegistry.Funnels) == 0 {
-			return
-		}
+	defer destroyFunnels(funnelRegistry)
 
-		if len(funnelRegistry.Funnels) == 1 {
-			fmt.Printf("Closing tunnel\n")
-		} else {
-			fmt.Printf("Closing %d tunnels\n", len(funnelRegistry.Funnels))
-		}
+	p := tea.NewProgram(m, tea.WithAltScreen())
 
-		var wg sync.WaitGroup
-		for _, f := range funnelRegistry.Funnels {
-			wg.Add(1)
-			go func(f funnel.Funnel) {
-				defer wg.Done()
-				err := f.Destroy()
-				// should we log it?
-				if err != nil {
-					fmt.Printf("Error destroying tunnel: %v\n", err)
-				}
-			}(f)
+	// the program is sent events on the subscription's goroutine, so the proxy never waits on the UI
+	defer events.Subscribe(func(event util.Event) { p.Send(event) })()
+
+	if _, err := p.Run(); err != nil {
+		fmt.Println(err)
+	}
+
+}
+
+// loadStartupFunnels loads the funnels to create at launch from the given config
+// file, or from a config file discovered in the working directory when path is empty.
+func loadStartupFunnels(path string) ([]funnel.EphemeralFunnelOptions, error) {
+	if path == "" {
+		var err error
+		path, err = config.Discover(".")
+		if err != nil {
+			return nil, fmt.Errorf("error looking for config file: %w", err)
+		}
+		if path == "" {
+			return nil, nil
 		}
-		wg.Wait()
 	}
-	defer cleanup()
 
-	p := tea.NewProgram(m, tea.WithAltScreen())
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config %s:\n%w", path, err)
+	}
+	return cfg.FunnelOptions(), nil
+}
+
+// validateCaptureLimits checks the --max-requests and --max-capture-bytes flags.
+func validateCaptureLimits(maxRequests int, maxCaptureBytes int64) error {
+	if maxRequests < 1 {
+		return fmt.Errorf("invalid --max-requests %d, must be at least 1", maxRequests)
+	}
+	if maxCaptureBytes < 0 {
+		return fmt.Errorf("invalid --max-capture-bytes %d, cannot be negative", maxCaptureBytes)
+	}
+	return nil
+}
 
-	messageBus.SetProgram(p)
+// requireAuthKey exits the program if no tailscale auth key is configured.
+func requireAuthKey() {
+	if util.GetAuthKey() == "" {
+		fmt.Printf("Missing env var %s, please set it and try again.\n", util.AuthKeyEnvVar)
+		os.Exit(1)
+	}
+}
 
-	if _, err := p.Run(); err != nil {
-		fmt.Println(err)
+// destroyFunnels tears down every funnel in the registry in parallel.
+func destroyFunnels(funnelRegistry *funnel.FunnelRegistry) {
+	var funnels []funnel.Funnel
+	for _, f := range funnelRegistry.Funnels() {
+		if !f.Archived { // archived funnels have no node to tear down
+			funnels = append(funnels, f)
+		}
+	}
+	if len(funnels) == 0 {
+		return
 	}
 
+	if len(funnels) == 1 {
+		fmt.Printf("Closing tunnel\n")
+	} else {
+		fmt.Printf("Closing %d tunnels\n", len(funnels))
+	}
+
+	var wg sync.WaitGroup
+	for _, f := range funnels {
+		wg.Add(1)
+		go func(f funnel.Funnel) {
+			defer wg.Done()
+			err := f.Destroy()
+			// should we log it?
+			if err != nil {
+				fmt.Printf("Error destroying tunnel: %v\n", err)
+			}
+		}(f)
+	}
+	wg.Wait()
 }
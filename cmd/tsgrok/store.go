@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/jonson/tsgrok/internal/funnel"
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// storeFlags configure the on-disk capture store, they are shared by every command.
+type storeFlags struct {
+	enabled  *bool
+	maxAge   *time.Duration
+	maxBytes *int64
+}
+
+func addStoreFlags(fs *flag.FlagSet) storeFlags {
+	return storeFlags{
+		enabled:  fs.Bool("store", false, "save captures to disk as they arrive, and show the ones from earlier runs"),
+		maxAge:   fs.Duration("store-max-age", util.DefaultStoreMaxAge, "stored captures older than this are pruned, 0 keeps them"),
+		maxBytes: fs.Int64("store-max-bytes", util.DefaultStoreMaxBytes, "size of the capture store before the oldest captures are pruned, 0 for no limit"),
+	}
+}
+
+func (f storeFlags) validate() error {
+	if *f.maxAge < 0 {
+		return fmt.Errorf("invalid --store-max-age %s, cannot be negative", *f.maxAge)
+	}
+	if *f.maxBytes < 0 {
+		return fmt.Errorf("invalid --store-max-bytes %d, cannot be negative", *f.maxBytes)
+	}
+	return nil
+}
+
+// openCaptureStore opens the capture store when --store is set, adds the captures
+// of earlier runs to the registry as archived funnels, and has the server save new
+// ones.  It returns nil when the store is disabled.
+func openCaptureStore(f storeFlags, funnelRegistry *funnel.FunnelRegistry, httpServer *funnel.HttpServer) (*funnel.CaptureStore, error) {
+	if !*f.enabled {
+		return nil, nil
+	}
+	stateDir, err := util.StateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := funnel.OpenCaptureStore(filepath.Join(stateDir, "captures"), *f.maxAge, *f.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := store.Load()
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	for _, f := range archived {
+		funnelRegistry.AddFunnel(f)
+	}
+	httpServer.SetCaptureStore(store)
+	return store, nil
+}
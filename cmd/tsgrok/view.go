@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jonson/tsgrok/internal/funnel"
+	"github.com/jonson/tsgrok/internal/tui"
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// runViewCommand opens HAR files as read-only funnels in the TUI and web inspector.
+// No funnels are created, so it doesn't need an auth key.
+func runViewCommand(args []string, logger *stdlog.Logger) int {
+	fs := flag.NewFlagSet(util.ProgramName+" view", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s view <file.har> [file.har...]\n\nBrowse requests exported as HAR files, without creating any funnels.\n", util.ProgramName)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// the files are read up front so mistakes are reported before the TUI starts
+	funnelRegistry := funnel.NewFunnelRegistry()
+	funnelRegistry.CaptureBudget().SetLimit(0) // everything in the files is kept
+	for _, path := range fs.Args() {
+		f, err := funnel.LoadHARFunnel(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if _, err := funnelRegistry.GetFunnel(f.ID()); err == nil {
+			fmt.Fprintf(os.Stderr, "%s: more than one file named %s\n", path, f.Name())
+			return 2
+		}
+		funnelRegistry.AddFunnel(f)
+	}
+
+	events := util.NewBus()
+	httpServer, err := funnel.NewHttpServer(util.GetProxyHttpPort(), events, funnelRegistry, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating HTTP server: %v\n", err)
+		return 1
+	}
+	defer httpServer.Close()
+	if err := httpServer.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting HTTP server: %v\n", err)
+		return 1
+	}
+
+	m := tui.InitialModel(funnelRegistry, logger, tui.Options{ReadOnly: true, Server: httpServer})
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	defer events.Subscribe(func(event util.Event) { p.Send(event) })()
+	if _, err := p.Run(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jonson/tsgrok/internal/funnel"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFileNames are the config files looked for in the working directory, in order.
+var DefaultFileNames = []string{"tsgrok.yaml", "tsgrok.yml"}
+
+// Config is the declarative configuration loaded at startup.
+type Config struct {
+	Funnels []FunnelConfig `yaml:"funnels"`
+}
+
+// FunnelConfig describes a single funnel to create at launch.
+type FunnelConfig struct {
+	Name         string `yaml:"name"`           // tailscale node name
+	Target       string `yaml:"target"`         // local target, e.g. 8080 or http://localhost:8080
+	RemotePort   uint16 `yaml:"remote_port"`    // public port, one of 443, 8443, 10000.  defaults to 443
+	Inspect      *bool  `yaml:"inspect"`        // route through the inspection proxy, defaults to true
+	MaxRequests  int    `yaml:"max_requests"`   // captured requests to keep, defaults to --max-requests
+	MaxBodyBytes int64  `yaml:"max_body_bytes"` // bytes of each body to capture, defaults to util.DefaultMaxBodyBytes
+	CACert       string `yaml:"ca_cert"`        // PEM bundle of extra CAs for an https target, relative to the config file
+	ErrorPage    string `yaml:"error_page"`     // html/template shown when the target is down, relative to the config file
+}
+
+// Discover returns the path of the first default config file found in dir, or an
+// empty string if there is none.
+func Discover(dir string) (string, error) {
+	for _, name := range DefaultFileNames {
+		path := filepath.Join(dir, name)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// Load reads and validates the config file at path.  Relative file paths in the
+// config are resolved against the directory containing it.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(data, filepath.Dir(path))
+}
+
+// Parse decodes and validates a config document.  Unknown keys are rejected so
+// typos don't silently fall back to defaults.  Relative file paths are resolved
+// against the working directory.
+func Parse(data []byte) (*Config, error) {
+	return parse(data, ".")
+}
+
+func parse(data []byte, baseDir string) (*Config, error) {
+	cfg := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	for i := range cfg.Funnels {
+		cfg.Funnels[i].CACert = resolvePath(baseDir, cfg.Funnels[i].CACert)
+		cfg.Funnels[i].ErrorPage = resolvePath(baseDir, cfg.Funnels[i].ErrorPage)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolvePath makes a relative path relative to baseDir, leaving empty and absolute paths as is.
+func resolvePath(baseDir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// Validate checks every funnel entry, returning all problems found joined together.
+func (c *Config) Validate() error {
+	var errs []error
+	seen := make(map[string]int)
+
+	for i, fc := range c.Funnels {
+		label := fmt.Sprintf("funnel #%d", i+1)
+		if fc.Name != "" {
+			label = fmt.Sprintf("funnel #%d (%s)", i+1, fc.Name)
+		}
+
+		for _, err := range fc.validate() {
+			errs = append(errs, fmt.Errorf("%s: %w", label, err))
+		}
+
+		if fc.Name != "" {
+			key := strings.ToLower(fc.Name)
+			if prev, ok := seen[key]; ok {
+				errs = append(errs, fmt.Errorf("%s: name already used by funnel #%d", label, prev))
+			} else {
+				seen[key] = i + 1
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (fc FunnelConfig) validate() []error {
+	var errs []error
+
+	if fc.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	} else if len(fc.Name) > 63 {
+		errs = append(errs, errors.New("name must be at most 63 characters"))
+	}
+
+	var targetScheme string
+	if fc.Target == "" {
+		errs = append(errs, errors.New("target is required"))
+	} else if targetURL, err := funnel.ParseLocalTarget(fc.Target); err != nil {
+		errs = append(errs, fmt.Errorf("invalid target %q: %w", fc.Target, err))
+	} else {
+		targetScheme = targetURL.Scheme
+	}
+
+	if fc.CACert != "" {
+		if targetScheme != "" && targetScheme != "https" {
+			errs = append(errs, errors.New("ca_cert requires an https:// target"))
+		} else if fc.Inspect != nil && !*fc.Inspect {
+			errs = append(errs, errors.New("ca_cert is only used by the inspection proxy and requires inspect: true"))
+		} else if _, err := funnel.LoadCACertPool(fc.CACert); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if fc.ErrorPage != "" {
+		if fc.Inspect != nil && !*fc.Inspect {
+			errs = append(errs, errors.New("error_page is only used by the inspection proxy and requires inspect: true"))
+		} else if _, err := funnel.LoadErrorPage(fc.ErrorPage); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if fc.RemotePort != 0 {
+		if err := funnel.ValidateRemotePort(fc.RemotePort); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if fc.MaxRequests < 0 {
+		errs = append(errs, errors.New("max_requests cannot be negative"))
+	}
+
+	if fc.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("max_body_bytes cannot be negative"))
+	}
+
+	return errs
+}
+
+// FunnelOptions converts the configured funnels into creation options.
+func (c *Config) FunnelOptions() []funnel.EphemeralFunnelOptions {
+	opts := make([]funnel.EphemeralFunnelOptions, 0, len(c.Funnels))
+	for _, fc := range c.Funnels {
+		inspect := true
+		if fc.Inspect != nil {
+			inspect = *fc.Inspect
+		}
+		opts = append(opts, funnel.EphemeralFunnelOptions{
+			Name:         fc.Name,
+			Target:       fc.Target,
+			RemotePort:   fc.RemotePort,
+			Inspect:      inspect,
+			MaxRequests:  fc.MaxRequests,
+			MaxBodyBytes: fc.MaxBodyBytes,
+			CACertFile:   fc.CACert,
+			ErrorPage:    fc.ErrorPage,
+		})
+	}
+	return opts
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jonson/tsgrok/internal/funnel"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`
+funnels:
+  - name: webhook-dev
+    target: 8080
+    remote_port: 8443
+    max_requests: 250
+    max_body_bytes: 4096
+  - name: docs
+    target: http://localhost:3000
+    inspect: false
+`)
+
+	cfg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+
+	want := []funnel.EphemeralFunnelOptions{
+		{Name: "webhook-dev", Target: "8080", RemotePort: 8443, Inspect: true, MaxRequests: 250, MaxBodyBytes: 4096},
+		{Name: "docs", Target: "http://localhost:3000", Inspect: false},
+	}
+	if diff := cmp.Diff(want, cfg.FunnelOptions()); diff != "" {
+		t.Errorf("FunnelOptions() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse_Empty(t *testing.T) {
+	cfg, err := Parse([]byte(""))
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if len(cfg.Funnels) != 0 {
+		t.Errorf("Expected no funnels, got %d", len(cfg.Funnels))
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantErrs []string
+	}{
+		{
+			name:     "Unknown key",
+			data:     "funnels:\n  - name: a\n    target: 8080\n    port: 1\n",
+			wantErrs: []string{"field port not found"},
+		},
+		{
+			name:     "Missing name and target",
+			data:     "funnels:\n  - inspect: true\n",
+			wantErrs: []string{"funnel #1: name is required", "funnel #1: target is required"},
+		},
+		{
+			name:     "Target without port",
+			data:     "funnels:\n  - name: a\n    target: http://localhost\n",
+			wantErrs: []string{"funnel #1 (a): invalid target"},
+		},
+		{
+			name:     "Bad remote port",
+			data:     "funnels:\n  - name: a\n    target: 8080\n    remote_port: 80\n",
+			wantErrs: []string{"funnel #1 (a): invalid remote port 80"},
+		},
+		{
+			name:     "CA bundle with plain http target",
+			data:     "funnels:\n  - name: a\n    target: http://localhost:8080\n    ca_cert: ca.pem\n",
+			wantErrs: []string{"ca_cert requires an https:// target"},
+		},
+		{
+			name:     "CA bundle without inspection",
+			data:     "funnels:\n  - name: a\n    target: https://localhost:8443\n    inspect: false\n    ca_cert: ca.pem\n",
+			wantErrs: []string{"ca_cert is only used by the inspection proxy"},
+		},
+		{
+			name:     "Missing CA bundle",
+			data:     "funnels:\n  - name: a\n    target: https://localhost:8443\n    ca_cert: does-not-exist.pem\n",
+			wantErrs: []string{"failed to read CA bundle"},
+		},
+		{
+			name:     "Negative max requests",
+			data:     "funnels:\n  - name: a\n    target: 8080\n    max_requests: -1\n",
+			wantErrs: []string{"max_requests cannot be negative"},
+		},
+		{
+			name:     "Missing error page",
+			data:     "funnels:\n  - name: a\n    target: 8080\n    error_page: does-not-exist.html\n",
+			wantErrs: []string{"failed to read error page"},
+		},
+		{
+			name:     "Error page without inspection",
+			data:     "funnels:\n  - name: a\n    target: 8080\n    inspect: false\n    error_page: down.html\n",
+			wantErrs: []string{"error_page is only used by the inspection proxy"},
+		},
+		{
+			name:     "Negative max body bytes",
+			data:     "funnels:\n  - name: a\n    target: 8080\n    max_body_bytes: -1\n",
+			wantErrs: []string{"max_body_bytes cannot be negative"},
+		},
+		{
+			name: "Every entry is reported",
+			data: "funnels:\n  - name: a\n    target: 8080\n  - name: A\n    target: 8081\n  - name: c\n    remote_port: 1\n",
+			wantErrs: []string{
+				"funnel #2 (A): name already used by funnel #1",
+				"funnel #3 (c): target is required",
+				"funnel #3 (c): invalid remote port 1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if err == nil {
+				t.Fatalf("Parse() expected error, got nil")
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Parse() error %q does not contain %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+
+	path, err := Discover(dir)
+	if err != nil || path != "" {
+		t.Fatalf("Discover() on empty dir = %q, %v; want empty path", path, err)
+	}
+
+	want := filepath.Join(dir, "tsgrok.yml")
+	if err := os.WriteFile(want, []byte("funnels: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err = Discover(dir)
+	if err != nil {
+		t.Fatalf("Discover() unexpected error: %v", err)
+	}
+	if path != want {
+		t.Errorf("Discover() = %q, want %q", path, want)
+	}
+}
@@ -0,0 +1,537 @@
+package funnel
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonson/tsgrok/internal/util"
+	"github.com/jonson/tsgrok/web"
+)
+
+// APIPath is where the JSON API is served, versioned so it can change without
+// breaking the tools built on it.
+const APIPath = "/api/v1/"
+
+const (
+	defaultAPIPageSize = 50
+	maxAPIPageSize     = 500
+	maxAPIBodyBytes    = 64 << 10 // largest request body the API accepts, e.g. a new funnel
+)
+
+// apiFunnel is a funnel as listed by the API.
+type apiFunnel struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	RemoteURL   string `json:"remote_url"`
+	LocalTarget string `json:"local_target"`
+	Inspect     bool   `json:"inspect"`
+	Archived    bool   `json:"archived"`
+	Requests    int    `json:"requests"` // captures currently held
+}
+
+// apiNewFunnel is the body of a request creating a funnel.
+type apiNewFunnel struct {
+	Name        string `json:"name"`
+	Target      string `json:"target"`
+	RemotePort  uint16 `json:"remote_port"`
+	Inspect     *bool  `json:"inspect"`
+	MaxRequests int    `json:"max_requests"`
+}
+
+// apiRequestSummary is a capture as listed by the API.
+type apiRequestSummary struct {
+	ID         string         `json:"id"`
+	FunnelID   string         `json:"funnel_id"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Method     string         `json:"method"`
+	URL        string         `json:"url"`
+	Path       string         `json:"path"`
+	Status     int            `json:"status"`
+	DurationMS float64        `json:"duration_ms"`
+	Type       string         `json:"type,omitempty"`
+	InProgress bool           `json:"in_progress,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	ErrorKind  ProxyErrorKind `json:"error_kind,omitempty"`
+	Origin     string         `json:"origin,omitempty"` // replay, edited or composed
+	ReplayOf   string         `json:"replay_of,omitempty"`
+}
+
+// apiRequest is a capture with its headers and bodies.
+type apiRequest struct {
+	apiRequestSummary
+	Request   apiMessage    `json:"request"`
+	Response  apiMessage    `json:"response"`
+	WebSocket *apiWebSocket `json:"websocket,omitempty"`
+	Events    *apiEvents    `json:"events,omitempty"`
+}
+
+type apiMessage struct {
+	Headers       http.Header `json:"headers"`
+	Body          string      `json:"body"`
+	BodyEncoding  string      `json:"body_encoding,omitempty"` // base64 when the body isn't valid UTF-8
+	BodySize      int64       `json:"body_size"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+type apiWebSocket struct {
+	Count  int        `json:"count"` // frames seen, more than are kept on long connections
+	Closed bool       `json:"closed"`
+	Frames []apiFrame `json:"frames"`
+}
+
+type apiFrame struct {
+	Timestamp       time.Time `json:"timestamp"`
+	FromClient      bool      `json:"from_client"`
+	Type            string    `json:"type"`
+	Final           bool      `json:"final"`
+	Size            int64     `json:"size"`
+	Payload         string    `json:"payload"`
+	PayloadEncoding string    `json:"payload_encoding,omitempty"`
+}
+
+type apiEvents struct {
+	Count  int        `json:"count"` // events seen, more than are kept on long streams
+	Events []apiEvent `json:"events"`
+}
+
+type apiEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        string    `json:"id,omitempty"`
+	Event     string    `json:"event"`
+	Data      string    `json:"data"`
+	Size      int64     `json:"size"`
+}
+
+// apiRequestPage is a page of a funnel's captures, newest first.
+type apiRequestPage struct {
+	Requests []apiRequestSummary `json:"requests"`
+	Total    int                 `json:"total"` // captures matching the filter
+	Offset   int                 `json:"offset"`
+	Limit    int                 `json:"limit"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (s *HttpServer) handleAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, APIPath)
+	path = strings.TrimSuffix(path, "/")
+	parts := strings.Split(path, "/")
+
+	// browsers send some cross-site requests without asking first, other sites
+	// mustn't be able to create funnels or replay requests
+	if r.Method != http.MethodGet && !s.sameOrigin(r) {
+		writeAPIError(w, http.StatusForbidden, "cross-origin requests are not allowed")
+		return
+	}
+
+	if len(parts) == 1 && parts[0] == "openapi.json" {
+		if allowAPIMethods(w, r, http.MethodGet) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(web.OpenAPISpec)
+		}
+		return
+	}
+
+	if len(parts) == 1 && parts[0] == "funnels" {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleAPIListFunnels(w, r)
+		case http.MethodPost:
+			s.handleAPICreateFunnel(w, r)
+		default:
+			allowAPIMethods(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	if len(parts) == 2 && parts[0] == "funnels" && parts[1] != "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleAPIGetFunnel(w, r, parts[1])
+		case http.MethodDelete:
+			s.handleAPIDeleteFunnel(w, r, parts[1])
+		default:
+			allowAPIMethods(w, r, http.MethodGet, http.MethodDelete)
+		}
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "funnels" && parts[1] != "" && parts[2] == "requests" {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleAPIListRequests(w, r, parts[1])
+		case http.MethodDelete:
+			s.handleAPIClearRequests(w, r, parts[1])
+		default:
+			allowAPIMethods(w, r, http.MethodGet, http.MethodDelete)
+		}
+		return
+	}
+
+	if len(parts) == 4 && parts[0] == "funnels" && parts[1] != "" && parts[2] == "requests" && parts[3] != "" {
+		if allowAPIMethods(w, r, http.MethodGet) {
+			s.handleAPIGetRequest(w, r, parts[1], parts[3])
+		}
+		return
+	}
+
+	if len(parts) == 5 && parts[0] == "funnels" && parts[1] != "" && parts[2] == "requests" && parts[3] != "" && parts[4] == "replay" {
+		if allowAPIMethods(w, r, http.MethodPost) {
+			s.handleAPIReplayRequest(w, r, parts[1], parts[3])
+		}
+		return
+	}
+
+	writeAPIError(w, http.StatusNotFound, "not found")
+}
+
+func (s *HttpServer) handleAPIListFunnels(w http.ResponseWriter, r *http.Request) {
+	registered := s.funnelRegistry.Funnels()
+	funnels := make([]apiFunnel, 0, len(registered))
+	for _, f := range registered {
+		funnels = append(funnels, newAPIFunnel(f))
+	}
+	sort.Slice(funnels, func(i, j int) bool {
+		if funnels[i].Name != funnels[j].Name {
+			return funnels[i].Name < funnels[j].Name
+		}
+		return funnels[i].ID < funnels[j].ID
+	})
+	writeJSON(w, http.StatusOK, struct {
+		Funnels []apiFunnel `json:"funnels"`
+	}{funnels})
+}
+
+func (s *HttpServer) handleAPIGetFunnel(w http.ResponseWriter, r *http.Request, funnelID string) {
+	f, ok := s.apiFunnel(w, funnelID)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIFunnel(f))
+}
+
+// handleAPICreateFunnel brings up a new funnel, which takes a few seconds, and
+// responds once it is ready.
+func (s *HttpServer) handleAPICreateFunnel(w http.ResponseWriter, r *http.Request) {
+	if s.funnelDefaults == nil {
+		writeAPIError(w, http.StatusForbidden, errFunnelCreationDisabled.Error())
+		return
+	}
+
+	var req apiNewFunnel
+	if err := readJSON(w, r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f, err := s.createFunnel(r.Context(), newFunnelRequest{
+		Name:        req.Name,
+		Target:      req.Target,
+		RemotePort:  req.RemotePort,
+		Inspect:     req.Inspect == nil || *req.Inspect,
+		MaxRequests: req.MaxRequests,
+	})
+	if err != nil {
+		var optionsErr funnelOptionsError
+		if errors.As(err, &optionsErr) {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+		} else {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Location", APIPath+"funnels/"+f.ID())
+	writeJSON(w, http.StatusCreated, newAPIFunnel(f))
+}
+
+// handleAPIDeleteFunnel tears the funnel down and forgets its captures.
+func (s *HttpServer) handleAPIDeleteFunnel(w http.ResponseWriter, r *http.Request, funnelID string) {
+	if err := s.deleteFunnel(funnelID); err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+		} else {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIListRequests lists a page of the funnel's captures, newest first,
+// optionally only those matching the q filter.
+func (s *HttpServer) handleAPIListRequests(w http.ResponseWriter, r *http.Request, funnelID string) {
+	f, ok := s.apiFunnel(w, funnelID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := ParseFilter(query.Get("q"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := apiIntParam(query.Get("limit"), defaultAPIPageSize)
+	if err != nil || limit < 1 || limit > maxAPIPageSize {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAPIPageSize))
+		return
+	}
+	offset, err := apiIntParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeAPIError(w, http.StatusBadRequest, "offset must be 0 or more")
+		return
+	}
+
+	var captures []CaptureRequestResponse
+	if f.Requests != nil {
+		captures = filter.Apply(f.Requests.All())
+	}
+	page := apiRequestPage{Requests: []apiRequestSummary{}, Total: len(captures), Offset: offset, Limit: limit}
+	if offset < len(captures) {
+		captures = captures[offset:min(offset+limit, len(captures))]
+		for i := range captures {
+			page.Requests = append(page.Requests, newAPIRequestSummary(funnelID, &captures[i]))
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleAPIClearRequests forgets the funnel's captures, the capture store keeps
+// what it has saved.
+func (s *HttpServer) handleAPIClearRequests(w http.ResponseWriter, r *http.Request, funnelID string) {
+	f, ok := s.apiFunnel(w, funnelID)
+	if !ok {
+		return
+	}
+	if f.Requests != nil {
+		f.Requests.Clear()
+	}
+	s.events.Publish(RequestsClearedMsg{FunnelId: funnelID})
+	s.funnelRegistry.FunnelUpdated(funnelID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HttpServer) handleAPIGetRequest(w http.ResponseWriter, r *http.Request, funnelID string, requestID string) {
+	f, ok := s.apiFunnel(w, funnelID)
+	if !ok {
+		return
+	}
+	capture := findRequestInList(f.Requests, requestID)
+	if capture == nil {
+		writeAPIError(w, http.StatusNotFound, ErrRequestNotFound.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIRequest(funnelID, capture))
+}
+
+// handleAPIReplayRequest sends the capture to the local target again and responds
+// with the new capture.
+func (s *HttpServer) handleAPIReplayRequest(w http.ResponseWriter, r *http.Request, funnelID string, requestID string) {
+	ctx, cancel := context.WithTimeout(r.Context(), util.ReplayTimeout)
+	defer cancel()
+	replayed, err := s.Replay(ctx, funnelID, requestID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrFunnelNotFound), errors.Is(err, ErrRequestNotFound):
+			writeAPIError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrFunnelArchived), errors.Is(err, ErrReplayWebSocket), errors.Is(err, ErrReplayEventStream), errors.Is(err, ErrReplayBodyTruncated):
+			writeAPIError(w, http.StatusConflict, err.Error())
+		default:
+			s.logger.Printf("Error replaying request %s for funnel %s: %v", requestID, funnelID, err)
+			writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("replaying request: %v", err))
+		}
+		return
+	}
+	w.Header().Set("Location", APIPath+"funnels/"+funnelID+"/requests/"+replayed.ID)
+	writeJSON(w, http.StatusCreated, newAPIRequest(funnelID, replayed))
+}
+
+// apiFunnel returns the funnel with the given ID, responding with a 404 if there
+// is none.
+func (s *HttpServer) apiFunnel(w http.ResponseWriter, funnelID string) (Funnel, bool) {
+	f, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+		} else {
+			s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+			writeAPIError(w, http.StatusInternalServerError, "error retrieving funnel")
+		}
+		return Funnel{}, false
+	}
+	return f, true
+}
+
+func newAPIFunnel(f Funnel) apiFunnel {
+	af := apiFunnel{
+		ID:          f.ID(),
+		Name:        funnelName(f),
+		RemoteURL:   f.RemoteTarget(),
+		LocalTarget: f.LocalTarget(),
+		Inspect:     f.Inspect(),
+		Archived:    f.Archived,
+	}
+	if f.Requests != nil {
+		af.Requests = f.Requests.Len()
+	}
+	return af
+}
+
+func newAPIRequestSummary(funnelID string, c *CaptureRequestResponse) apiRequestSummary {
+	return apiRequestSummary{
+		ID:         c.ID,
+		FunnelID:   funnelID,
+		Timestamp:  c.Timestamp,
+		Method:     c.Method(),
+		URL:        c.URL(),
+		Path:       c.Path(),
+		Status:     c.StatusCode(),
+		DurationMS: float64(c.Duration) / float64(time.Millisecond),
+		Type:       c.Type(),
+		InProgress: c.InProgress,
+		Error:      c.Error,
+		ErrorKind:  c.ErrorKind,
+		Origin:     c.Origin(),
+		ReplayOf:   c.ReplayOf,
+	}
+}
+
+func newAPIRequest(funnelID string, c *CaptureRequestResponse) apiRequest {
+	req := apiRequest{
+		apiRequestSummary: newAPIRequestSummary(funnelID, c),
+		Request:           newAPIMessage(c.Request.Headers, c.Request.Body, c.Request.BodySize, c.Request.BodyTruncated),
+		Response:          newAPIMessage(c.Response.Headers, c.Response.Body, c.Response.BodySize, c.Response.BodyTruncated),
+	}
+	if c.WebSocket != nil {
+		req.WebSocket = &apiWebSocket{Count: c.WebSocket.Count(), Closed: c.WebSocket.Closed(), Frames: []apiFrame{}}
+		for _, frame := range c.WebSocket.Frames() {
+			af := apiFrame{
+				Timestamp:  frame.Timestamp,
+				FromClient: frame.Direction == WebSocketClientToServer,
+				Type:       frame.Type(),
+				Final:      frame.Final,
+				Size:       frame.Size,
+				Payload:    string(frame.Payload),
+			}
+			if frame.Opcode != wsOpText {
+				af.Payload = base64.StdEncoding.EncodeToString(frame.Payload)
+				af.PayloadEncoding = "base64"
+			}
+			req.WebSocket.Frames = append(req.WebSocket.Frames, af)
+		}
+	}
+	if c.ServerSentEvents != nil {
+		req.Events = &apiEvents{Count: c.ServerSentEvents.Count(), Events: []apiEvent{}}
+		for _, event := range c.ServerSentEvents.Events() {
+			req.Events.Events = append(req.Events.Events, apiEvent{
+				Timestamp: event.Timestamp,
+				ID:        event.ID,
+				Event:     event.Event,
+				Data:      event.Data,
+				Size:      event.Size,
+			})
+		}
+	}
+	return req
+}
+
+func newAPIMessage(headers http.Header, body []byte, size int64, truncated bool) apiMessage {
+	if headers == nil {
+		headers = http.Header{}
+	}
+	text, encoding := harBodyText(body)
+	return apiMessage{Headers: headers, Body: text, BodyEncoding: encoding, BodySize: size, BodyTruncated: truncated}
+}
+
+// allowAPIMethods reports whether the request uses one of the methods, responding
+// with a 405 if it doesn't.
+func allowAPIMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// apiIntParam parses an integer query parameter, empty values are the default.
+func apiIntParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// sameOrigin reports whether the request didn't come from a page on another site.
+// A page whose name was rebound to 127.0.0.1 is same-origin as far as the browser
+// is concerned, so the request must also be addressed to the inspector itself.
+func (s *HttpServer) sameOrigin(r *http.Request) bool {
+	if !s.isInspectorHost(r.Host) {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") != "cross-site"
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// isInspectorHost reports whether a Host header names the address the inspector
+// listens on.
+func (s *HttpServer) isInspectorHost(host string) bool {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil || port != strconv.Itoa(s.port) {
+		return false
+	}
+	switch hostname {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// readJSON decodes the request's JSON body into v, rejecting unknown fields.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return errors.New("the body must be application/json")
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("a JSON body is required")
+		}
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
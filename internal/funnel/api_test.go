@@ -0,0 +1,298 @@
+package funnel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// callAPI sends a request to the server's API and decodes the JSON response into out, if given.
+func callAPI(t *testing.T, s *HttpServer, method, path, body string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := newInspectorRequest(method, APIPath+path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	s.handleAPI(rec, req)
+	if out != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+func TestAPI_Funnels(t *testing.T) {
+	s, f := newTestProxy(t, "http://localhost:8080", nil)
+	f.HTTPFunnel.remoteTarget = "https://demo.example.ts.net"
+	f.Requests.Add(CaptureRequestResponse{ID: "a"})
+
+	var list struct{ Funnels []apiFunnel }
+	if rec := callAPI(t, s, http.MethodGet, "funnels", "", &list); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	want := []apiFunnel{{ID: "test-funnel", Name: "demo", RemoteURL: "https://demo.example.ts.net", LocalTarget: "http://localhost:8080", Inspect: true, Requests: 1}}
+	if diff := cmp.Diff(want, list.Funnels); diff != "" {
+		t.Errorf("Funnels mismatch (-want +got):\n%s", diff)
+	}
+
+	var got apiFunnel
+	if rec := callAPI(t, s, http.MethodGet, "funnels/test-funnel", "", &got); rec.Code != http.StatusOK || got.ID != "test-funnel" {
+		t.Errorf("Expected the funnel, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := callAPI(t, s, http.MethodGet, "funnels/nope", "", nil); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `"error": "funnel not found"`) {
+		t.Errorf("Expected a JSON 404, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := callAPI(t, s, http.MethodPut, "funnels/test-funnel", "", nil); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, DELETE" {
+		t.Errorf("Expected 405 allowing GET and DELETE, got %d %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestAPI_CreateFunnel(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+
+	if rec := callAPI(t, s, http.MethodPost, "funnels", `{"name":"web","target":"3000"}`, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected creating funnels to be off by default, got %d", rec.Code)
+	}
+
+	var created EphemeralFunnelOptions
+	s.EnableFunnelCreation(EphemeralFunnelOptions{MaxRequests: 25, MaxBodyBytes: 1024})
+	s.newFunnel = func(ctx context.Context, opts EphemeralFunnelOptions, logger *stdlog.Logger) (Funnel, error) {
+		if opts.Name == "taken" {
+			return Funnel{}, fmt.Errorf("%w: port 8443", ErrFunnelPortNotAllowed)
+		}
+		created = opts
+		return Funnel{HTTPFunnel: &HTTPFunnel{id: "new-funnel", localTarget: "http://localhost:3000", remoteTarget: "https://web.example.ts.net"}, Requests: NewRequestList(opts.MaxRequests)}, nil
+	}
+
+	var got apiFunnel
+	rec := callAPI(t, s, http.MethodPost, "funnels", `{"name":"web","target":"3000","inspect":false}`, &got)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != APIPath+"funnels/new-funnel" || got.Name != "web" {
+		t.Fatalf("Expected the funnel to be created, got %d %v: %s", rec.Code, rec.Header(), rec.Body.String())
+	}
+	want := EphemeralFunnelOptions{Name: "web", Target: "3000", RemotePort: 443, MaxRequests: 25, MaxBodyBytes: 1024}
+	if diff := cmp.Diff(want, created); diff != "" {
+		t.Errorf("Options mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := s.funnelRegistry.GetFunnel("new-funnel"); err != nil {
+		t.Errorf("Expected the funnel to be registered")
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		origin      string
+		host        string
+		wantStatus  int
+		wantErr     string
+	}{
+		{name: "missing name", body: `{"target":"3000"}`, wantStatus: http.StatusBadRequest, wantErr: "name and target are required"},
+		{name: "bad port", body: `{"name":"web","target":"3000","remote_port":80}`, wantStatus: http.StatusBadRequest, wantErr: "invalid remote port 80"},
+		{name: "bad target", body: `{"name":"web","target":"http://localhost"}`, wantStatus: http.StatusBadRequest, wantErr: "invalid target"},
+		{name: "unknown field", body: `{"name":"web","target":"3000","colour":"red"}`, wantStatus: http.StatusBadRequest, wantErr: "unknown field"},
+		{name: "port not allowed", body: `{"name":"taken","target":"3000"}`, wantStatus: http.StatusBadRequest, wantErr: "not allowed"},
+		{name: "not json", body: `{"name":"web","target":"3000"}`, contentType: "text/plain", wantStatus: http.StatusBadRequest, wantErr: "must be application/json"},
+		{name: "other site", body: `{"name":"web","target":"3000"}`, origin: "https://evil.example", wantStatus: http.StatusForbidden, wantErr: "cross-origin"},
+		{name: "rebound name", body: `{"name":"web","target":"3000"}`, origin: "http://evil.example:4040", host: "evil.example:4040", wantStatus: http.StatusForbidden, wantErr: "cross-origin"},
+		{name: "other port", body: `{"name":"web","target":"3000"}`, host: "127.0.0.1:8080", wantStatus: http.StatusForbidden, wantErr: "cross-origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newInspectorRequest(http.MethodPost, APIPath+"funnels", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			rec := httptest.NewRecorder()
+			s.handleAPI(rec, req)
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("Expected %d with %q, got %d: %s", tt.wantStatus, tt.wantErr, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPI_DeleteFunnel(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	s.funnelRegistry.AddFunnel(NewArchivedFunnel("old", "http://localhost:9000", "https://old.example.ts.net", NewRequestList(0)))
+
+	if rec := callAPI(t, s, http.MethodDelete, "funnels/old", "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.funnelRegistry.GetFunnel("old"); !errors.Is(err, ErrFunnelNotFound) {
+		t.Errorf("Expected the funnel to be removed")
+	}
+	if rec := callAPI(t, s, http.MethodDelete, "funnels/old", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected deleting it again to 404, got %d", rec.Code)
+	}
+}
+
+func TestAPI_Requests(t *testing.T) {
+	s, f := newTestProxy(t, "http://localhost:8080", nil)
+	start := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		status := 200
+		if i%2 == 1 {
+			status = 500
+		}
+		f.Requests.Add(CaptureRequestResponse{
+			ID:        fmt.Sprintf("r%d", i),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Duration:  1500 * time.Microsecond,
+			Request:   CaptureRequest{Method: http.MethodPost, URL: fmt.Sprintf("http://localhost:8080/hooks/%d?x=1", i), Headers: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"n":1}`), BodySize: 7},
+			Response:  CaptureResponse{StatusCode: status, Headers: http.Header{"Content-Type": {"image/png"}}, Body: []byte{0x89, 'P', 0}, BodySize: 300, BodyTruncated: true},
+		})
+	}
+
+	var page apiRequestPage
+	if rec := callAPI(t, s, http.MethodGet, "funnels/test-funnel/requests?limit=2&offset=1", "", &page); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	ids := []string{}
+	for _, r := range page.Requests {
+		ids = append(ids, r.ID)
+	}
+	if diff := cmp.Diff([]string{"r3", "r2"}, ids); diff != "" || page.Total != 5 || page.Offset != 1 || page.Limit != 2 {
+		t.Errorf("Unexpected page %+v (-want +got ids):\n%s", page, diff)
+	}
+	wantSummary := apiRequestSummary{ID: "r3", FunnelID: "test-funnel", Timestamp: start.Add(3 * time.Second), Method: "POST", URL: "http://localhost:8080/hooks/3?x=1", Path: "/hooks/3", Status: 500, DurationMS: 1.5, Type: "image"}
+	if diff := cmp.Diff(wantSummary, page.Requests[0]); diff != "" {
+		t.Errorf("Summary mismatch (-want +got):\n%s", diff)
+	}
+
+	callAPI(t, s, http.MethodGet, "funnels/test-funnel/requests?q=status%3A5xx", "", &page)
+	if page.Total != 2 || len(page.Requests) != 2 {
+		t.Errorf("Expected the 2 failed requests, got %+v", page)
+	}
+	callAPI(t, s, http.MethodGet, "funnels/test-funnel/requests?offset=10", "", &page)
+	if page.Total != 5 || page.Requests == nil || len(page.Requests) != 0 {
+		t.Errorf("Expected an empty page past the end, got %+v", page)
+	}
+	for _, query := range []string{"q=colour%3Ared", "limit=0", "limit=1000", "offset=-1", "limit=ten"} {
+		if rec := callAPI(t, s, http.MethodGet, "funnels/test-funnel/requests?"+query, "", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+
+	var detail apiRequest
+	if rec := callAPI(t, s, http.MethodGet, "funnels/test-funnel/requests/r0", "", &detail); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if detail.Request.Body != `{"n":1}` || detail.Request.BodyEncoding != "" {
+		t.Errorf("Expected the request body as text, got %+v", detail.Request)
+	}
+	if detail.Response.Body != "iVAA" || detail.Response.BodyEncoding != "base64" || detail.Response.BodySize != 300 || !detail.Response.BodyTruncated {
+		t.Errorf("Expected the binary response body in base64, got %+v", detail.Response)
+	}
+	if rec := callAPI(t, s, http.MethodGet, "funnels/test-funnel/requests/nope", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+
+	// clearing the history
+	budget := s.funnelRegistry.CaptureBudget()
+	if rec := callAPI(t, s, http.MethodDelete, "funnels/test-funnel/requests", "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if f.Requests.Len() != 0 || f.Requests.Bytes() != 0 || budget.Used() != 0 {
+		t.Errorf("Expected the requests and their memory to be released, got %d requests, %d bytes, %d budgeted", f.Requests.Len(), f.Requests.Bytes(), budget.Used())
+	}
+	bus := s.events.(*recordingBus)
+	want := []util.Event{RequestsClearedMsg{FunnelId: "test-funnel"}, FunnelChangedMsg{FunnelId: "test-funnel", Change: FunnelUpdated}}
+	if diff := cmp.Diff(want, bus.msgs[len(bus.msgs)-2:]); diff != "" {
+		t.Errorf("Expected the clearing to be published (-want +got):\n%s", diff)
+	}
+}
+
+func TestAPI_ReplayRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(append([]byte("echo:"), body...))
+	}))
+	defer backend.Close()
+	s, f := newTestProxy(t, backend.URL, nil)
+	f.Requests.Add(CaptureRequestResponse{ID: "orig", Request: CaptureRequest{Method: http.MethodPost, URL: backend.URL + "/hook", Body: []byte("ping"), BodySize: 4}})
+
+	var replayed apiRequest
+	rec := callAPI(t, s, http.MethodPost, "funnels/test-funnel/requests/orig/replay", "", &replayed)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if replayed.ReplayOf != "orig" || replayed.Origin != "replay" || replayed.Response.Body != "echo:ping" {
+		t.Errorf("Unexpected replay %+v", replayed)
+	}
+	if rec.Header().Get("Location") != APIPath+"funnels/test-funnel/requests/"+replayed.ID {
+		t.Errorf("Unexpected Location %q", rec.Header().Get("Location"))
+	}
+	if rec := callAPI(t, s, http.MethodGet, "funnels/test-funnel/requests/orig/replay", "", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestAPI_OpenAPI(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	var spec struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	rec := callAPI(t, s, http.MethodGet, "openapi.json", "", &spec)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected the spec, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, path := range []string{"/funnels", "/funnels/{funnelId}", "/funnels/{funnelId}/requests", "/funnels/{funnelId}/requests/{requestId}", "/funnels/{funnelId}/requests/{requestId}/replay"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("Expected the spec to describe %s", path)
+		}
+	}
+	if rec := callAPI(t, s, http.MethodGet, "nope", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
+
+func TestReadJSON_Empty(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/json")
+	var v apiNewFunnel
+	if err := readJSON(httptest.NewRecorder(), req, &v); err == nil || errors.Is(err, io.EOF) || !strings.Contains(err.Error(), "required") {
+		t.Errorf("Expected a readable error for an empty body, got %v", err)
+	}
+}
+
+func TestIsInspectorHost(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	for host, want := range map[string]bool{
+		"localhost:4040":        true,
+		"127.0.0.1:4040":        true,
+		"[::1]:4040":            true,
+		"localhost":             false,
+		"localhost:8080":        false,
+		"evil.example:4040":     false,
+		"127.0.0.1.nip.io:4040": false,
+	} {
+		if got := s.isInspectorHost(host); got != want {
+			t.Errorf("isInspectorHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package funnel
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/jonson/tsgrok/internal/util"
+	"golang.org/x/net/html"
+)
+
+// BodyFormat is how a captured body is interpreted for display.
+type BodyFormat string
+
+const (
+	BodyEmpty     BodyFormat = "empty"
+	BodyText      BodyFormat = "text"
+	BodyJSON      BodyFormat = "json"
+	BodyXML       BodyFormat = "xml"
+	BodyHTML      BodyFormat = "html"
+	BodyForm      BodyFormat = "form"
+	BodyMultipart BodyFormat = "multipart"
+	BodyImage     BodyFormat = "image"
+	BodyBinary    BodyFormat = "binary"
+)
+
+// maxDecodedBodyBytes bounds how much of a compressed body is decoded for display.
+const maxDecodedBodyBytes = 16 << 20
+
+// FormattedBody is a body ready to be shown.
+type FormattedBody struct {
+	Format      BodyFormat
+	Text        string
+	Pretty      bool        // Text was reformatted, e.g. indented JSON, rather than shown as captured
+	MediaType   string      // from the Content-Type header, e.g. image/png
+	Encoding    string      // the Content-Encoding decoded for display, e.g. gzip
+	DecodeError string      // why the body couldn't be decoded, or only partly
+	Fields      []FormField // form fields and multipart parts, in the order they were sent
+	decoded     []byte
+}
+
+// FormField is a field of a url-encoded form or a part of a multipart body.
+type FormField struct {
+	Name        string
+	Value       string // the value of a text field
+	FileName    string // set for file parts, whose content is downloaded instead
+	ContentType string
+	Size        int
+	data        []byte
+}
+
+// IsFile reports whether the field's content is downloaded rather than shown, as it
+// is an uploaded file or binary.
+func (f FormField) IsFile() bool {
+	return f.FileName != "" || !textBody(f.data)
+}
+
+// Data returns the field's content.
+func (f FormField) Data() []byte {
+	return f.data
+}
+
+// Decoded returns the body after its Content-Encoding was removed.
+func (b FormattedBody) Decoded() []byte {
+	return b.decoded
+}
+
+// FormatBody prepares a body for display.  Compressed bodies are decoded, JSON, XML and
+// HTML are pretty printed and forms are split into their fields, according to the
+// content type or what the body looks like when there isn't one, and binary bodies are
+// shown as a hex dump.  A body that doesn't parse, e.g. because it was truncated, is
+// shown as captured.
+func FormatBody(body []byte, headers http.Header) FormattedBody {
+	view := RawBody(body, headers)
+	if view.Format == BodyEmpty || view.Format == BodyImage {
+		return view
+	}
+
+	switch format := bodyFormatOf(view.decoded, view.MediaType); format {
+	case BodyMultipart:
+		if fields, text, err := parseMultipart(view.decoded, headers.Get("Content-Type")); err == nil {
+			view.Format, view.Text, view.Fields, view.Pretty = format, text, fields, true
+		}
+	case BodyForm:
+		if view.Format == BodyText {
+			if fields, text, err := parseForm(view.decoded); err == nil {
+				view.Format, view.Text, view.Fields, view.Pretty = format, text, fields, true
+			}
+		}
+	case BodyJSON, BodyXML, BodyHTML:
+		if view.Format == BodyText {
+			pretty := map[BodyFormat]func([]byte) (string, error){BodyJSON: prettyJSON, BodyXML: prettyXML, BodyHTML: prettyHTML}[format]
+			if text, err := pretty(view.decoded); err == nil {
+				view.Format, view.Text, view.Pretty = format, text, true
+			}
+		}
+	}
+	return view
+}
+
+// RawBody shows a body without reformatting it, only decoding its Content-Encoding.
+// Binary bodies are shown as a hex dump.
+func RawBody(body []byte, headers http.Header) FormattedBody {
+	view := FormattedBody{decoded: body}
+	view.MediaType, _, _ = mime.ParseMediaType(headers.Get("Content-Type"))
+
+	if encoding := headers.Get("Content-Encoding"); len(body) > 0 && encoding != "" && !strings.EqualFold(encoding, "identity") {
+		decoded, err := decodeContentEncoding(body, encoding)
+		switch {
+		case err == nil:
+			view.decoded, view.Encoding = decoded, encoding
+		case len(decoded) > 0:
+			// a truncated capture decodes as far as it goes
+			view.decoded, view.Encoding = decoded, encoding
+			view.DecodeError = fmt.Sprintf("only part of the %s body could be decoded: %v", encoding, err)
+		default:
+			view.DecodeError = fmt.Sprintf("the %s body couldn't be decoded, it is shown as captured: %v", encoding, err)
+		}
+	}
+
+	switch {
+	case len(view.decoded) == 0:
+		view.Format = BodyEmpty
+	case !textBody(view.decoded):
+		view.Format = BodyBinary
+		if strings.HasPrefix(view.MediaType, "image/") && view.DecodeError == "" {
+			view.Format = BodyImage
+		}
+		view.Text = strings.TrimSuffix(hex.Dump(view.decoded), "\n")
+	default:
+		view.Format = BodyText
+		view.Text = string(view.decoded)
+	}
+	return view
+}
+
+// decodeContentEncoding removes the encodings listed in a Content-Encoding header, which
+// were applied in order.  Whatever was decoded before an error is returned with it.
+func decodeContentEncoding(body []byte, contentEncoding string) ([]byte, error) {
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		var err error
+		switch encoding := strings.ToLower(strings.TrimSpace(encodings[i])); encoding {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// deflate is meant to be zlib wrapped, but some servers send it raw
+			reader, err = zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(body))
+		case "identity", "":
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported encoding %q", encoding)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBodyBytes+1))
+		if len(decoded) > maxDecodedBodyBytes {
+			return decoded[:maxDecodedBodyBytes], fmt.Errorf("the decoded body is larger than %s", util.FormatBytes(maxDecodedBodyBytes))
+		}
+		if err != nil {
+			return decoded, err
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+// bodyFormatOf works out the format of a body from its media type, looking at the body
+// itself when the type doesn't say.
+func bodyFormatOf(body []byte, mediaType string) BodyFormat {
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return BodyJSON
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return BodyXML
+	case mediaType == "text/html":
+		return BodyHTML
+	case mediaType == "application/x-www-form-urlencoded":
+		return BodyForm
+	case mediaType == "multipart/form-data":
+		return BodyMultipart
+	case mediaType != "" && mediaType != "text/plain" && mediaType != "application/octet-stream":
+		return BodyText
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) == 0:
+		return BodyText
+	case (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed):
+		return BodyJSON
+	case bytes.HasPrefix(trimmed, []byte("<?xml")):
+		return BodyXML
+	}
+	return BodyText
+}
+
+func prettyJSON(body []byte) (string, error) {
+	var b bytes.Buffer
+	if err := json.Indent(&b, bytes.TrimSpace(body), "", "  "); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// prettyXML re-indents an XML document.  Tokens are read raw and names are written with
+// their prefixes as they were, so namespace declarations are left alone.
+func prettyXML(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	var b bytes.Buffer
+	encoder := xml.NewEncoder(&b)
+	encoder.Indent("", "  ")
+
+	prefixed := func(name xml.Name) xml.Name {
+		if name.Space != "" {
+			return xml.Name{Local: name.Space + ":" + name.Local}
+		}
+		return name
+	}
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			t.Name = prefixed(t.Name)
+			attrs := make([]xml.Attr, len(t.Attr))
+			for i, attr := range t.Attr {
+				attrs[i] = xml.Attr{Name: prefixed(attr.Name), Value: attr.Value}
+			}
+			t.Attr = attrs
+			token = t
+		case xml.EndElement:
+			t.Name = prefixed(t.Name)
+			token = t
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue // the encoder indents
+			}
+		}
+		if err := encoder.EncodeToken(token); err != nil {
+			return "", err
+		}
+		if _, ok := token.(xml.ProcInst); ok {
+			// the encoder doesn't break the line after the declaration
+			if err := encoder.Flush(); err != nil {
+				return "", err
+			}
+			b.WriteByte('\n')
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// htmlVoidElements never have an end tag, so they don't indent what follows.
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// prettyHTML puts each tag on its own line, indented by how deeply it is nested.  Tags
+// and text are written as they were, only whitespace between them changes.
+func prettyHTML(body []byte) (string, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(body))
+	var b strings.Builder
+	depth := 0
+	writeLine := func(s string) {
+		b.WriteString(strings.Repeat("  ", depth))
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	for {
+		tokenType := tokenizer.Next()
+		// the raw text must be copied before the tokenizer is used again
+		raw := string(tokenizer.Raw())
+		switch tokenType {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return strings.TrimSuffix(b.String(), "\n"), nil
+			}
+			return "", tokenizer.Err()
+		case html.StartTagToken:
+			writeLine(raw)
+			if name, _ := tokenizer.TagName(); !htmlVoidElements[string(name)] {
+				depth++
+			}
+		case html.EndTagToken:
+			depth = max(depth-1, 0)
+			writeLine(raw)
+		case html.TextToken:
+			for _, line := range strings.Split(raw, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					writeLine(line)
+				}
+			}
+		default:
+			writeLine(raw)
+		}
+	}
+}
+
+// parseForm splits a url-encoded form into its fields, listing them one per line.
+func parseForm(body []byte) ([]FormField, string, error) {
+	var fields []FormField
+	var lines []string
+	for _, pair := range strings.Split(strings.TrimSpace(string(body)), "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			return nil, "", err
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return nil, "", err
+		}
+		fields = append(fields, FormField{Name: key, Value: value, Size: len(value), data: []byte(value)})
+		lines = append(lines, key+" = "+value)
+	}
+	return fields, strings.Join(lines, "\n"), nil
+}
+
+// parseMultipart splits a multipart body into its parts.  Text fields are listed with
+// their values, file parts with their name, type and size.
+func parseMultipart(body []byte, contentType string) ([]FormField, string, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, "", err
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, "", errors.New("no multipart boundary")
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	var fields []FormField
+	var lines []string
+	for {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, "", err
+		}
+		field := FormField{
+			Name:        part.FormName(),
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        len(data),
+			data:        data,
+		}
+		if field.IsFile() {
+			description := util.FormatBytes(int64(field.Size))
+			if field.ContentType != "" {
+				description = field.ContentType + ", " + description
+			}
+			if field.FileName != "" {
+				description = fmt.Sprintf("file %q (%s)", field.FileName, description)
+			} else {
+				description = "binary (" + description + ")"
+			}
+			lines = append(lines, field.Name+": "+description)
+		} else {
+			field.Value = string(data)
+			lines = append(lines, field.Name+" = "+field.Value)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, "", errors.New("no multipart parts")
+	}
+	return fields, strings.Join(lines, "\n"), nil
+}
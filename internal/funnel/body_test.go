@@ -0,0 +1,272 @@
+package funnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestFormatBody(t *testing.T) {
+	multipartBody := "--XYZ\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"Quarterly report\r\n" +
+		"--XYZ\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"report.pdf\"\r\n" +
+		"Content-Type: application/pdf\r\n\r\n" +
+		"%PDF-1.7\r\n" +
+		"--XYZ--\r\n"
+	var longText strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&longText, "%d ", i*i)
+	}
+	gzipped := compress(t, "gzip", longText.String())
+	truncated := gzipped[:len(gzipped)/2]
+	partial, _ := decodeContentEncoding([]byte(truncated), "gzip")
+	if len(partial) == 0 {
+		t.Fatal("Expected part of the truncated gzip body to decode")
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		headers http.Header
+		want    FormattedBody
+	}{
+		{
+			name:    "json",
+			body:    `{"id":7,"tags":["a","b"]}`,
+			headers: http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+			want:    FormattedBody{Format: BodyJSON, MediaType: "application/json", Text: "{\n  \"id\": 7,\n  \"tags\": [\n    \"a\",\n    \"b\"\n  ]\n}", Pretty: true},
+		},
+		{
+			name:    "json suffix",
+			body:    `{"type":"about:blank"}`,
+			headers: http.Header{"Content-Type": {"application/problem+json"}},
+			want:    FormattedBody{Format: BodyJSON, MediaType: "application/problem+json", Text: "{\n  \"type\": \"about:blank\"\n}", Pretty: true},
+		},
+		{
+			name: "json without a content type",
+			body: ` [1,2] `,
+			want: FormattedBody{Format: BodyJSON, Text: "[\n  1,\n  2\n]", Pretty: true},
+		},
+		{
+			name:    "truncated json",
+			body:    `{"id":7,"na`,
+			headers: http.Header{"Content-Type": {"application/json"}},
+			want:    FormattedBody{Format: BodyText, MediaType: "application/json", Text: `{"id":7,"na`},
+		},
+		{
+			name:    "xml",
+			body:    `<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body> <ok a="1">yes</ok></soap:Body></soap:Envelope>`,
+			headers: http.Header{"Content-Type": {"text/xml"}},
+			want: FormattedBody{Format: BodyXML, MediaType: "text/xml", Text: `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <ok a="1">yes</ok>
+  </soap:Body>
+</soap:Envelope>`, Pretty: true},
+		},
+		{
+			name:    "html",
+			body:    "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Hi</title></head><body><p class=\"x\">Hello\n   world</p><br></body></html>",
+			headers: http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+			want: FormattedBody{Format: BodyHTML, MediaType: "text/html", Text: `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>
+      Hi
+    </title>
+  </head>
+  <body>
+    <p class="x">
+      Hello
+      world
+    </p>
+    <br>
+  </body>
+</html>`, Pretty: true},
+		},
+		{
+			name:    "form",
+			body:    "name=Ada+Lovelace&role=admin&note=a%26b&empty=",
+			headers: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			want: FormattedBody{
+				Format: BodyForm, MediaType: "application/x-www-form-urlencoded", Pretty: true,
+				Text: "name = Ada Lovelace\nrole = admin\nnote = a&b\nempty = ",
+				Fields: []FormField{
+					{Name: "name", Value: "Ada Lovelace", Size: 12},
+					{Name: "role", Value: "admin", Size: 5},
+					{Name: "note", Value: "a&b", Size: 3},
+					{Name: "empty"},
+				},
+			},
+		},
+		{
+			name:    "multipart",
+			body:    multipartBody,
+			headers: http.Header{"Content-Type": {"multipart/form-data; boundary=XYZ"}},
+			want: FormattedBody{
+				Format: BodyMultipart, MediaType: "multipart/form-data", Pretty: true,
+				Text: "title = Quarterly report\nfile: file \"report.pdf\" (application/pdf, 8 B)",
+				Fields: []FormField{
+					{Name: "title", Value: "Quarterly report", Size: 16},
+					{Name: "file", FileName: "report.pdf", ContentType: "application/pdf", Size: 8},
+				},
+			},
+		},
+		{
+			name:    "image",
+			body:    "\x89PNG\x00",
+			headers: http.Header{"Content-Type": {"image/png"}},
+			want:    FormattedBody{Format: BodyImage, MediaType: "image/png", Text: "00000000  89 50 4e 47 00                                    |.PNG.|"},
+		},
+		{
+			name:    "binary",
+			body:    "\x00\x01",
+			headers: http.Header{"Content-Type": {"application/octet-stream"}},
+			want:    FormattedBody{Format: BodyBinary, MediaType: "application/octet-stream", Text: "00000000  00 01                                             |..|"},
+		},
+		{
+			name:    "gzip",
+			body:    compress(t, "gzip", `{"ok":true}`),
+			headers: http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}},
+			want:    FormattedBody{Format: BodyJSON, MediaType: "application/json", Encoding: "gzip", Text: "{\n  \"ok\": true\n}", Pretty: true},
+		},
+		{
+			name:    "deflate",
+			body:    compress(t, "deflate", "plain text"),
+			headers: http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"deflate"}},
+			want:    FormattedBody{Format: BodyText, MediaType: "text/plain", Encoding: "deflate", Text: "plain text"},
+		},
+		{
+			name:    "brotli",
+			body:    compress(t, "br", "<p>hi</p>"),
+			headers: http.Header{"Content-Type": {"text/html"}, "Content-Encoding": {"br"}},
+			want:    FormattedBody{Format: BodyHTML, MediaType: "text/html", Encoding: "br", Text: "<p>\n  hi\n</p>", Pretty: true},
+		},
+		{
+			name:    "truncated gzip",
+			body:    truncated,
+			headers: http.Header{"Content-Encoding": {"gzip"}},
+			want: FormattedBody{
+				Format: BodyText, Encoding: "gzip", Text: string(partial),
+				DecodeError: "only part of the gzip body could be decoded: unexpected EOF",
+			},
+		},
+		{
+			name:    "unsupported encoding",
+			body:    "zz",
+			headers: http.Header{"Content-Encoding": {"zstd"}},
+			want:    FormattedBody{Format: BodyText, Text: "zz", DecodeError: `the zstd body couldn't be decoded, it is shown as captured: unsupported encoding "zstd"`},
+		},
+		{
+			name: "empty",
+			want: FormattedBody{Format: BodyEmpty},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := tt.headers
+			if headers == nil {
+				headers = http.Header{}
+			}
+			got := FormatBody([]byte(tt.body), headers)
+			if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreUnexported(FormattedBody{}, FormField{}), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("FormatBody() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRawBody(t *testing.T) {
+	got := RawBody([]byte(compress(t, "gzip", `{"ok":true}`)), http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}})
+	if got.Format != BodyText || got.Text != `{"ok":true}` || got.Pretty {
+		t.Errorf("Expected the decoded body as it was sent, got %+v", got)
+	}
+}
+
+// compress encodes s with a Content-Encoding.
+func compress(t *testing.T, encoding string, s string) string {
+	t.Helper()
+	var b bytes.Buffer
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "br":
+		w = brotli.NewWriter(&b)
+	}
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestHandleFunnelBodyDownload(t *testing.T) {
+	s, f := newTestProxy(t, "http://localhost:8080", nil)
+	f.Requests.Add(CaptureRequestResponse{
+		ID:        "req",
+		Timestamp: time.Now(),
+		Request: CaptureRequest{
+			Method:  http.MethodPost,
+			URL:     "http://localhost:8080/upload",
+			Headers: http.Header{"Content-Type": {"multipart/form-data; boundary=XYZ"}},
+			Body:    []byte("--XYZ\r\nContent-Disposition: form-data; name=\"file\"; filename=\"../evil.html\"\r\nContent-Type: text/html\r\n\r\n<script>1</script>\r\n--XYZ--\r\n"),
+		},
+		Response: CaptureResponse{
+			StatusCode: 200,
+			Headers:    http.Header{"Content-Type": {"image/png"}, "Content-Encoding": {"gzip"}},
+			Body:       []byte(compress(t, "gzip", "\x89PNG\x00")),
+		},
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// images are decoded and shown inline
+	rec := get("/inspect/test-funnel/request/req/body/response/raw")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" || rec.Header().Get("Content-Disposition") != "" || rec.Body.String() != "\x89PNG\x00" {
+		t.Errorf("Expected the decoded image inline, got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+	rec = get("/inspect/test-funnel/request/req/body/response")
+	if !strings.Contains(rec.Body.String(), `<img class="body-image" src="/inspect/test-funnel/request/req/body/response/raw"`) {
+		t.Errorf("Expected the body view to show the image, got:\n%s", rec.Body.String())
+	}
+
+	// file parts are only ever downloaded
+	rec = get("/inspect/test-funnel/request/req/body/request/part/0")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/octet-stream" ||
+		rec.Header().Get("Content-Disposition") != `attachment; filename=evil.html` || rec.Body.String() != "<script>1</script>" {
+		t.Errorf("Expected the part as a download, got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+	rec = get("/inspect/test-funnel/request/req/body/request")
+	if !strings.Contains(rec.Body.String(), `href="/inspect/test-funnel/request/req/body/request/part/0"`) {
+		t.Errorf("Expected a download link for the file part, got:\n%s", rec.Body.String())
+	}
+
+	if rec = get("/inspect/test-funnel/request/req/body/request/part/1"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing part to return 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package funnel
+
+import (
+	"sync"
+)
+
+// CaptureBudget bounds the memory held by captured requests across every funnel.
+// When the captures grow past the limit the oldest ones are evicted first,
+// whichever funnel they belong to.  Captures still in progress count towards the
+// limit but aren't evicted, as they are updated until they finish.  Sizes are
+// approximate, see footprint.
+type CaptureBudget struct {
+	mu    sync.Mutex
+	limit int64 // bytes, <= 0 is unlimited
+	used  int64
+	lists map[*RequestList]struct{}
+}
+
+func NewCaptureBudget(limit int64) *CaptureBudget {
+	return &CaptureBudget{
+		limit: limit,
+		lists: make(map[*RequestList]struct{}),
+	}
+}
+
+// Limit returns the budget in bytes, <= 0 means unlimited.
+func (b *CaptureBudget) Limit() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit
+}
+
+// Used returns the bytes currently held by captures.
+func (b *CaptureBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// SetLimit changes the budget, evicting captures straight away if they no longer fit.
+func (b *CaptureBudget) SetLimit(limit int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+	b.enforce()
+}
+
+// attach starts accounting for a funnel's request list.
+func (b *CaptureBudget) attach(list *RequestList) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.lists[list]; ok {
+		return
+	}
+	b.lists[list] = struct{}{}
+
+	list.mu.Lock()
+	list.budget = b
+	b.used += list.bytes
+	list.mu.Unlock()
+
+	b.enforce()
+}
+
+// detach stops accounting for a request list, e.g. when its funnel is removed.
+func (b *CaptureBudget) detach(list *RequestList) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.lists[list]; !ok {
+		return
+	}
+	delete(b.lists, list)
+
+	list.mu.Lock()
+	list.budget = nil
+	b.used -= list.bytes
+	list.mu.Unlock()
+}
+
+// adjust records a change in size of an attached list.  It must not be called
+// with the list's lock held, as eviction may need it.
+func (b *CaptureBudget) adjust(delta int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += delta
+	b.enforce()
+}
+
+// enforce evicts the oldest finished captures until the budget is met, b.mu must
+// be held.
+func (b *CaptureBudget) enforce() {
+	for b.limit > 0 && b.used > b.limit {
+		var oldest *RequestList
+		var oldestNode *RequestListNode
+		for list := range b.lists {
+			list.mu.Lock()
+			if node := list.oldestFinished(); node != nil && (oldestNode == nil || node.Request.Timestamp.Before(oldestNode.Request.Timestamp)) {
+				oldest = list
+				oldestNode = node
+			}
+			list.mu.Unlock()
+		}
+		if oldest == nil {
+			return // nothing left to evict
+		}
+
+		oldest.mu.Lock()
+		if node := oldest.oldestFinished(); node != nil {
+			b.used -= oldest.remove(node)
+		}
+		oldest.mu.Unlock()
+	}
+}
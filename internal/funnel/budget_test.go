@@ -0,0 +1,151 @@
+package funnel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newSizedRequest returns a capture with a response body of size bytes, taken at the given time.
+func newSizedRequest(id string, timestamp time.Time, size int) CaptureRequestResponse {
+	return CaptureRequestResponse{
+		ID:        id,
+		Timestamp: timestamp,
+		Response:  CaptureResponse{Body: []byte(strings.Repeat("x", size))},
+	}
+}
+
+// listIDs returns the IDs in the list, newest first.
+func listIDs(list *RequestList) []string {
+	var ids []string
+	for node := list.Head; node != nil; node = node.Next {
+		ids = append(ids, node.Request.ID)
+	}
+	return ids
+}
+
+func TestCaptureBudget_EvictsOldestAcrossLists(t *testing.T) {
+	start := time.Now()
+	first := newSizedRequest("a1", start, 1000)
+	size := first.footprint()
+
+	budget := NewCaptureBudget(3 * size)
+	a, b := NewRequestList(10), NewRequestList(10)
+	budget.attach(a)
+	budget.attach(b)
+
+	a.Add(first)
+	b.Add(newSizedRequest("b1", start.Add(1*time.Second), 1000))
+	a.Add(newSizedRequest("a2", start.Add(2*time.Second), 1000))
+	if budget.Used() != 3*size {
+		t.Fatalf("Expected %d bytes used, got %d", 3*size, budget.Used())
+	}
+
+	// over budget, the oldest capture goes even though it belongs to the other list
+	b.Add(newSizedRequest("b2", start.Add(3*time.Second), 1000))
+	if diff := cmp.Diff([]string{"a2"}, listIDs(a)); diff != "" {
+		t.Errorf("List a mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"b2", "b1"}, listIDs(b)); diff != "" {
+		t.Errorf("List b mismatch (-want +got):\n%s", diff)
+	}
+	if budget.Used() != 3*size || a.Bytes()+b.Bytes() != budget.Used() {
+		t.Errorf("Expected %d bytes used, got %d (lists %d + %d)", 3*size, budget.Used(), a.Bytes(), b.Bytes())
+	}
+
+	// shrinking the budget evicts straight away
+	budget.SetLimit(size)
+	if diff := cmp.Diff([]string{"b2"}, append(listIDs(a), listIDs(b)...)); diff != "" {
+		t.Errorf("Captures after SetLimit mismatch (-want +got):\n%s", diff)
+	}
+
+	// a capture bigger than the whole budget doesn't stay
+	a.Add(newSizedRequest("huge", start.Add(4*time.Second), 5000))
+	if a.Length != 0 || b.Length != 0 || budget.Used() != 0 {
+		t.Errorf("Expected everything evicted, got %d + %d captures using %d bytes", a.Length, b.Length, budget.Used())
+	}
+}
+
+func TestCaptureBudget_KeepsCapturesInProgress(t *testing.T) {
+	start := time.Now()
+	first := newSizedRequest("old", start, 1000)
+	size := first.footprint()
+
+	budget := NewCaptureBudget(3 * size)
+	list := NewRequestList(10)
+	budget.attach(list)
+
+	live := newSizedRequest("live", start.Add(-time.Second), 0)
+	live.InProgress = true
+	list.Add(live)
+	list.Add(first)
+	list.Add(newSizedRequest("new", start.Add(time.Second), 1000))
+
+	// the live capture is the oldest and grows past the budget, the finished ones go instead
+	list.Update("live", func(c *CaptureRequestResponse) {
+		c.Response.Body = []byte(strings.Repeat("x", 2500))
+	})
+	if diff := cmp.Diff([]string{"live"}, listIDs(list)); diff != "" {
+		t.Errorf("Captures mismatch (-want +got):\n%s", diff)
+	}
+	if budget.Used() != list.Bytes() {
+		t.Errorf("Expected %d bytes used, got %d", list.Bytes(), budget.Used())
+	}
+
+	// once finished it is evicted like any other
+	list.Update("live", func(c *CaptureRequestResponse) {
+		c.InProgress = false
+		c.Response.Body = []byte(strings.Repeat("x", 5000))
+	})
+	if list.Length != 0 || budget.Used() != 0 {
+		t.Errorf("Expected everything evicted, got %d captures using %d bytes", list.Length, budget.Used())
+	}
+}
+
+func TestCaptureBudget_Detach(t *testing.T) {
+	budget := NewCaptureBudget(0)
+	list := NewRequestList(10)
+	list.Add(newSizedRequest("r1", time.Now(), 100))
+
+	// captures made before attaching are accounted for
+	budget.attach(list)
+	if budget.Used() != list.Bytes() || list.Bytes() == 0 {
+		t.Fatalf("Expected %d bytes used, got %d", list.Bytes(), budget.Used())
+	}
+
+	budget.detach(list)
+	if budget.Used() != 0 {
+		t.Errorf("Expected detaching to release the list's bytes, %d still used", budget.Used())
+	}
+	list.Add(newSizedRequest("r2", time.Now(), 100))
+	if budget.Used() != 0 {
+		t.Errorf("Expected a detached list not to be accounted, %d used", budget.Used())
+	}
+}
+
+func TestRequestList_SetMaxLength(t *testing.T) {
+	budget := NewCaptureBudget(0)
+	list := NewRequestList(5)
+	budget.attach(list)
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		list.Add(newSizedRequest(id, time.Now(), 100))
+	}
+
+	list.SetMaxLength(2)
+	if diff := cmp.Diff([]string{"r4", "r3"}, listIDs(list)); diff != "" {
+		t.Errorf("List mismatch (-want +got):\n%s", diff)
+	}
+	if list.Length != 2 || list.MaxLength() != 2 {
+		t.Errorf("Expected length 2 and max length 2, got %d and %d", list.Length, list.MaxLength())
+	}
+	if budget.Used() != list.Bytes() {
+		t.Errorf("Expected the budget to follow the list, %d used but the list holds %d", budget.Used(), list.Bytes())
+	}
+
+	list.Add(newSizedRequest("r5", time.Now(), 100))
+	if diff := cmp.Diff([]string{"r5", "r4"}, listIDs(list)); diff != "" {
+		t.Errorf("List after add mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,137 @@
+package funnel
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// captureBuffer keeps the first limit bytes written to it while counting every
+// byte, so bodies can be streamed through the proxy without being held in memory.
+type captureBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int64 // max bytes to keep, <= 0 keeps nothing
+	total int64 // total bytes written
+}
+
+func newCaptureBuffer(limit int64) *captureBuffer {
+	return &captureBuffer{limit: limit}
+}
+
+// Write never fails, so it is safe to use as the writer of an io.TeeReader.
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.total += int64(len(p))
+	if remaining := c.limit - int64(c.buf.Len()); remaining > 0 {
+		if int64(len(p)) > remaining {
+			c.buf.Write(p[:remaining])
+		} else {
+			c.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the captured bytes.
+func (c *captureBuffer) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	return bytes.Clone(c.buf.Bytes())
+}
+
+// Total returns the number of bytes written, captured or not.
+func (c *captureBuffer) Total() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// Truncated reports whether more bytes were written than were captured.
+func (c *captureBuffer) Truncated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total > int64(c.buf.Len())
+}
+
+// teeReadCloser copies everything read from an io.ReadCloser into a writer that never fails.
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func newTeeReadCloser(rc io.ReadCloser, capture io.Writer) io.ReadCloser {
+	return teeReadCloser{Reader: io.TeeReader(rc, capture), Closer: rc}
+}
+
+// triggerWriter calls itself for every write, discarding the bytes.
+type triggerWriter func()
+
+func (t triggerWriter) Write(p []byte) (int, error) {
+	t()
+	return len(p), nil
+}
+
+// updateThrottle runs fn at most once per interval, however often it is
+// triggered.  A trigger inside the interval schedules a trailing run, so the
+// last change is never lost.
+type updateThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	fn       func()
+	timer    *time.Timer
+	last     time.Time
+	stopped  bool
+	running  sync.WaitGroup // the run in progress, if any
+}
+
+func newUpdateThrottle(interval time.Duration, fn func()) *updateThrottle {
+	return &updateThrottle{interval: interval, fn: fn}
+}
+
+func (t *updateThrottle) trigger() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped || t.timer != nil {
+		return // a run is already scheduled
+	}
+	wait := max(t.interval-time.Since(t.last), 0)
+	t.timer = time.AfterFunc(wait, func() {
+		t.mu.Lock()
+		t.timer = nil
+		if t.stopped {
+			// fired as stop was called, too late to be cancelled
+			t.mu.Unlock()
+			return
+		}
+		t.last = time.Now()
+		t.running.Add(1)
+		t.mu.Unlock()
+
+		defer t.running.Done()
+		t.fn()
+	})
+}
+
+// stop cancels any scheduled run and waits for one in progress to finish, so
+// nothing it does lands after what the caller does next.  Later triggers are
+// ignored.
+func (t *updateThrottle) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.mu.Unlock()
+
+	t.running.Wait()
+}
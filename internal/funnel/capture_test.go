@@ -0,0 +1,91 @@
+package funnel
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int64
+		writes        []string
+		wantBytes     string
+		wantTotal     int64
+		wantTruncated bool
+	}{
+		{name: "Under limit", limit: 10, writes: []string{"abc", "def"}, wantBytes: "abcdef", wantTotal: 6},
+		{name: "Exactly at limit", limit: 6, writes: []string{"abc", "def"}, wantBytes: "abcdef", wantTotal: 6},
+		{name: "Write crosses limit", limit: 4, writes: []string{"abc", "def"}, wantBytes: "abcd", wantTotal: 6, wantTruncated: true},
+		{name: "Writes after limit", limit: 3, writes: []string{"abc", "def", "ghi"}, wantBytes: "abc", wantTotal: 9, wantTruncated: true},
+		{name: "Zero limit", limit: 0, writes: []string{"abc"}, wantBytes: "", wantTotal: 3, wantTruncated: true},
+		{name: "Nothing written", limit: 5, wantBytes: "", wantTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCaptureBuffer(tt.limit)
+			for _, w := range tt.writes {
+				n, err := c.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v; want %d, nil", w, n, err, len(w))
+				}
+			}
+			if got := string(c.Bytes()); got != tt.wantBytes {
+				t.Errorf("Bytes() = %q, want %q", got, tt.wantBytes)
+			}
+			if got := c.Total(); got != tt.wantTotal {
+				t.Errorf("Total() = %d, want %d", got, tt.wantTotal)
+			}
+			if got := c.Truncated(); got != tt.wantTruncated {
+				t.Errorf("Truncated() = %v, want %v", got, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestTeeReadCloser(t *testing.T) {
+	c := newCaptureBuffer(5)
+	rc := newTeeReadCloser(io.NopCloser(strings.NewReader("hello world")), c)
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("Reader returned %q, want the full body", data)
+	}
+	if string(c.Bytes()) != "hello" || c.Total() != 11 || !c.Truncated() {
+		t.Errorf("Unexpected capture %q, total %d, truncated %v", c.Bytes(), c.Total(), c.Truncated())
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("Close() unexpected error: %v", err)
+	}
+}
+
+func TestUpdateThrottle_StopWaitsForRun(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	throttle := newUpdateThrottle(0, func() {
+		close(started)
+		<-release
+	})
+	throttle.trigger()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		throttle.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Expected stop to wait for the run in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-stopped
+	throttle.trigger() // ignored, fn would close started again
+}
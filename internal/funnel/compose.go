@@ -0,0 +1,140 @@
+package funnel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ComposedRequest is a request written or edited by hand, to be sent to a
+// funnel's local target as though it came through the funnel.
+type ComposedRequest struct {
+	Method  string
+	Path    string // relative to the local target, with any query, e.g. /hooks?id=1
+	Headers http.Header
+	Body    []byte
+}
+
+// NewComposedRequest returns the incoming request a capture was made from, with
+// its path relative to the funnel's local target, ready to be edited or resent.
+func NewComposedRequest(funnel Funnel, capture CaptureRequestResponse) (ComposedRequest, error) {
+	targetURL, err := url.Parse(funnel.LocalTarget())
+	if err != nil {
+		return ComposedRequest{}, ErrTargetURLParse
+	}
+	capturedURL, err := url.Parse(capture.Request.URL)
+	if err != nil {
+		return ComposedRequest{}, err
+	}
+
+	// undo what the proxy did to the path and query on the way through
+	path := "/" + strings.TrimPrefix(strings.TrimPrefix(capturedURL.Path, targetURL.Path), "/")
+	query := capturedURL.RawQuery
+	if targetURL.RawQuery != "" {
+		query = strings.TrimPrefix(strings.TrimPrefix(query, targetURL.RawQuery), "&")
+	}
+	if query != "" {
+		path += "?" + query
+	}
+
+	headers := capture.Request.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	// worked out from the body when the request is sent
+	headers.Del("Content-Length")
+
+	return ComposedRequest{
+		Method:  capture.Request.Method,
+		Path:    path,
+		Headers: headers,
+		Body:    capture.Request.Body,
+	}, nil
+}
+
+// Send sends a composed request to the funnel's local target and returns its
+// capture.  basedOn is the ID of the capture the request was edited from, if any.
+func (s *HttpServer) Send(ctx context.Context, funnelID string, req ComposedRequest, basedOn string) (*CaptureRequestResponse, error) {
+	funnel, err := s.liveFunnel(funnelID)
+	if err != nil {
+		return nil, err
+	}
+	return s.send(ctx, funnel, req, CaptureRequestResponse{ReplayOf: basedOn, Composed: true})
+}
+
+// httpRequest builds the request as it would arrive from the funnel, returning it
+// with its path relative to the funnel's local target.
+func (c ComposedRequest) httpRequest(ctx context.Context, funnel Funnel) (*http.Request, string, error) {
+	method := strings.TrimSpace(c.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	target, err := url.Parse(strings.TrimSpace(c.Path))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid path: %w", err)
+	}
+	if target.Scheme != "" || target.Host != "" {
+		return nil, "", ErrComposeAbsolutePath
+	}
+	rest := strings.TrimPrefix(target.Path, "/")
+
+	reqURL := &url.URL{Path: HttpServerPath + funnel.ID() + "/" + rest, RawQuery: target.RawQuery}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), bytes.NewReader(c.Body))
+	if err != nil {
+		return nil, "", err
+	}
+	// no RemoteAddr, so the proxy leaves any X-Forwarded-For from the original alone
+	req.Header = c.Headers.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	return req, rest, nil
+}
+
+// ParseHeaderLines parses headers written one per line as "Name: value", as they
+// are edited in the composer.  Blank lines are ignored.
+func ParseHeaderLines(text string) (http.Header, error) {
+	headers := http.Header{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !validHeaderName(name) {
+			return nil, fmt.Errorf("line %d: expected a header as \"Name: value\", got %q", line, entry)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, scanner.Err()
+}
+
+// FormatHeaderLines writes headers one per line, sorted by name, in the form read
+// by ParseHeaderLines.
+func FormatHeaderLines(headers http.Header) string {
+	var b strings.Builder
+	for _, entry := range headerEntries(headers) {
+		fmt.Fprintf(&b, "%s: %s\n", entry.Name, entry.Value)
+	}
+	return b.String()
+}
+
+// validHeaderName reports whether name is a token, as header names must be.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
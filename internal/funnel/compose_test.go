@@ -0,0 +1,208 @@
+package funnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseHeaderLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    http.Header
+		wantErr bool
+	}{
+		{
+			name: "headers",
+			text: "content-type: application/json\n\nX-Hub-Signature:sha256=abc\r\nSet-Cookie: a=1\nSet-Cookie: b=2\n",
+			want: http.Header{
+				"Content-Type":    {"application/json"},
+				"X-Hub-Signature": {"sha256=abc"},
+				"Set-Cookie":      {"a=1", "b=2"},
+			},
+		},
+		{name: "colon in value", text: "Referer: https://example.com:8443/", want: http.Header{"Referer": {"https://example.com:8443/"}}},
+		{name: "empty value", text: "X-Empty:", want: http.Header{"X-Empty": {""}}},
+		{name: "empty", text: "  \n", want: http.Header{}},
+		{name: "no colon", text: "Content-Type application/json", wantErr: true},
+		{name: "space in name", text: "Content Type: text/plain", wantErr: true},
+		{name: "no name", text: ": value", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHeaderLines(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHeaderLines() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseHeaderLines() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormatHeaderLines(t *testing.T) {
+	headers := http.Header{"X-B": {"2"}, "X-A": {"1", "3"}}
+	text := FormatHeaderLines(headers)
+	if want := "X-A: 1\nX-A: 3\nX-B: 2\n"; text != want {
+		t.Errorf("FormatHeaderLines() = %q, want %q", text, want)
+	}
+	parsed, err := ParseHeaderLines(text)
+	if err != nil {
+		t.Fatalf("ParseHeaderLines() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(headers, parsed); diff != "" {
+		t.Errorf("Round trip mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewComposedRequest(t *testing.T) {
+	f := Funnel{HTTPFunnel: &HTTPFunnel{id: "f", localTarget: "http://localhost:8080/api?v=2"}}
+	capture := CaptureRequestResponse{
+		Request: CaptureRequest{
+			Method:  http.MethodPut,
+			URL:     "http://localhost:8080/api/items/7?v=2&force=true",
+			Headers: http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"5"}},
+			Body:    []byte("hello"),
+		},
+	}
+
+	got, err := NewComposedRequest(f, capture)
+	if err != nil {
+		t.Fatalf("NewComposedRequest() unexpected error: %v", err)
+	}
+	// the path is as the funnel received it, the length is worked out again when sent
+	want := ComposedRequest{
+		Method:  http.MethodPut,
+		Path:    "/items/7?force=true",
+		Headers: http.Header{"Content-Type": {"text/plain"}},
+		Body:    []byte("hello"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NewComposedRequest() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var received receivedRequest
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = receivedRequest{Method: r.Method, URI: r.RequestURI, Body: string(body), Signature: r.Header.Get("X-Signature"), ContentLength: r.ContentLength}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL, nil)
+	f.Requests.Add(CaptureRequestResponse{ID: "original", Timestamp: time.Now()})
+
+	sent, err := s.Send(context.Background(), "test-funnel", ComposedRequest{
+		Method:  http.MethodPatch,
+		Path:    "items/7?dry_run=1",
+		Headers: http.Header{"X-Signature": {"edited"}},
+		Body:    []byte(`{"name":"edited"}`),
+	}, "original")
+	if err != nil {
+		t.Fatalf("Send() unexpected error: %v", err)
+	}
+
+	want := receivedRequest{Method: http.MethodPatch, URI: "/items/7?dry_run=1", Body: `{"name":"edited"}`, Signature: "edited", ContentLength: 17}
+	if diff := cmp.Diff(want, received); diff != "" {
+		t.Errorf("Received request mismatch (-want +got):\n%s", diff)
+	}
+	if !sent.Composed || sent.ReplayOf != "original" || sent.Origin() != "edited" || sent.StatusCode() != http.StatusCreated {
+		t.Errorf("Unexpected capture: composed %v, replay of %q, origin %q, status %d", sent.Composed, sent.ReplayOf, sent.Origin(), sent.StatusCode())
+	}
+
+	_, err = s.Send(context.Background(), "test-funnel", ComposedRequest{Method: http.MethodGet, Path: "http://example.com/"}, "")
+	if !errors.Is(err, ErrComposeAbsolutePath) {
+		t.Errorf("Send() with an absolute URL error = %v, want %v", err, ErrComposeAbsolutePath)
+	}
+	_, err = s.Send(context.Background(), "test-funnel", ComposedRequest{Method: "BAD METHOD", Path: "/"}, "")
+	if err == nil {
+		t.Error("Send() with an invalid method, expected an error")
+	}
+	if f.Requests.Length != 2 {
+		t.Errorf("Expected requests that weren't sent not to be captured, the list holds %d", f.Requests.Length)
+	}
+}
+
+func TestHandleFunnelCompose(t *testing.T) {
+	var receivedBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receivedBody = string(body)
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL, nil)
+	f.Requests.Add(CaptureRequestResponse{
+		ID:        "original",
+		Timestamp: time.Now(),
+		Request: CaptureRequest{
+			Method:  http.MethodPost,
+			URL:     backend.URL + "/hooks?source=github",
+			Headers: http.Header{"X-Event": {"push"}},
+			Body:    []byte(`{"ref":"main"}`),
+		},
+	})
+
+	// the form is filled in from the capture
+	rec := httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel/compose?request=original", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, want := range []string{`value="POST"`, `value="/hooks?source=github"`, "X-Event: push", `{&#34;ref&#34;:&#34;main&#34;}`, `name="based_on" value="original"`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Expected the form to contain %s, got:\n%s", want, rec.Body.String())
+		}
+	}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := newInspectorRequest(http.MethodPost, "/inspect/test-funnel/compose", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.handleFunnelInspect(rec, req)
+		return rec
+	}
+
+	// other sites can't use the browser to send requests to the target
+	req := newInspectorRequest(http.MethodPost, "/inspect/test-funnel/compose", strings.NewReader(url.Values{"method": {"GET"}, "path": {"/"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	s.handleFunnelInspect(rec, req)
+	if rec.Code != http.StatusForbidden || f.Requests.Length != 1 {
+		t.Errorf("Expected a post from another site to be refused, got %d with %d requests captured", rec.Code, f.Requests.Length)
+	}
+
+	// mistakes are shown with the form, keeping what was written
+	rec = post(url.Values{"method": {"POST"}, "path": {"/hooks"}, "headers": {"not a header"}, "body": {"kept"}})
+	if !strings.Contains(rec.Body.String(), "expected a header") || !strings.Contains(rec.Body.String(), ">kept</textarea>") {
+		t.Errorf("Expected the form with an error, got:\n%s", rec.Body.String())
+	}
+	if f.Requests.Length != 1 {
+		t.Fatalf("Expected nothing to be sent, the list holds %d requests", f.Requests.Length)
+	}
+
+	rec = post(url.Values{"based_on": {"original"}, "method": {"POST"}, "path": {"/hooks"}, "headers": {"X-Event: push\r\n"}, "body": {"line one\r\nline two"}})
+	if rec.Code != http.StatusOK || f.Requests.Length != 2 {
+		t.Fatalf("Expected the request to be sent, got %d with %d requests captured: %s", rec.Code, f.Requests.Length, rec.Body.String())
+	}
+	if receivedBody != "line one\nline two" {
+		t.Errorf("Expected the browser's line breaks to be normalized, the target received %q", receivedBody)
+	}
+	sent := f.Requests.Head.Request
+	if sent.Origin() != "edited" || !strings.Contains(rec.Body.String(), sent.ID) || !strings.Contains(rec.Body.String(), "Edited from:") {
+		t.Errorf("Expected the edited request's details, got origin %q and:\n%s", sent.Origin(), rec.Body.String())
+	}
+}
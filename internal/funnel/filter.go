@@ -0,0 +1,320 @@
+package funnel
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter narrows down the request log.  It is parsed from an expression of terms
+// separated by spaces, all of which must match:
+//
+//	status:5xx status:404 status:>=400   the response status, a class, code or comparison
+//	method:POST method:put,patch         the method, any of a comma separated list
+//	path:/webhooks/*                     the path, * matching anything, otherwise contained in it
+//	body:"invoice"                       the request or response body contains the text
+//	header:X-Event header:X-Event=push   a request or response header is present, or contains the text
+//	type:json                            the response type, as shown in the request log
+//	is:error is:replay is:websocket      the request failed, didn't come through the funnel (is:replay,
+//	                                     is:edited, is:composed), or is a websocket, event stream or live
+//	invoice                              the path or query contains the text
+//
+// Text is matched without regard to case and values with spaces can be quoted.
+// A term starting with - excludes the requests it matches.
+type Filter struct {
+	expr  string
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	negate bool
+	match  func(c *CaptureRequestResponse) bool
+}
+
+// ParseFilter parses a filter expression, an empty expression matches everything.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := splitFilterTerms(expr)
+	if err != nil {
+		return Filter{}, err
+	}
+	f := Filter{expr: strings.TrimSpace(expr)}
+	for _, token := range tokens {
+		term, err := parseFilterTerm(token)
+		if err != nil {
+			return Filter{}, err
+		}
+		f.terms = append(f.terms, term)
+	}
+	return f, nil
+}
+
+// String returns the expression the filter was parsed from.
+func (f Filter) String() string {
+	return f.expr
+}
+
+// Empty reports whether the filter matches everything.
+func (f Filter) Empty() bool {
+	return len(f.terms) == 0
+}
+
+// Match reports whether the capture matches every term of the filter.
+func (f Filter) Match(c *CaptureRequestResponse) bool {
+	for _, term := range f.terms {
+		if term.match(c) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the captures that match, in the same order.
+func (f Filter) Apply(captures []CaptureRequestResponse) []CaptureRequestResponse {
+	if f.Empty() {
+		return captures
+	}
+	matched := make([]CaptureRequestResponse, 0, len(captures))
+	for i := range captures {
+		if f.Match(&captures[i]) {
+			matched = append(matched, captures[i])
+		}
+	}
+	return matched
+}
+
+// filterToken is a term as written, before its value is interpreted.
+type filterToken struct {
+	negate bool
+	key    string // empty for bare text
+	value  string
+}
+
+// splitFilterTerms splits the expression on spaces outside quotes, separating each
+// term's key from its value and removing the quotes.
+func splitFilterTerms(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var token filterToken
+		if runes[i] == '-' {
+			token.negate = true
+			i++
+		}
+
+		var text strings.Builder
+		quoted := false
+		for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+			switch {
+			case runes[i] == '"':
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end == len(runes) {
+					return nil, fmt.Errorf("unterminated quote in %q", expr)
+				}
+				text.WriteString(string(runes[i+1 : end]))
+				i = end
+				quoted = true
+			case runes[i] == ':' && token.key == "" && !quoted && text.Len() > 0:
+				token.key = strings.ToLower(text.String())
+				text.Reset()
+			default:
+				text.WriteRune(runes[i])
+			}
+		}
+		token.value = text.String()
+		if token.key == "" && token.value == "" {
+			if token.negate {
+				return nil, fmt.Errorf("nothing to exclude after -")
+			}
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func parseFilterTerm(token filterToken) (filterTerm, error) {
+	term := filterTerm{negate: token.negate}
+	value := token.value
+	lower := strings.ToLower(value)
+	if token.key != "" && value == "" {
+		return term, fmt.Errorf("%s: needs a value", token.key)
+	}
+
+	switch token.key {
+	case "":
+		term.match = func(c *CaptureRequestResponse) bool {
+			return containsFold(requestPathAndQuery(c), lower)
+		}
+
+	case "status":
+		match, err := parseStatusMatch(value)
+		if err != nil {
+			return term, err
+		}
+		term.match = func(c *CaptureRequestResponse) bool {
+			return match(c.Response.StatusCode)
+		}
+
+	case "method":
+		methods := strings.Split(strings.ToUpper(value), ",")
+		term.match = func(c *CaptureRequestResponse) bool {
+			for _, method := range methods {
+				if strings.ToUpper(c.Request.Method) == method {
+					return true
+				}
+			}
+			return false
+		}
+
+	case "path":
+		term.match = func(c *CaptureRequestResponse) bool {
+			path := strings.ToLower(c.Path())
+			if strings.Contains(lower, "*") {
+				return globMatch(lower, path)
+			}
+			return strings.Contains(path, lower)
+		}
+
+	case "body":
+		needle := []byte(lower)
+		term.match = func(c *CaptureRequestResponse) bool {
+			return bytes.Contains(bytes.ToLower(searchableBody(c.Request.Body, c.Request.Headers)), needle) ||
+				bytes.Contains(bytes.ToLower(searchableBody(c.Response.Body, c.Response.Headers)), needle)
+		}
+
+	case "header":
+		name, text, hasText := strings.Cut(value, "=")
+		text = strings.ToLower(text)
+		term.match = func(c *CaptureRequestResponse) bool {
+			for _, values := range [][]string{c.Request.Headers.Values(name), c.Response.Headers.Values(name)} {
+				for _, v := range values {
+					if !hasText || containsFold(v, text) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+
+	case "type":
+		term.match = func(c *CaptureRequestResponse) bool {
+			return containsFold(c.Type(), lower)
+		}
+
+	case "is":
+		match, ok := filterStates[lower]
+		if !ok {
+			return term, fmt.Errorf("is:%s: expected one of error, replay, edited, composed, websocket, sse or live", value)
+		}
+		term.match = match
+
+	default:
+		return term, fmt.Errorf("unknown filter %q, expected status, method, path, body, header, type or is", token.key)
+	}
+	return term, nil
+}
+
+// filterStates are the values of is: terms.
+var filterStates = map[string]func(c *CaptureRequestResponse) bool{
+	"error":     func(c *CaptureRequestResponse) bool { return c.Error != "" },
+	"replay":    func(c *CaptureRequestResponse) bool { return c.Origin() == "replay" },
+	"edited":    func(c *CaptureRequestResponse) bool { return c.Origin() == "edited" },
+	"composed":  func(c *CaptureRequestResponse) bool { return c.Composed },
+	"websocket": func(c *CaptureRequestResponse) bool { return c.WebSocket != nil },
+	"sse":       func(c *CaptureRequestResponse) bool { return c.ServerSentEvents != nil },
+	"live":      func(c *CaptureRequestResponse) bool { return c.InProgress },
+}
+
+// parseStatusMatch parses a status term's value: a code, a class like 5xx, or a
+// comparison like >=400.
+func parseStatusMatch(value string) (func(int) bool, error) {
+	lower := strings.ToLower(value)
+	if len(lower) == 3 && strings.HasSuffix(lower, "xx") && lower[0] >= '1' && lower[0] <= '5' {
+		class := int(lower[0]-'0') * 100
+		return func(code int) bool { return code >= class && code < class+100 }, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if rest, ok := strings.CutPrefix(lower, op); ok {
+			n, err := strconv.Atoi(rest)
+			if err != nil {
+				return nil, fmt.Errorf("status:%s: expected a status code after %s", value, op)
+			}
+			switch op {
+			case ">=":
+				return func(code int) bool { return code >= n }, nil
+			case "<=":
+				return func(code int) bool { return code <= n }, nil
+			case ">":
+				return func(code int) bool { return code > n }, nil
+			default:
+				return func(code int) bool { return code < n }, nil
+			}
+		}
+	}
+
+	n, err := strconv.Atoi(lower)
+	if err != nil {
+		return nil, fmt.Errorf("status:%s: expected a code like 404, a class like 5xx or a comparison like >=400", value)
+	}
+	return func(code int) bool { return code == n }, nil
+}
+
+// requestPathAndQuery returns the path of the request with its query, if any.
+func requestPathAndQuery(c *CaptureRequestResponse) string {
+	if _, query, ok := strings.Cut(c.Request.URL, "?"); ok {
+		return c.Path() + "?" + query
+	}
+	return c.Path()
+}
+
+// containsFold reports whether s contains the lower case substr, without regard to case.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), substr)
+}
+
+// globMatch reports whether s matches pattern, where * matches any run of
+// characters, including slashes, and the pattern must match the whole of s.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(s, part)
+		}
+		idx := strings.Index(s, part)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+	return s == ""
+}
+
+// searchableBody returns the body with its Content-Encoding removed, so compressed
+// bodies can be searched, or as captured if none of it can be decoded.
+func searchableBody(body []byte, header http.Header) []byte {
+	contentEncoding := header.Get("Content-Encoding")
+	if contentEncoding == "" || len(body) == 0 {
+		return body
+	}
+	// a truncated body decodes as far as it was captured
+	if decoded, err := decodeContentEncoding(body, contentEncoding); err == nil || len(decoded) > 0 {
+		return decoded
+	}
+	return body
+}
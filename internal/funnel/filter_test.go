@@ -0,0 +1,156 @@
+package funnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilter(t *testing.T) {
+	captures := []CaptureRequestResponse{
+		{
+			ID:       "invoice",
+			Request:  CaptureRequest{Method: http.MethodPost, URL: "http://localhost:8080/webhooks/stripe?live=true", Headers: http.Header{"X-Event": {"invoice.paid"}}, Body: []byte(`{"type":"Invoice"}`)},
+			Response: CaptureResponse{StatusCode: 500, Headers: http.Header{"Content-Type": {"application/json"}}},
+		},
+		{
+			ID:       "page",
+			Request:  CaptureRequest{Method: http.MethodGet, URL: "http://localhost:8080/index.html"},
+			Response: CaptureResponse{StatusCode: 200, Headers: http.Header{"Content-Type": {"text/html"}}, Body: []byte("<h1>Invoices</h1>")},
+		},
+		{
+			ID:       "missing",
+			Request:  CaptureRequest{Method: http.MethodPut, URL: "http://localhost:8080/webhooks/github"},
+			Response: CaptureResponse{StatusCode: 404},
+			ReplayOf: "page",
+		},
+		{
+			ID:        "down",
+			Request:   CaptureRequest{Method: http.MethodGet, URL: "http://localhost:8080/health"},
+			Response:  CaptureResponse{StatusCode: 502},
+			Error:     "connection refused",
+			ErrorKind: ProxyErrorRefused,
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{expr: "", want: []string{"invoice", "page", "missing", "down"}},
+		{expr: "status:5xx", want: []string{"invoice", "down"}},
+		{expr: "status:404", want: []string{"missing"}},
+		{expr: "status:>=404", want: []string{"invoice", "missing", "down"}},
+		{expr: "status:<404", want: []string{"page"}},
+		{expr: "method:post", want: []string{"invoice"}},
+		{expr: "method:PUT,post", want: []string{"invoice", "missing"}},
+		{expr: "path:/webhooks/*", want: []string{"invoice", "missing"}},
+		{expr: "path:/webhooks/*e", want: []string{"invoice"}},
+		{expr: "path:hook", want: []string{"invoice", "missing"}},
+		{expr: `body:"invoice"`, want: []string{"invoice", "page"}},
+		{expr: `body:"h1>invoices"`, want: []string{"page"}},
+		{expr: "header:x-event", want: []string{"invoice"}},
+		{expr: "header:content-type=html", want: []string{"page"}},
+		{expr: "type:json", want: []string{"invoice"}},
+		{expr: "is:error", want: []string{"down"}},
+		{expr: "is:replay", want: []string{"missing"}},
+		{expr: "live=true", want: []string{"invoice"}},
+		{expr: "WEBHOOKS", want: []string{"invoice", "missing"}},
+		{expr: "-path:/webhooks/*", want: []string{"page", "down"}},
+		{expr: `status:5xx method:POST path:/webhooks/* body:"invoice"`, want: []string{"invoice"}},
+		{expr: "method:get -is:error", want: []string{"page"}},
+		{expr: `body:"no such text"`, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q) unexpected error: %v", tt.expr, err)
+			}
+			got := []string{}
+			for _, c := range f.Apply(captures) {
+				got = append(got, c.ID)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Apply() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFilter_EncodedBody(t *testing.T) {
+	gzipped := []byte(compress(t, "gzip", `{"report":"Quarterly"}`))
+	captures := []CaptureRequestResponse{
+		{
+			ID:       "gzipped",
+			Request:  CaptureRequest{Method: http.MethodGet, URL: "http://localhost:8080/report"},
+			Response: CaptureResponse{StatusCode: 200, Headers: http.Header{"Content-Encoding": {"gzip"}}, Body: gzipped},
+		},
+		{
+			ID:       "undecodable",
+			Request:  CaptureRequest{Method: http.MethodPost, URL: "http://localhost:8080/upload", Headers: http.Header{"Content-Encoding": {"zstd"}}, Body: []byte("quarterly, not compressed")},
+			Response: CaptureResponse{StatusCode: 204},
+		},
+	}
+
+	f, err := ParseFilter("body:quarterly")
+	if err != nil {
+		t.Fatalf("ParseFilter() unexpected error: %v", err)
+	}
+	got := []string{}
+	for _, c := range f.Apply(captures) {
+		got = append(got, c.ID)
+	}
+	if diff := cmp.Diff([]string{"gzipped", "undecodable"}, got); diff != "" {
+		t.Errorf("Apply() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseFilter_Errors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "colour:red", wantErr: `unknown filter "colour"`},
+		{expr: "status:teapot", wantErr: "status:teapot"},
+		{expr: "status:>=abc", wantErr: "expected a status code after >="},
+		{expr: "is:slow", wantErr: "is:slow"},
+		{expr: "method:", wantErr: "method: needs a value"},
+		{expr: `body:"invoice`, wantErr: "unterminated quote"},
+		{expr: "status:5xx -", wantErr: "nothing to exclude"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseFilter(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseFilter(%q) error = %v, want it to contain %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeFunnelRequestsPage_Filter(t *testing.T) {
+	s, f := newTestProxy(t, "http://localhost:8080", nil)
+	f.Requests.Add(CaptureRequestResponse{ID: "ok", Timestamp: time.Now(), Request: CaptureRequest{Method: http.MethodGet, URL: "http://localhost:8080/ok"}, Response: CaptureResponse{StatusCode: 200}})
+	f.Requests.Add(CaptureRequestResponse{ID: "broken", Timestamp: time.Now(), Request: CaptureRequest{Method: http.MethodPost, URL: "http://localhost:8080/broken"}, Response: CaptureResponse{StatusCode: 500}})
+
+	rec := httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel?q=status%3A5xx", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "/broken") || strings.Contains(body, `/ok<`) || !strings.Contains(body, "1 of 2 requests") {
+		t.Errorf("Expected only the failed request, got:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel?q=colour%3Ared", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "unknown filter") {
+		t.Errorf("Expected the page with the filter's error, got %d:\n%s", rec.Code, rec.Body.String())
+	}
+}
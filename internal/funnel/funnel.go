@@ -3,16 +3,33 @@ package funnel
 import (
 	"context"
 	"fmt"
+	"html/template"
+	"net/http"
 	"net/url"
 	"strings"
 
+	"github.com/jonson/tsgrok/internal/util"
 	"tailscale.com/ipn"
 )
 
 type Funnel struct {
-	HTTPFunnel *HTTPFunnel
-	Client     *TailscaleClient
-	Requests   *RequestList
+	HTTPFunnel   *HTTPFunnel
+	Client       *TailscaleClient
+	Requests     *RequestList
+	MaxBodyBytes int64              // bytes of each body to capture, 0 uses util.DefaultMaxBodyBytes
+	ErrorPage    *template.Template // page returned when the target can't be reached, nil uses the built in page
+	Archived     bool               // read-only captures from an earlier run, there is no tailscale node behind it
+	DisplayName  string             // overrides the name taken from the remote target, e.g. for imported HAR files
+}
+
+// NewArchivedFunnel returns a read-only funnel holding captures from an earlier
+// run, e.g. loaded from a CaptureStore.  The targets describe the original funnel.
+func NewArchivedFunnel(id, localTarget, remoteTarget string, requests *RequestList) Funnel {
+	return Funnel{
+		HTTPFunnel: &HTTPFunnel{id: id, localTarget: localTarget, remoteTarget: remoteTarget},
+		Requests:   requests,
+		Archived:   true,
+	}
 }
 
 // ID returns the unique identifier for the funnel.
@@ -39,7 +56,48 @@ func (f *Funnel) RemoteTarget() string {
 	return f.HTTPFunnel.remoteTarget
 }
 
+// Transport returns the transport used to reach the local target, honoring the
+// funnel's TLS settings.
+func (f *Funnel) Transport() http.RoundTripper {
+	if f.HTTPFunnel == nil || f.HTTPFunnel.transport == nil {
+		return http.DefaultTransport
+	}
+	return f.HTTPFunnel.transport
+}
+
+// BodyCaptureLimit returns how many bytes of each request and response body are captured.
+func (f *Funnel) BodyCaptureLimit() int64 {
+	if f.MaxBodyBytes <= 0 {
+		return util.DefaultMaxBodyBytes
+	}
+	return f.MaxBodyBytes
+}
+
+// Inspect reports whether the funnel's traffic is captured by the inspection proxy.
+func (f *Funnel) Inspect() bool {
+	if f.HTTPFunnel == nil {
+		return false
+	}
+	return f.HTTPFunnel.Inspect()
+}
+
+// SetInspect toggles between inspected and pass-through mode.  Pass-through funnels
+// point tailscale straight at the local target, so requests are neither buffered
+// nor captured.
+func (f *Funnel) SetInspect(inspect bool) error {
+	if f.Archived {
+		return ErrFunnelArchived
+	}
+	if f.HTTPFunnel == nil || f.Client == nil {
+		return ErrFunnelNotReady
+	}
+	return f.Client.SetInspect(f.HTTPFunnel, inspect)
+}
+
 func (f *Funnel) Name() string {
+	if f.DisplayName != "" {
+		return f.DisplayName
+	}
 	if f.HTTPFunnel == nil {
 		return ""
 	}
@@ -51,6 +109,9 @@ func (f *Funnel) Name() string {
 }
 
 func (f *Funnel) Destroy() error {
+	if f.Archived {
+		return nil // nothing to tear down
+	}
 	ctx := context.Background()
 	// find the srvConfig, find this record in it, remove it
 	srvConfig, err := f.Client.ts.GetServeConfig(ctx)
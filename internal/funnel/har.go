@@ -0,0 +1,514 @@
+package funnel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// HAR is an HTTP Archive, see http://www.softwareishard.com/blog/har-12-spec/.
+// Fields starting with an underscore are extensions, following the ones used by
+// browser devtools where there is one.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+
+	Error             string                `json:"_error,omitempty"` // why the target couldn't be reached
+	ErrorKind         ProxyErrorKind        `json:"_errorKind,omitempty"`
+	ID                string                `json:"_id,omitempty"`
+	ReplayOf          string                `json:"_replayOf,omitempty"` // the _id of the entry this one replayed or edited
+	Composed          bool                  `json:"_composed,omitempty"` // written or edited by hand
+	WebSocketMessages []HARWebSocketMessage `json:"_webSocketMessages,omitempty"`
+	EventMessages     []HAREventMessage     `json:"_eventStreamMessages,omitempty"` // of a text/event-stream response
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"` // "base64" for binary bodies, the spec only allows text
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARContent is the response body after removing its Content-Encoding, Size and
+// Text are of the decoded body and Compression is the bytes the encoding saved.
+type HARContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// HARTimings splits the entry's time into phases, -1 meaning not applicable.  Only
+// the total is captured, so it is all attributed to waiting for the target.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type HARWebSocketMessage struct {
+	Type   string  `json:"type"` // "send" for frames from the public client, "receive" for frames from the target
+	Time   float64 `json:"time"` // seconds since the unix epoch
+	Opcode byte    `json:"opcode"`
+	Data   string  `json:"data"`
+}
+
+type HAREventMessage struct {
+	Type string  `json:"type"` // the event type, "message" when the stream doesn't set one
+	Time float64 `json:"time"` // seconds since the unix epoch
+	ID   string  `json:"id,omitempty"`
+	Data string  `json:"data"`
+	Size int64   `json:"size"` // of the data, which is truncated when larger than what was kept
+}
+
+// NewHAR converts captures of the funnel to a HAR, oldest first.
+func NewHAR(funnel Funnel, captures []CaptureRequestResponse) HAR {
+	entries := make([]HAREntry, 0, len(captures))
+	for _, c := range captures {
+		entries = append(entries, newHAREntry(c))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+
+	return HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: util.ProgramName, Version: util.ProgramVersion},
+		Entries: entries,
+		Comment: fmt.Sprintf("Requests to %s, proxied to %s", funnel.RemoteTarget(), funnel.LocalTarget()),
+	}}
+}
+
+// HARFileName is the name HAR exports of the funnel are saved as.
+func HARFileName(funnel Funnel, now time.Time) string {
+	return fmt.Sprintf("%s-%s.har", safeFileName(funnelName(funnel)), now.Format("20060102-150405"))
+}
+
+// Write encodes the HAR as indented JSON.
+func (h HAR) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(h)
+}
+
+func newHAREntry(c CaptureRequestResponse) HAREntry {
+	millis := float64(c.Duration) / float64(time.Millisecond)
+	entry := HAREntry{
+		StartedDateTime: c.Timestamp,
+		Time:            millis,
+		Request: HARRequest{
+			Method:      c.Request.Method,
+			URL:         c.Request.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     harRequestCookies(c.Request.Headers),
+			Headers:     harHeaders(c.Request.Headers),
+			QueryString: harQueryString(c.Request.URL),
+			HeadersSize: -1,
+			BodySize:    c.Request.BodySize,
+		},
+		Response: HARResponse{
+			Status:      c.Response.StatusCode,
+			StatusText:  http.StatusText(c.Response.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     harResponseCookies(c.Response.Headers),
+			Headers:     harHeaders(c.Response.Headers),
+			Content:     harResponseContent(c.Response),
+			RedirectURL: c.Response.Headers.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    c.Response.BodySize,
+		},
+		Timings:   HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: millis},
+		Error:     c.Error,
+		ErrorKind: c.ErrorKind,
+		ID:        c.ID,
+		ReplayOf:  c.ReplayOf,
+		Composed:  c.Composed,
+	}
+
+	if len(c.Request.Body) > 0 {
+		text, encoding := harBodyText(c.Request.Body)
+		entry.Request.PostData = &HARPostData{
+			MimeType: c.Request.Headers.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  harTruncatedComment(c.Request.BodyTruncated, len(c.Request.Body)),
+		}
+	}
+
+	if c.InProgress {
+		entry.Comment = "still in progress when exported"
+	}
+	if c.WebSocket != nil {
+		for _, frame := range c.WebSocket.Frames() {
+			message := HARWebSocketMessage{
+				Type:   "receive",
+				Time:   float64(frame.Timestamp.UnixNano()) / float64(time.Second),
+				Opcode: frame.Opcode,
+			}
+			if frame.Direction == WebSocketClientToServer {
+				message.Type = "send"
+			}
+			// as in browser devtools, binary frames are always base64 encoded
+			message.Data = string(frame.Payload)
+			if frame.Opcode != wsOpText {
+				message.Data = base64.StdEncoding.EncodeToString(frame.Payload)
+			}
+			entry.WebSocketMessages = append(entry.WebSocketMessages, message)
+		}
+	}
+	if c.ServerSentEvents != nil {
+		for _, event := range c.ServerSentEvents.Events() {
+			entry.EventMessages = append(entry.EventMessages, HAREventMessage{
+				Type: event.Event,
+				Time: float64(event.Timestamp.UnixNano()) / float64(time.Second),
+				ID:   event.ID,
+				Data: event.Data,
+				Size: event.Size,
+			})
+		}
+	}
+	return entry
+}
+
+// harResponseContent describes the response body, decoded when the whole of it
+// was captured.  Otherwise the text is the part captured, as it was transferred.
+func harResponseContent(r CaptureResponse) HARContent {
+	content := HARContent{
+		Size:     r.BodySize,
+		MimeType: r.Headers.Get("Content-Type"),
+		Comment:  harTruncatedComment(r.BodyTruncated, len(r.Body)),
+	}
+	body := r.Body
+	if encoding := r.Headers.Get("Content-Encoding"); len(body) > 0 && encoding != "" && !strings.EqualFold(encoding, "identity") {
+		decoded, err := decodeContentEncoding(body, encoding)
+		if err == nil && !r.BodyTruncated {
+			body = decoded
+			content.Size = int64(len(decoded))
+			content.Compression = content.Size - r.BodySize
+		} else {
+			content.Comment = strings.TrimPrefix(content.Comment+"; "+encoding+" encoded, as transferred", "; ")
+		}
+	}
+	content.Text, content.Encoding = harBodyText(body)
+	return content
+}
+
+// harBodyText returns the body as HAR text, base64 encoded unless it is valid UTF-8.
+func harBodyText(body []byte) (text string, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harTruncatedComment(truncated bool, captured int) string {
+	if !truncated {
+		return ""
+	}
+	return fmt.Sprintf("truncated, only the first %d bytes were captured", captured)
+}
+
+// harHeaders lists the headers sorted by name, repeated headers as separate entries.
+func harHeaders(headers http.Header) []HARNameValue {
+	entries := headerEntries(headers)
+	values := make([]HARNameValue, 0, len(entries))
+	for _, header := range entries {
+		values = append(values, HARNameValue{Name: header.Name, Value: header.Value})
+	}
+	return values
+}
+
+func harQueryString(rawURL string) []HARNameValue {
+	values := []HARNameValue{}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return values
+	}
+	query := parsed.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range query[name] {
+			values = append(values, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return values
+}
+
+func harRequestCookies(headers http.Header) []HARCookie {
+	cookies := []HARCookie{}
+	for _, cookie := range (&http.Request{Header: headers}).Cookies() {
+		cookies = append(cookies, HARCookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return cookies
+}
+
+func harResponseCookies(headers http.Header) []HARCookie {
+	cookies := []HARCookie{}
+	for _, cookie := range (&http.Response{Header: headers}).Cookies() {
+		harCookie := HARCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HTTPOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			expires := cookie.Expires
+			harCookie.Expires = &expires
+		}
+		cookies = append(cookies, harCookie)
+	}
+	return cookies
+}
+
+// ReadHAR decodes a HAR file.
+func ReadHAR(r io.Reader) (HAR, error) {
+	var har HAR
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return HAR{}, fmt.Errorf("invalid HAR: %w", err)
+	}
+	if har.Log.Version == "" && har.Log.Entries == nil {
+		return HAR{}, fmt.Errorf("invalid HAR: no log entries")
+	}
+	return har, nil
+}
+
+// LoadHARFunnel reads the HAR file at path into a read-only funnel named after
+// the file, so its requests can be browsed like any other capture.
+func LoadHARFunnel(path string) (Funnel, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Funnel{}, fmt.Errorf("failed to read HAR: %w", err)
+	}
+	defer file.Close()
+
+	har, err := ReadHAR(file)
+	if err != nil {
+		return Funnel{}, fmt.Errorf("%s: %w", path, err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	id := "har-" + safeFileName(name)
+	requests := NewRequestList(0)
+	localTarget := ""
+	for _, capture := range har.Captures() {
+		capture.FunnelID = id
+		requests.Add(capture)
+		if parsed, err := url.Parse(capture.Request.URL); err == nil && parsed.Host != "" {
+			localTarget = parsed.Scheme + "://" + parsed.Host
+		}
+	}
+
+	f := NewArchivedFunnel(id, localTarget, (&url.URL{Scheme: "file", Path: absPath}).String(), requests)
+	f.DisplayName = name
+	return f, nil
+}
+
+// Captures converts the HAR's entries back to captures, in the order they were started.
+func (h HAR) Captures() []CaptureRequestResponse {
+	entries := append([]HAREntry(nil), h.Log.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+
+	captures := make([]CaptureRequestResponse, 0, len(entries))
+	for _, entry := range entries {
+		captures = append(captures, entry.capture())
+	}
+	return captures
+}
+
+func (e HAREntry) capture() CaptureRequestResponse {
+	c := CaptureRequestResponse{
+		ID:        e.ID,
+		Timestamp: e.StartedDateTime,
+		Duration:  time.Duration(e.Time * float64(time.Millisecond)),
+		Request: CaptureRequest{
+			Method:   e.Request.Method,
+			URL:      e.Request.URL,
+			Headers:  harHeaderValues(e.Request.Headers),
+			BodySize: e.Request.BodySize,
+		},
+		Response: CaptureResponse{
+			StatusCode: e.Response.Status,
+			Headers:    harHeaderValues(e.Response.Headers),
+			Body:       harBodyBytes(e.Response.Content.Text, e.Response.Content.Encoding),
+			BodySize:   e.Response.Content.Size,
+		},
+		Error:     e.Error,
+		ErrorKind: e.ErrorKind,
+		ReplayOf:  e.ReplayOf,
+		Composed:  e.Composed,
+	}
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	if c.Error != "" && c.ErrorKind == "" {
+		c.ErrorKind = ProxyErrorOther
+	}
+	if e.Request.PostData != nil {
+		c.Request.Body = harBodyBytes(e.Request.PostData.Text, e.Request.PostData.Encoding)
+	}
+
+	// sizes are -1 when the tool that wrote the HAR didn't know them
+	if c.Request.BodySize < int64(len(c.Request.Body)) {
+		c.Request.BodySize = int64(len(c.Request.Body))
+	}
+	if c.Response.BodySize < int64(len(c.Response.Body)) {
+		c.Response.BodySize = int64(len(c.Response.Body))
+	}
+	c.Request.BodyTruncated = c.Request.BodySize > int64(len(c.Request.Body))
+	c.Response.BodyTruncated = c.Response.BodySize > int64(len(c.Response.Body))
+
+	// the text is normally decoded, as browsers write it, so the Content-Encoding no
+	// longer applies.  It still does to the part of a body that was cut short.
+	if encoding := c.Response.Headers.Get("Content-Encoding"); len(c.Response.Body) > 0 && encoding != "" && !c.Response.BodyTruncated {
+		if decoded, err := decodeContentEncoding(c.Response.Body, encoding); err != nil && len(decoded) == 0 {
+			c.Response.Headers.Del("Content-Encoding")
+		}
+	}
+
+	if len(e.WebSocketMessages) > 0 {
+		log := &WebSocketLog{closed: true}
+		for _, message := range e.WebSocketMessages {
+			frame := WebSocketFrame{
+				Direction: WebSocketServerToClient,
+				Opcode:    message.Opcode,
+				Final:     true,
+				Timestamp: time.Unix(0, int64(message.Time*float64(time.Second))),
+				Payload:   []byte(message.Data),
+			}
+			if message.Type == "send" {
+				frame.Direction = WebSocketClientToServer
+			}
+			if message.Opcode != wsOpText {
+				if payload, err := base64.StdEncoding.DecodeString(message.Data); err == nil {
+					frame.Payload = payload
+				}
+			}
+			frame.Size = int64(len(frame.Payload))
+			log.add(frame)
+		}
+		c.WebSocket = log
+	}
+	if len(e.EventMessages) > 0 {
+		log := newSSELog(0)
+		for _, message := range e.EventMessages {
+			log.add(SSEEvent{
+				ID:        message.ID,
+				Event:     message.Type,
+				Data:      message.Data,
+				Size:      max(message.Size, int64(len(message.Data))),
+				Timestamp: time.Unix(0, int64(message.Time*float64(time.Second))),
+			})
+		}
+		c.ServerSentEvents = log
+	}
+	return c
+}
+
+// harBodyBytes decodes HAR text, falling back to the text itself if it isn't valid base64.
+func harBodyBytes(text string, encoding string) []byte {
+	if encoding == "base64" {
+		if body, err := base64.StdEncoding.DecodeString(text); err == nil {
+			return body
+		}
+	}
+	return []byte(text)
+}
+
+func harHeaderValues(values []HARNameValue) http.Header {
+	headers := make(http.Header, len(values))
+	for _, header := range values {
+		headers.Add(header.Name, header.Value)
+	}
+	return headers
+}
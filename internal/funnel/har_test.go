@@ -0,0 +1,287 @@
+package funnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestNewHAR(t *testing.T) {
+	start := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	frames := newWebSocketLog(10)
+	frames.add(WebSocketFrame{Direction: WebSocketClientToServer, Opcode: wsOpText, Final: true, Size: 5, Timestamp: start, Payload: []byte("hello")})
+	frames.add(WebSocketFrame{Direction: WebSocketServerToClient, Opcode: wsOpBinary, Final: true, Size: 2, Timestamp: start, Payload: []byte{0xff, 0x00}})
+	events := newSSELog(10)
+	events.add(SSEEvent{ID: "7", Event: "price", Data: "42", Size: 2, Timestamp: start})
+	gzipped := []byte(compress(t, "gzip", strings.Repeat("compressible ", 10)))
+
+	captures := []CaptureRequestResponse{
+		{
+			ID:        "gzipped",
+			Timestamp: start.Add(2 * time.Second),
+			Request:   CaptureRequest{Method: "GET", URL: "http://localhost:8080/text"},
+			Response:  CaptureResponse{StatusCode: http.StatusOK, Body: gzipped, BodySize: int64(len(gzipped)), Headers: http.Header{"Content-Encoding": {"gzip"}}},
+		},
+		{
+			ID:               "events",
+			Timestamp:        start.Add(3 * time.Second),
+			Request:          CaptureRequest{Method: "GET", URL: "http://localhost:8080/prices"},
+			Response:         CaptureResponse{StatusCode: http.StatusOK, Headers: http.Header{"Content-Type": {"text/event-stream"}}},
+			ServerSentEvents: events,
+		},
+		{
+			ID:        "upgrade",
+			Timestamp: start.Add(time.Second),
+			Request:   CaptureRequest{Method: "GET", URL: "http://localhost:8080/ws"},
+			Response:  CaptureResponse{StatusCode: http.StatusSwitchingProtocols},
+			WebSocket: frames,
+		},
+		{
+			ID:        "post",
+			Timestamp: start,
+			Duration:  1500 * time.Microsecond,
+			Request: CaptureRequest{
+				Method:   "POST",
+				URL:      "http://localhost:8080/upload?b=2&a=1&a=3",
+				Body:     []byte{0x89, 'P', 'N', 'G'},
+				BodySize: 4,
+				Headers:  http.Header{"Content-Type": {"image/png"}, "Cookie": {"session=abc; theme=dark"}},
+			},
+			Response: CaptureResponse{
+				StatusCode:    http.StatusCreated,
+				Body:          []byte(`{"id":`),
+				BodySize:      20,
+				BodyTruncated: true,
+				Headers:       http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"id=1; Path=/; HttpOnly"}},
+			},
+		},
+	}
+
+	har := NewHAR(Funnel{HTTPFunnel: &HTTPFunnel{localTarget: "http://localhost:8080", remoteTarget: "https://demo.example.ts.net"}}, captures)
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 4 {
+		t.Fatalf("Expected a version 1.2 log with 4 entries, got %q with %d", har.Log.Version, len(har.Log.Entries))
+	}
+
+	post := har.Log.Entries[0] // oldest first
+	if post.ID != "post" || post.Time != 1.5 || post.Timings.Wait != 1.5 {
+		t.Errorf("Unexpected entry %q, time %v, wait %v", post.ID, post.Time, post.Timings.Wait)
+	}
+	if diff := cmp.Diff([]HARNameValue{{Name: "a", Value: "1"}, {Name: "a", Value: "3"}, {Name: "b", Value: "2"}}, post.Request.QueryString); diff != "" {
+		t.Errorf("Query string mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]HARCookie{{Name: "session", Value: "abc"}, {Name: "theme", Value: "dark"}}, post.Request.Cookies); diff != "" {
+		t.Errorf("Request cookies mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]HARCookie{{Name: "id", Value: "1", Path: "/", HTTPOnly: true}}, post.Response.Cookies); diff != "" {
+		t.Errorf("Response cookies mismatch (-want +got):\n%s", diff)
+	}
+	wantPost := &HARPostData{MimeType: "image/png", Text: "iVBORw==", Encoding: "base64"}
+	if diff := cmp.Diff(wantPost, post.Request.PostData); diff != "" {
+		t.Errorf("Post data mismatch (-want +got):\n%s", diff)
+	}
+	wantContent := HARContent{Size: 20, MimeType: "application/json", Text: `{"id":`, Comment: "truncated, only the first 6 bytes were captured"}
+	if diff := cmp.Diff(wantContent, post.Response.Content); diff != "" {
+		t.Errorf("Response content mismatch (-want +got):\n%s", diff)
+	}
+
+	wantMessages := []HARWebSocketMessage{
+		{Type: "send", Time: float64(start.Unix()), Opcode: wsOpText, Data: "hello"},
+		{Type: "receive", Time: float64(start.Unix()), Opcode: wsOpBinary, Data: "/wA="},
+	}
+	if diff := cmp.Diff(wantMessages, har.Log.Entries[1].WebSocketMessages); diff != "" {
+		t.Errorf("WebSocket messages mismatch (-want +got):\n%s", diff)
+	}
+
+	// the content is the decoded body, the compression what the encoding saved
+	wantContent = HARContent{Size: 130, Compression: 130 - int64(len(gzipped)), Text: strings.Repeat("compressible ", 10)}
+	if diff := cmp.Diff(wantContent, har.Log.Entries[2].Response.Content); diff != "" {
+		t.Errorf("Encoded response content mismatch (-want +got):\n%s", diff)
+	}
+	if har.Log.Entries[2].Response.BodySize != int64(len(gzipped)) {
+		t.Errorf("Expected the body size as transferred, got %d", har.Log.Entries[2].Response.BodySize)
+	}
+
+	wantEvents := []HAREventMessage{{Type: "price", Time: float64(start.Unix()), ID: "7", Data: "42", Size: 2}}
+	if diff := cmp.Diff(wantEvents, har.Log.Entries[3].EventMessages); diff != "" {
+		t.Errorf("Event stream messages mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandleFunnelHARExport(t *testing.T) {
+	s, f := newTestProxy(t, "http://localhost:8080", nil)
+	start := time.Now()
+	f.Requests.Add(CaptureRequestResponse{ID: "one", Timestamp: start, Request: CaptureRequest{Method: "GET", URL: "http://localhost:8080/one"}})
+	f.Requests.Add(CaptureRequestResponse{ID: "two", Timestamp: start.Add(time.Second), Request: CaptureRequest{Method: "GET", URL: "http://localhost:8080/two"}})
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []string
+	}{
+		{name: "All requests", query: "", wantIDs: []string{"one", "two"}},
+		{name: "Selected request", query: "?request=two", wantIDs: []string{"two"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel/har"+tt.query, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="test-funnel-`) {
+				t.Errorf("Unexpected Content-Disposition %q", got)
+			}
+
+			var har HAR
+			if err := json.Unmarshal(rec.Body.Bytes(), &har); err != nil {
+				t.Fatalf("Response is not a HAR: %v", err)
+			}
+			var ids []string
+			for _, entry := range har.Log.Entries {
+				ids = append(ids, entry.ID)
+			}
+			if diff := cmp.Diff(tt.wantIDs, ids); diff != "" {
+				t.Errorf("Exported entries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHAR_RoundTrip(t *testing.T) {
+	start := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	frames := newWebSocketLog(10)
+	frames.add(WebSocketFrame{Direction: WebSocketClientToServer, Opcode: wsOpText, Final: true, Size: 2, Timestamp: start, Payload: []byte("hi")})
+	frames.add(WebSocketFrame{Direction: WebSocketServerToClient, Opcode: wsOpBinary, Final: true, Size: 2, Timestamp: start, Payload: []byte{0xff, 0x00}})
+	events := newSSELog(10)
+	events.add(SSEEvent{Event: "message", Data: "partial", Size: 100, Timestamp: start})
+	gzipped := compress(t, "gzip", strings.Repeat("compressible ", 100))
+
+	captures := []CaptureRequestResponse{
+		{
+			ID:        "post",
+			Timestamp: start,
+			Duration:  12 * time.Millisecond,
+			Request:   CaptureRequest{Method: "POST", URL: "http://localhost:8080/upload", Body: []byte{0x89, 'P'}, BodySize: 2, Headers: http.Header{"Content-Type": {"image/png"}}},
+			Response:  CaptureResponse{StatusCode: 200, Body: []byte("ok"), BodySize: 10, BodyTruncated: true, Headers: http.Header{"Set-Cookie": {"a=1", "b=2"}}},
+		},
+		{
+			ID:        "down",
+			Timestamp: start.Add(time.Second),
+			Request:   CaptureRequest{Method: "GET", URL: "http://localhost:8080/"},
+			Response:  CaptureResponse{StatusCode: 502},
+			Error:     "connection refused",
+			ErrorKind: ProxyErrorRefused,
+		},
+		{
+			ID:        "ws",
+			Timestamp: start.Add(2 * time.Second),
+			Request:   CaptureRequest{Method: "GET", URL: "http://localhost:8080/ws"},
+			Response:  CaptureResponse{StatusCode: 101},
+			WebSocket: frames,
+		},
+		{
+			ID:               "events",
+			Timestamp:        start.Add(3 * time.Second),
+			Request:          CaptureRequest{Method: "GET", URL: "http://localhost:8080/events"},
+			Response:         CaptureResponse{StatusCode: 200},
+			ServerSentEvents: events,
+		},
+		{
+			ID:        "cut-short",
+			Timestamp: start.Add(4 * time.Second),
+			Request:   CaptureRequest{Method: "GET", URL: "http://localhost:8080/big"},
+			Response:  CaptureResponse{StatusCode: 200, Body: []byte(gzipped[:10]), BodySize: int64(len(gzipped)), BodyTruncated: true, Headers: http.Header{"Content-Encoding": {"gzip"}}},
+		},
+	}
+
+	var buf strings.Builder
+	if err := NewHAR(Funnel{}, captures).Write(&buf); err != nil {
+		t.Fatalf("Write() unexpected error: %v", err)
+	}
+	har, err := ReadHAR(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("ReadHAR() unexpected error: %v", err)
+	}
+	got := har.Captures()
+
+	opts := cmp.Options{
+		cmpopts.IgnoreFields(CaptureRequestResponse{}, "WebSocket", "ServerSentEvents"),
+		cmpopts.EquateEmpty(),
+	}
+	if diff := cmp.Diff(captures, got, opts); diff != "" {
+		t.Errorf("Captures mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(frames.Frames(), got[2].WebSocket.Frames()); diff != "" {
+		t.Errorf("Frames mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(events.Events(), got[3].ServerSentEvents.Events()); diff != "" {
+		t.Errorf("Events mismatch (-want +got):\n%s", diff)
+	}
+
+	// a whole encoded body comes back decoded
+	encoded := CaptureRequestResponse{ID: "gzipped", Response: CaptureResponse{StatusCode: 200, Body: []byte(gzipped), BodySize: int64(len(gzipped)), Headers: http.Header{"Content-Encoding": {"gzip"}}}}
+	decoded := newHAREntry(encoded).capture().Response
+	if string(decoded.Body) != strings.Repeat("compressible ", 100) || decoded.BodyTruncated || decoded.Headers.Get("Content-Encoding") != "" {
+		t.Errorf("Expected the decoded body without its Content-Encoding, got %d bytes, truncated %v, headers %v", len(decoded.Body), decoded.BodyTruncated, decoded.Headers)
+	}
+}
+
+func TestLoadHARFunnel(t *testing.T) {
+	// roughly what browser devtools export, without any of tsgrok's extensions
+	const devtoolsHAR = `{"log": {"version": "1.2", "creator": {"name": "WebInspector", "version": "537.36"}, "entries": [
+		{"startedDateTime": "2025-05-01T12:00:01.500Z", "time": 20.5,
+		 "request": {"method": "GET", "url": "https://api.example.com/logo.png", "httpVersion": "h2", "headers": [{"name": "accept", "value": "image/*"}], "queryString": [], "cookies": [], "headersSize": -1, "bodySize": 0},
+		 "response": {"status": 200, "statusText": "", "httpVersion": "h2", "headers": [{"name": "content-type", "value": "image/png"}], "cookies": [],
+		              "content": {"size": 4, "mimeType": "image/png", "text": "iVBORw==", "encoding": "base64"}, "redirectURL": "", "headersSize": -1, "bodySize": -1},
+		 "cache": {}, "timings": {"send": 1, "wait": 18, "receive": 1.5}},
+		{"startedDateTime": "2025-05-01T12:00:00Z", "time": 5,
+		 "request": {"method": "POST", "url": "https://api.example.com/login", "httpVersion": "h2", "headers": [], "queryString": [], "cookies": [], "headersSize": -1, "bodySize": -1,
+		             "postData": {"mimeType": "application/json", "text": "{\"user\":\"a\"}"}},
+		 "response": {"status": 204, "statusText": "No Content", "httpVersion": "h2", "headers": [], "cookies": [], "content": {"size": 0, "mimeType": ""}, "redirectURL": "", "headersSize": -1, "bodySize": 0},
+		 "cache": {}, "timings": {"send": 1, "wait": 3, "receive": 1}}
+	]}}`
+	path := filepath.Join(t.TempDir(), "session.har")
+	if err := os.WriteFile(path, []byte(devtoolsHAR), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadHARFunnel(path)
+	if err != nil {
+		t.Fatalf("LoadHARFunnel() unexpected error: %v", err)
+	}
+	if !f.Archived || f.Name() != "session" || f.ID() != "har-session" || f.LocalTarget() != "https://api.example.com" {
+		t.Errorf("Unexpected funnel: archived %v, name %q, id %q, target %q", f.Archived, f.Name(), f.ID(), f.LocalTarget())
+	}
+	if f.Requests.Length != 2 {
+		t.Fatalf("Expected 2 requests, got %d", f.Requests.Length)
+	}
+
+	// the newest request is at the head of the list
+	logo, login := f.Requests.Head.Request, f.Requests.Tail.Request
+	if logo.Path() != "/logo.png" || string(logo.Response.Body) != "\x89PNG" || logo.Response.BodySize != 4 || logo.Duration != 20500*time.Microsecond {
+		t.Errorf("Unexpected logo request: path %q, body %q, size %d, duration %v", logo.Path(), logo.Response.Body, logo.Response.BodySize, logo.Duration)
+	}
+	if logo.ID == "" || logo.FunnelID != "har-session" || logo.Request.Headers.Get("Accept") != "image/*" {
+		t.Errorf("Unexpected logo request: id %q, funnel %q, headers %v", logo.ID, logo.FunnelID, logo.Request.Headers)
+	}
+	if string(login.Request.Body) != `{"user":"a"}` || login.Request.BodySize != 12 || login.Request.BodyTruncated {
+		t.Errorf("Unexpected login body %q, size %d, truncated %v", login.Request.Body, login.Request.BodySize, login.Request.BodyTruncated)
+	}
+
+	if _, err := LoadHARFunnel(filepath.Join(t.TempDir(), "missing.har")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+	if _, err := ReadHAR(strings.NewReader(`{"not": "a har"}`)); err == nil {
+		t.Errorf("Expected an error for JSON that isn't a HAR")
+	}
+}
@@ -1,6 +1,8 @@
 package funnel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	stdlog "log"
@@ -8,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 
 	"io/fs"
 
@@ -18,33 +21,61 @@ import (
 var HttpServerPath = fmt.Sprintf("/%s/", util.ProgramName)
 
 type HttpServer struct {
-	port                  int             // port we're listening on
-	mux                   *http.ServeMux  // mux for handling requests
-	requestLimitPerFunnel int             // max requests per funnel to keep, older ones will be dropped
-	messageBus            util.MessageBus // message bus for sending messages to the program
-	funnelRegistry        *FunnelRegistry // registry of funnels
-	logger                *stdlog.Logger  // logger for logging
-	embeddedTemplates     *template.Template
+	port              int             // port we're listening on
+	mux               *http.ServeMux  // mux for handling requests
+	events            util.EventBus   // the server's events are published on it
+	funnelRegistry    *FunnelRegistry // registry of funnels
+	logger            *stdlog.Logger  // logger for logging
+	embeddedTemplates *template.Template
+	store             *CaptureStore // optional, finished captures are saved to it
+	live              *liveUpdates  // pushes changes to the web inspector
+	unsubscribe       []func()      // releases the subscriptions to the events and the registry, see Close
+
+	mu     sync.Mutex
+	server *http.Server // set by Start
+
+	funnelDefaults *EphemeralFunnelOptions                                                       // set by EnableFunnelCreation
+	newFunnel      func(context.Context, EphemeralFunnelOptions, *stdlog.Logger) (Funnel, error) // CreateEphemeralFunnel, replaced in tests
 }
 
-func NewHttpServer(port int, messageBus util.MessageBus, funnelRegistry *FunnelRegistry, logger *stdlog.Logger) (*HttpServer, error) {
+func NewHttpServer(port int, events util.EventBus, funnelRegistry *FunnelRegistry, logger *stdlog.Logger) (*HttpServer, error) {
 	// Parse templates from the web.TemplatesFS, first inject a few functions
 	tmpl, err := loadTemplates()
 	if err != nil {
 		return nil, err
 	}
 
+	live := newLiveUpdates()
+	unsubscribe := []func(){
+		events.Subscribe(live.follow),
+		funnelRegistry.Subscribe(func(change FunnelChange, id string) {
+			events.Publish(FunnelChangedMsg{FunnelId: id, Change: change})
+		}),
+	}
+
 	return &HttpServer{
-		port:                  port,
-		mux:                   http.NewServeMux(),
-		requestLimitPerFunnel: 100,
-		messageBus:            messageBus,
-		funnelRegistry:        funnelRegistry,
-		logger:                logger,
-		embeddedTemplates:     tmpl,
+		port:              port,
+		mux:               http.NewServeMux(),
+		events:            events,
+		funnelRegistry:    funnelRegistry,
+		logger:            logger,
+		embeddedTemplates: tmpl,
+		live:              live,
+		unsubscribe:       unsubscribe,
+		newFunnel:         CreateEphemeralFunnel,
 	}, nil
 }
 
+// SetCaptureStore saves every finished capture to store, it must be called before Start.
+func (s *HttpServer) SetCaptureStore(store *CaptureStore) {
+	s.store = store
+	store.setErrorHandler(func(funnelID string, err error) {
+		// the store's errors say what it was doing
+		s.logger.Printf("Error in capture store: %v", err)
+		s.events.Publish(ErrorMsg{FunnelId: funnelID, Err: err})
+	})
+}
+
 func (s *HttpServer) GetFunnelById(id string) (Funnel, error) {
 	return s.funnelRegistry.GetFunnel(id)
 }
@@ -73,14 +104,22 @@ func (s *HttpServer) Start() error {
 
 	s.mux.HandleFunc(HttpServerPath, s.handleRequest)
 	s.mux.HandleFunc("/inspect/", s.handleFunnelInspect)
+	s.mux.HandleFunc("/funnels", s.handleFunnelCreate)
+	s.mux.HandleFunc("/funnels/", s.handleFunnelDelete)
+	s.mux.HandleFunc("/events", s.handleLiveEvents)
+	s.mux.HandleFunc(APIPath, s.handleAPI)
 	s.mux.HandleFunc("/", s.handleRoot)
 
+	server := &http.Server{Addr: target, Handler: s.mux, ErrorLog: s.logger}
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
 	// do this in a goroutine, we listen in the background
 	go func() {
-		server := &http.Server{Addr: target, Handler: s.mux, ErrorLog: s.logger}
 		err := server.ListenAndServe()
 
-		if err != nil {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			// this will kill the program
 			s.logger.Println(err)
 			os.Exit(1)
@@ -89,3 +128,20 @@ func (s *HttpServer) Start() error {
 
 	return nil
 }
+
+// Close stops the server if it was started, and releases its subscriptions to
+// the event bus and the funnel registry.
+func (s *HttpServer) Close() error {
+	for _, unsubscribe := range s.unsubscribe {
+		unsubscribe()
+	}
+	s.unsubscribe = nil
+
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Close()
+}
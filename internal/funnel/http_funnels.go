@@ -0,0 +1,98 @@
+package funnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errFunnelCreationDisabled is returned by createFunnel unless EnableFunnelCreation
+// was called.
+var errFunnelCreationDisabled = errors.New("funnels can't be created by this tsgrok")
+
+// funnelOptionsError is returned by createFunnel when the funnel asked for is
+// invalid, rather than failing to come up.
+type funnelOptionsError struct {
+	err error
+}
+
+func (e funnelOptionsError) Error() string { return e.err.Error() }
+func (e funnelOptionsError) Unwrap() error { return e.err }
+
+// newFunnelRequest is a funnel to create, from the API or the web inspector's form.
+type newFunnelRequest struct {
+	Name        string
+	Target      string // a port, host:port or URL
+	RemotePort  uint16 // defaults to 443
+	Inspect     bool
+	MaxRequests int // defaults to the one given to EnableFunnelCreation
+}
+
+// EnableFunnelCreation lets the API and the web inspector create funnels, using
+// the defaults for the options a request leaves out.  It must be called before
+// Start, without it funnels can only be listed and deleted.
+func (s *HttpServer) EnableFunnelCreation(defaults EphemeralFunnelOptions) {
+	s.funnelDefaults = &defaults
+}
+
+// createFunnel brings up a new funnel, which takes a few seconds, and adds it to
+// the registry once it is ready.  The startup is abandoned if ctx is cancelled,
+// e.g. by the client going away.
+func (s *HttpServer) createFunnel(ctx context.Context, req newFunnelRequest) (Funnel, error) {
+	if s.funnelDefaults == nil {
+		return Funnel{}, errFunnelCreationDisabled
+	}
+
+	name := strings.TrimSpace(req.Name)
+	target := strings.TrimSpace(req.Target)
+	if name == "" || target == "" {
+		return Funnel{}, funnelOptionsError{errors.New("name and target are required")}
+	}
+	if req.RemotePort == 0 {
+		req.RemotePort = 443
+	}
+	if err := ValidateRemotePort(req.RemotePort); err != nil {
+		return Funnel{}, funnelOptionsError{err}
+	}
+	if _, err := ParseLocalTarget(target); err != nil {
+		return Funnel{}, funnelOptionsError{fmt.Errorf("invalid target %q: %w", target, err)}
+	}
+	if req.MaxRequests < 0 {
+		return Funnel{}, funnelOptionsError{errors.New("max requests can't be negative")}
+	}
+
+	opts := *s.funnelDefaults
+	opts.Name = name
+	opts.Target = target
+	opts.RemotePort = req.RemotePort
+	opts.Inspect = req.Inspect
+	if req.MaxRequests > 0 {
+		opts.MaxRequests = req.MaxRequests
+	}
+
+	f, err := s.newFunnel(ctx, opts, s.logger)
+	if err != nil {
+		if errors.Is(err, ErrFunnelPortNotAllowed) {
+			return Funnel{}, funnelOptionsError{err}
+		}
+		s.logger.Printf("Error creating funnel %s: %v", name, err)
+		return Funnel{}, fmt.Errorf("creating funnel: %w", err)
+	}
+	s.funnelRegistry.AddFunnel(f)
+	return f, nil
+}
+
+// deleteFunnel tears the funnel down and forgets its captures.
+func (s *HttpServer) deleteFunnel(funnelID string) error {
+	f, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		return err
+	}
+	if err := f.Destroy(); err != nil {
+		s.logger.Printf("Error destroying funnel %s: %v", funnelID, err)
+		return fmt.Errorf("deleting funnel: %w", err)
+	}
+	s.funnelRegistry.RemoveFunnel(funnelID)
+	return nil
+}
@@ -0,0 +1,188 @@
+package funnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// enableTestFunnelCreation lets s create funnels without tailscale, recording the
+// options each one is created with.
+func enableTestFunnelCreation(s *HttpServer) *[]EphemeralFunnelOptions {
+	var created []EphemeralFunnelOptions
+	s.EnableFunnelCreation(EphemeralFunnelOptions{MaxRequests: 25})
+	s.newFunnel = func(ctx context.Context, opts EphemeralFunnelOptions, logger *stdlog.Logger) (Funnel, error) {
+		if opts.Target == "9999" {
+			return Funnel{}, fmt.Errorf("%w: port %d", ErrFunnelPortNotAllowed, opts.RemotePort)
+		}
+		if opts.Target == "9998" {
+			<-ctx.Done() // a node that never comes up
+			return Funnel{}, ctx.Err()
+		}
+		created = append(created, opts)
+		id := fmt.Sprintf("created-%d", len(created))
+		return Funnel{HTTPFunnel: &HTTPFunnel{id: id, localTarget: "http://localhost:" + opts.Target}, Requests: NewRequestList(opts.MaxRequests)}, nil
+	}
+	return &created
+}
+
+func postForm(s *HttpServer, handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	req := newInspectorRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestHandleFunnelCreate(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	created := enableTestFunnelCreation(s)
+
+	rec := postForm(s, s.handleFunnelCreate, "/funnels", url.Values{"name": {"web"}, "target": {"3000"}, "remote_port": {"8443"}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Fatalf("Expected a redirect to the index, got %d: %s", rec.Code, rec.Body.String())
+	}
+	want := []EphemeralFunnelOptions{{Name: "web", Target: "3000", RemotePort: 8443, Inspect: false, MaxRequests: 25}}
+	if diff := cmp.Diff(want, *created); diff != "" {
+		t.Errorf("Options mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := s.funnelRegistry.GetFunnel("created-1"); err != nil {
+		t.Errorf("Expected the funnel to be registered")
+	}
+	bus := s.events.(*recordingBus)
+	if diff := cmp.Diff([]util.Event{FunnelChangedMsg{FunnelId: "created-1", Change: FunnelAdded}}, bus.msgs); diff != "" {
+		t.Errorf("Expected the TUI to be told (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		wantErr string
+	}{
+		{name: "missing target", form: url.Values{"name": {"web"}, "remote_port": {"443"}, "inspect": {"on"}}, wantErr: "name and target are required"},
+		{name: "bad port", form: url.Values{"name": {"web"}, "target": {"3000"}, "remote_port": {"80"}, "inspect": {"on"}}, wantErr: "invalid remote port 80"},
+		{name: "not a port", form: url.Values{"name": {"web"}, "target": {"3000"}, "remote_port": {"https"}}, wantErr: "invalid remote port &#34;https&#34;"},
+		{name: "port not allowed", form: url.Values{"name": {"web"}, "target": {"9999"}, "remote_port": {"10000"}, "inspect": {"on"}}, wantErr: "remote port not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postForm(s, s.handleFunnelCreate, "/funnels", tt.form)
+			body := rec.Body.String()
+			if rec.Code != http.StatusOK || !strings.Contains(body, "Not created:") || !strings.Contains(body, tt.wantErr) {
+				t.Fatalf("Expected the form again with %q, got %d:\n%s", tt.wantErr, rec.Code, body)
+			}
+			// what was typed is kept
+			if !strings.Contains(body, `name="name" value="web"`) {
+				t.Errorf("Expected the name to be kept, got:\n%s", body)
+			}
+			if tt.form.Get("inspect") != "" && !strings.Contains(body, `value="on" checked`) {
+				t.Errorf("Expected inspect to stay checked, got:\n%s", body)
+			}
+		})
+	}
+	if len(*created) != 1 {
+		t.Errorf("Expected no more funnels to be created, got %v", *created)
+	}
+}
+
+func TestCreateFunnel_Canceled(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	created := enableTestFunnelCreation(s)
+
+	// the client went away while the node was starting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.createFunnel(ctx, newFunnelRequest{Name: "web", Target: "9998"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("createFunnel() error = %v, want %v", err, context.Canceled)
+	}
+	if len(*created) != 0 || s.funnelRegistry.Len() != 1 {
+		t.Errorf("Expected no funnel to be added, got %v", *created)
+	}
+}
+
+func TestHandleFunnelCreate_Refused(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	form := url.Values{"name": {"web"}, "target": {"3000"}, "remote_port": {"443"}}
+
+	if rec := postForm(s, s.handleFunnelCreate, "/funnels", form); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected creating funnels to be off by default, got %d", rec.Code)
+	}
+
+	enableTestFunnelCreation(s)
+	req := newInspectorRequest(http.MethodPost, "/funnels", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	rec := httptest.NewRecorder()
+	s.handleFunnelCreate(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a post from another site to be refused, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleFunnelCreate(rec, httptest.NewRequest(http.MethodGet, "/funnels", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestHandleFunnelDelete(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	s.funnelRegistry.AddFunnel(NewArchivedFunnel("old", "http://localhost:9000", "https://old.example.ts.net", NewRequestList(0)))
+
+	rec := postForm(s, s.handleFunnelDelete, "/funnels/old/delete", nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Fatalf("Expected a redirect to the index, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.funnelRegistry.GetFunnel("old"); !errors.Is(err, ErrFunnelNotFound) {
+		t.Errorf("Expected the funnel to be removed")
+	}
+	bus := s.events.(*recordingBus)
+	if diff := cmp.Diff([]util.Event{FunnelChangedMsg{FunnelId: "old", Change: FunnelAdded}, FunnelChangedMsg{FunnelId: "old", Change: FunnelRemoved}}, bus.msgs); diff != "" {
+		t.Errorf("Expected the TUI to be told (-want +got):\n%s", diff)
+	}
+
+	for path, want := range map[string]int{
+		"/funnels/old/delete":         http.StatusNotFound,
+		"/funnels/test-funnel":        http.StatusNotFound,
+		"/funnels/a/b/delete":         http.StatusNotFound,
+		"/funnels/test-funnel/delete": http.StatusMethodNotAllowed,
+	} {
+		method := http.MethodPost
+		if want == http.StatusMethodNotAllowed {
+			method = http.MethodGet
+		}
+		rec := httptest.NewRecorder()
+		s.handleFunnelDelete(rec, newInspectorRequest(method, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s %s: expected %d, got %d", method, path, want, rec.Code)
+		}
+	}
+}
+
+func TestHandleRoot_NewFunnelForm(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+
+	rec := httptest.NewRecorder()
+	s.handleRoot(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	if strings.Contains(body, `id="new-funnel"`) || !strings.Contains(body, `action="/funnels/test-funnel/delete"`) {
+		t.Errorf("Expected only the delete buttons, got:\n%s", body)
+	}
+
+	enableTestFunnelCreation(s)
+	rec = httptest.NewRecorder()
+	s.handleRoot(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body = rec.Body.String()
+	if !strings.Contains(body, `id="new-funnel"`) || !strings.Contains(body, `<option value="443" selected>`) || !strings.Contains(body, `value="on" checked`) {
+		t.Errorf("Expected the new funnel form with its defaults, got:\n%s", body)
+	}
+}
@@ -1,6 +1,8 @@
 package funnel
 
 import (
+	"net/http"
+	"sort"
 	"strings"
 )
 
@@ -18,17 +20,25 @@ func findRequestInList(requestList *RequestList, requestID string) *CaptureReque
 	if requestList == nil {
 		return nil
 	}
-	requestList.mu.Lock()
-	defer requestList.mu.Unlock()
-	currentNode := requestList.Head
-	for currentNode != nil {
-		if currentNode.Request.ID == requestID {
-			crCopy := currentNode.Request
-			return &crCopy
+	return requestList.Find(requestID)
+}
+
+// headerEntries flattens headers into entries sorted by name, with one entry per
+// value so repeated headers like Set-Cookie are shown as they were sent.
+func headerEntries(headers http.Header) []HeaderEntry {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var entries []HeaderEntry
+	for _, name := range names {
+		for _, value := range headers[name] {
+			entries = append(entries, HeaderEntry{Name: name, Value: value})
 		}
-		currentNode = currentNode.Next
 	}
-	return nil
+	return entries
 }
 
 // singleJoiningSlash is a utility function for joining URL paths.
@@ -1,11 +1,17 @@
 package funnel
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jonson/tsgrok/internal/util"
 )
@@ -15,29 +21,48 @@ func (s *HttpServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	s.renderIndex(w, FunnelFormData{RemotePort: 443, Inspect: true})
+}
 
+// renderIndex writes the inspector's index page, listing every funnel, with the
+// new funnel form filled in with form.
+func (s *HttpServer) renderIndex(w http.ResponseWriter, form FunnelFormData) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	displayFunnels := make([]DisplayFunnel, 0, len(s.funnelRegistry.Funnels))
-	for _, funnel := range s.funnelRegistry.Funnels {
+	funnels := s.funnelRegistry.Funnels()
+	displayFunnels := make([]DisplayFunnel, 0, len(funnels))
+	for _, funnel := range funnels {
 		df := DisplayFunnel{
 			ID:          funnel.HTTPFunnel.id,
 			LocalTarget: funnel.LocalTarget(),
 			RemoteURL:   funnel.RemoteTarget(),
+			Inspect:     funnel.Inspect(),
+			Archived:    funnel.Archived,
 		}
 		displayFunnels = append(displayFunnels, df)
 	}
+	// keep the list steady as the page refreshes
+	sort.Slice(displayFunnels, func(i, j int) bool {
+		if displayFunnels[i].RemoteURL != displayFunnels[j].RemoteURL {
+			return displayFunnels[i].RemoteURL < displayFunnels[j].RemoteURL
+		}
+		return displayFunnels[i].ID < displayFunnels[j].ID
+	})
 
 	data := struct {
 		Title       string
 		ProgramName string
 		ActiveNav   string
 		Funnels     []DisplayFunnel
+		CanCreate   bool
+		NewFunnel   FunnelFormData
 	}{
 		Title:       "Request Inspector",
 		ProgramName: util.ProgramName,
 		ActiveNav:   "Inspect",
 		Funnels:     displayFunnels,
+		CanCreate:   s.funnelDefaults != nil,
+		NewFunnel:   form,
 	}
 
 	err := s.embeddedTemplates.ExecuteTemplate(w, "inspector.html", data)
@@ -47,13 +72,100 @@ func (s *HttpServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleFunnelCreate creates a funnel from the index page's form.  The page is
+// shown again with the error if it can't be, so nothing that was typed is lost.
+func (s *HttpServer) handleFunnelCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.sameOrigin(r) {
+		http.Error(w, "Cross-origin requests are not allowed", http.StatusForbidden)
+		return
+	}
+	if s.funnelDefaults == nil {
+		http.Error(w, "Funnels can't be created by this tsgrok", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	form := FunnelFormData{
+		Name:    r.PostForm.Get("name"),
+		Target:  r.PostForm.Get("target"),
+		Inspect: r.PostForm.Get("inspect") != "",
+	}
+	port, err := strconv.ParseUint(r.PostForm.Get("remote_port"), 10, 16)
+	if err != nil {
+		form.Error = fmt.Sprintf("invalid remote port %q", r.PostForm.Get("remote_port"))
+		s.renderIndex(w, form)
+		return
+	}
+	form.RemotePort = uint16(port)
+
+	_, err = s.createFunnel(r.Context(), newFunnelRequest{Name: form.Name, Target: form.Target, RemotePort: form.RemotePort, Inspect: form.Inspect})
+	if err != nil {
+		form.Error = err.Error()
+		s.renderIndex(w, form)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleFunnelDelete deletes the funnel posted to /funnels/<id>/delete and goes
+// back to the index page.
+func (s *HttpServer) handleFunnelDelete(w http.ResponseWriter, r *http.Request) {
+	funnelID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/funnels/"), "/delete")
+	if !ok || funnelID == "" || strings.Contains(funnelID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.sameOrigin(r) {
+		http.Error(w, "Cross-origin requests are not allowed", http.StatusForbidden)
+		return
+	}
+
+	if err := s.deleteFunnel(funnelID); err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 func (s *HttpServer) handleFunnelInspect(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/inspect/")
 	path = strings.TrimSuffix(path, "/")
 	parts := strings.Split(path, "/")
 
 	if len(parts) == 1 && parts[0] != "" {
-		s.serveFunnelRequestsPage(w, r, parts[0])
+		s.serveFunnelRequestsPage(w, r, parts[0], "funnel_requests.html")
+		return
+	}
+
+	if len(parts) == 2 && parts[0] != "" && parts[1] == "requests" {
+		s.serveFunnelRequestsPage(w, r, parts[0], "_request_list.html")
+		return
+	}
+
+	if len(parts) == 2 && parts[0] != "" && parts[1] == "har" {
+		s.handleFunnelHARExport(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[0] != "" && parts[1] == "compose" {
+		s.handleFunnelCompose(w, r, parts[0])
 		return
 	}
 
@@ -62,6 +174,26 @@ func (s *HttpServer) handleFunnelInspect(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if len(parts) == 4 && parts[0] != "" && parts[1] == "request" && parts[2] != "" && parts[3] == "replay" {
+		s.handleFunnelRequestReplay(w, r, parts[0], parts[2])
+		return
+	}
+
+	if len(parts) == 4 && parts[0] != "" && parts[1] == "request" && parts[2] != "" && parts[3] == "frames" {
+		s.handleFunnelWebSocketFramesFragment(w, r, parts[0], parts[2])
+		return
+	}
+
+	if len(parts) == 4 && parts[0] != "" && parts[1] == "request" && parts[2] != "" && parts[3] == "events" {
+		s.handleFunnelSSEEventsFragment(w, r, parts[0], parts[2])
+		return
+	}
+
+	if len(parts) == 5 && parts[0] != "" && parts[1] == "request" && parts[2] != "" && parts[3] == "snippet" && parts[4] != "" {
+		s.handleFunnelRequestSnippet(w, r, parts[0], parts[2], SnippetFormat(parts[4]))
+		return
+	}
+
 	if len(parts) == 5 && parts[0] != "" && parts[1] == "request" && parts[2] != "" && parts[3] == "body" && parts[4] == "request" {
 		s.handleFunnelRequestBodyFragment(w, r, parts[0], parts[2])
 		return
@@ -72,10 +204,27 @@ func (s *HttpServer) handleFunnelInspect(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if len(parts) == 6 && parts[0] != "" && parts[1] == "request" && parts[2] != "" && parts[3] == "body" && (parts[4] == "request" || parts[4] == "response") && parts[5] == "raw" {
+		s.handleFunnelBodyDownload(w, r, parts[0], parts[2], parts[4] == "request", -1)
+		return
+	}
+
+	if len(parts) == 7 && parts[0] != "" && parts[1] == "request" && parts[2] != "" && parts[3] == "body" && (parts[4] == "request" || parts[4] == "response") && parts[5] == "part" {
+		index, err := strconv.Atoi(parts[6])
+		if err != nil || index < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		s.handleFunnelBodyDownload(w, r, parts[0], parts[2], parts[4] == "request", index)
+		return
+	}
+
 	http.NotFound(w, r)
 }
 
-func (s *HttpServer) serveFunnelRequestsPage(w http.ResponseWriter, r *http.Request, funnelID string) {
+// serveFunnelRequestsPage renders a funnel's page, or only its request log with
+// the _request_list.html template, which the page reloads as requests come in.
+func (s *HttpServer) serveFunnelRequestsPage(w http.ResponseWriter, r *http.Request, funnelID string, templateName string) {
 	funnel, err := s.GetFunnelById(funnelID)
 	if err != nil {
 		if errors.Is(err, ErrFunnelNotFound) {
@@ -89,23 +238,32 @@ func (s *HttpServer) serveFunnelRequestsPage(w http.ResponseWriter, r *http.Requ
 
 	var capturedRequests []CaptureRequestResponse
 	if funnel.Requests != nil {
-		funnel.Requests.mu.Lock()
-		currentNode := funnel.Requests.Head
-		for currentNode != nil {
-			capturedRequests = append(capturedRequests, currentNode.Request)
-			currentNode = currentNode.Next
-		}
-		funnel.Requests.mu.Unlock()
+		capturedRequests = funnel.Requests.All()
+	}
+	totalRequests := len(capturedRequests)
+
+	// a filter that doesn't parse is shown with the page, which lists everything
+	query := r.URL.Query().Get("q")
+	var filterError string
+	if filter, err := ParseFilter(query); err != nil {
+		filterError = err.Error()
+	} else {
+		capturedRequests = filter.Apply(capturedRequests)
 	}
 
 	data := struct {
-		ProgramName string
-		ActiveNav   string
-		Funnel      struct {
+		ProgramName   string
+		ActiveNav     string
+		Filter        string
+		FilterError   string
+		TotalRequests int
+		Funnel        struct {
 			ID          string
 			DisplayName string
 			LocalTarget string
 			RemoteURL   string
+			Inspect     bool
+			Archived    bool
 		}
 		Requests []struct {
 			UUID              string
@@ -116,26 +274,40 @@ func (s *HttpServer) serveFunnelRequestsPage(w http.ResponseWriter, r *http.Requ
 			StatusClass       string
 			StatusCode        int
 			FormattedDuration string
+			ErrorKind         string // set when the target couldn't be reached
+			Origin            string // set when the request didn't come through the funnel
+			ReplayOf          string
 		}
 	}{
-		ProgramName: util.ProgramName,
-		ActiveNav:   "Inspect",
+		ProgramName:   util.ProgramName,
+		ActiveNav:     "Inspect",
+		Filter:        query,
+		FilterError:   filterError,
+		TotalRequests: totalRequests,
 		Funnel: struct {
 			ID          string
 			DisplayName string
 			LocalTarget string
 			RemoteURL   string
+			Inspect     bool
+			Archived    bool
 		}{
 			ID:          funnel.ID(),
 			DisplayName: funnelName(funnel),
 			LocalTarget: funnel.LocalTarget(),
 			RemoteURL:   funnel.RemoteTarget(),
+			Inspect:     funnel.Inspect(),
+			Archived:    funnel.Archived,
 		},
 	}
 
 	for _, req := range capturedRequests {
+		formattedDuration := req.DurationLabel() // as the TUI shows it
+
 		statusClass := "default"
-		if req.Response.StatusCode >= 200 && req.Response.StatusCode < 300 {
+		if req.Error != "" {
+			statusClass = "error"
+		} else if req.Response.StatusCode >= 200 && req.Response.StatusCode < 300 {
 			statusClass = "2xx"
 		} else if req.Response.StatusCode >= 300 && req.Response.StatusCode < 400 {
 			statusClass = "3xx"
@@ -166,6 +338,9 @@ func (s *HttpServer) serveFunnelRequestsPage(w http.ResponseWriter, r *http.Requ
 			StatusClass       string
 			StatusCode        int
 			FormattedDuration string
+			ErrorKind         string // set when the target couldn't be reached
+			Origin            string // set when the request didn't come through the funnel
+			ReplayOf          string
 		}{
 			UUID:              req.ID,
 			Method:            req.Request.Method,
@@ -174,18 +349,69 @@ func (s *HttpServer) serveFunnelRequestsPage(w http.ResponseWriter, r *http.Requ
 			RequestURLString:  req.Request.URL,
 			StatusClass:       statusClass,
 			StatusCode:        req.Response.StatusCode,
-			FormattedDuration: req.Duration.String(),
+			FormattedDuration: formattedDuration,
+			ErrorKind:         string(req.ErrorKind),
+			Origin:            req.Origin(),
+			ReplayOf:          req.ReplayOf,
 		})
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err = s.embeddedTemplates.ExecuteTemplate(w, "funnel_requests.html", data)
+	err = s.embeddedTemplates.ExecuteTemplate(w, templateName, data)
 	if err != nil {
-		s.logger.Printf("Error executing funnel_requests template for funnel %s: %v", funnelID, err)
+		s.logger.Printf("Error executing %s template for funnel %s: %v", templateName, funnelID, err)
 		http.Error(w, "Failed to render page", http.StatusInternalServerError)
 	}
 }
 
+// handleFunnelHARExport downloads the funnel's captures as a HAR file, or only the
+// ones named by request query parameters or matching the q filter.
+func (s *HttpServer) handleFunnelHARExport(w http.ResponseWriter, r *http.Request, funnelID string) {
+	funnel, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		} else {
+			s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+			http.Error(w, "Error retrieving funnel", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var captures []CaptureRequestResponse
+	if funnel.Requests != nil {
+		captures = funnel.Requests.All()
+	}
+	if ids := r.URL.Query()["request"]; len(ids) > 0 {
+		wanted := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			wanted[id] = true
+		}
+		var selected []CaptureRequestResponse
+		for _, c := range captures {
+			if wanted[c.ID] {
+				selected = append(selected, c)
+			}
+		}
+		captures = selected
+	}
+	if query := r.URL.Query().Get("q"); query != "" {
+		filter, err := ParseFilter(query)
+		if err != nil {
+			http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		captures = filter.Apply(captures)
+	}
+
+	filename := HARFileName(funnel, time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := NewHAR(funnel, captures).Write(w); err != nil {
+		s.logger.Printf("Error writing HAR for funnel %s: %v", funnelID, err)
+	}
+}
+
 func (s *HttpServer) handleFunnelRequestDetailFragment(w http.ResponseWriter, r *http.Request, funnelID string, requestID string) {
 	funnel, err := s.GetFunnelById(funnelID)
 	if err != nil {
@@ -205,6 +431,147 @@ func (s *HttpServer) handleFunnelRequestDetailFragment(w http.ResponseWriter, r
 		return
 	}
 
+	s.renderRequestDetailFragment(w, funnel, capturedRequest)
+}
+
+// handleFunnelRequestReplay sends the request to the local target again and
+// responds with the details of the new capture.
+func (s *HttpServer) handleFunnelRequestReplay(w http.ResponseWriter, r *http.Request, funnelID string, requestID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.sameOrigin(r) {
+		http.Error(w, "Cross-origin requests are not allowed", http.StatusForbidden)
+		return
+	}
+
+	// streamed responses are captured in full, don't wait for a stream forever
+	ctx, cancel := context.WithTimeout(r.Context(), util.ReplayTimeout)
+	defer cancel()
+	replayed, err := s.Replay(ctx, funnelID, requestID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrFunnelNotFound):
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		case errors.Is(err, ErrRequestNotFound):
+			http.Error(w, "Request not found", http.StatusNotFound)
+		case errors.Is(err, ErrFunnelArchived), errors.Is(err, ErrReplayWebSocket), errors.Is(err, ErrReplayEventStream), errors.Is(err, ErrReplayBodyTruncated):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.Printf("Error replaying request %s for funnel %s: %v", requestID, funnelID, err)
+			http.Error(w, "Error replaying request", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	funnel, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+		http.Error(w, "Error retrieving funnel", http.StatusInternalServerError)
+		return
+	}
+	s.renderRequestDetailFragment(w, funnel, replayed)
+}
+
+// handleFunnelCompose serves the composer form, filled in from the capture named by
+// the request query parameter if there is one, and sends the requests written in it.
+func (s *HttpServer) handleFunnelCompose(w http.ResponseWriter, r *http.Request, funnelID string) {
+	funnel, err := s.liveFunnel(funnelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrFunnelNotFound):
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		case errors.Is(err, ErrFunnelArchived):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+			http.Error(w, "Error retrieving funnel", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	form := ComposeFormData{
+		FunnelID:    funnelID,
+		LocalTarget: funnel.LocalTarget(),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		form.Method = http.MethodGet
+		form.Path = "/"
+		if requestID := r.URL.Query().Get("request"); requestID != "" {
+			capturedRequest := findRequestInList(funnel.Requests, requestID)
+			if capturedRequest == nil {
+				http.Error(w, "Request not found", http.StatusNotFound)
+				return
+			}
+			composed, err := NewComposedRequest(funnel, *capturedRequest)
+			if err != nil {
+				s.logger.Printf("Error composing request %s for funnel %s: %v", requestID, funnelID, err)
+				http.Error(w, "Error composing request", http.StatusInternalServerError)
+				return
+			}
+			form.BasedOn = requestID
+			form.Method = composed.Method
+			form.Path = composed.Path
+			form.Headers = FormatHeaderLines(composed.Headers)
+			form.Body = string(composed.Body)
+			if capturedRequest.Request.BodyTruncated {
+				form.Notice = "The original body was " + BodySizeLabel(capturedRequest.Request.BodySize, len(capturedRequest.Request.Body), true) + ", only the captured part is sent."
+			}
+		}
+
+	case http.MethodPost:
+		// the target is only meant to be reachable through the funnel, other sites
+		// mustn't be able to send requests to it through the user's browser
+		if !s.sameOrigin(r) {
+			http.Error(w, "Cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+			return
+		}
+		form.BasedOn = r.PostForm.Get("based_on")
+		form.Method = r.PostForm.Get("method")
+		form.Path = r.PostForm.Get("path")
+		form.Headers = r.PostForm.Get("headers")
+		form.Body = r.PostForm.Get("body")
+
+		headers, err := ParseHeaderLines(form.Headers)
+		if err == nil {
+			// browsers send the line breaks in a textarea as CRLF
+			body := strings.ReplaceAll(form.Body, "\r\n", "\n")
+			var sent *CaptureRequestResponse
+			sent, err = s.Send(r.Context(), funnelID, ComposedRequest{Method: form.Method, Path: form.Path, Headers: headers, Body: []byte(body)}, form.BasedOn)
+			if err == nil {
+				s.renderRequestDetailFragment(w, funnel, sent)
+				return
+			}
+		}
+		// the form is shown again with the error, so nothing that was written is lost
+		form.Error = err.Error()
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = s.embeddedTemplates.ExecuteTemplate(w, "_compose_form.html", form)
+	if err != nil {
+		s.logger.Printf("Error executing compose form template: %v", err)
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+	}
+}
+
+// renderRequestDetailFragment writes the _request_detail_content.html fragment for a capture.
+func (s *HttpServer) renderRequestDetailFragment(w http.ResponseWriter, funnel Funnel, capturedRequest *CaptureRequestResponse) {
+	funnelID := funnel.ID()
+
 	var requestPath string
 	var queryParams []QueryParamEntry
 	parsedURL, err := url.Parse(capturedRequest.Request.URL)
@@ -229,37 +596,36 @@ func (s *HttpServer) handleFunnelRequestDetailFragment(w http.ResponseWriter, r
 		Path:         requestPath,
 		Method:       capturedRequest.Request.Method,
 		Status:       capturedRequest.Response.StatusCode,
-		Duration:     capturedRequest.Duration.String(),
+		Duration:     capturedRequest.DurationLabel(),
 		Time:         capturedRequest.Timestamp.Format("2006-01-02 15:04:05"),
 		ClientIP:     "N/A",
 		RequestBody:  string(capturedRequest.Request.Body),
 		ResponseBody: string(capturedRequest.Response.Body),
+		RequestSize:  BodySizeLabel(capturedRequest.Request.BodySize, len(capturedRequest.Request.Body), capturedRequest.Request.BodyTruncated),
+		ResponseSize: BodySizeLabel(capturedRequest.Response.BodySize, len(capturedRequest.Response.Body), capturedRequest.Response.BodyTruncated),
 		QueryParams:  queryParams,
+		WebSocket:    capturedRequest.WebSocket != nil,
+		Events:       capturedRequest.ServerSentEvents != nil,
+		InProgress:   capturedRequest.InProgress,
+		Error:        capturedRequest.Error,
+		Origin:       capturedRequest.Origin(),
+		Snippets:     SnippetFormats,
+		ReplayOf:     capturedRequest.ReplayOf,
+		CanEdit:      !funnel.Archived && capturedRequest.WebSocket == nil,
 	}
-
-	if xff, ok := capturedRequest.Request.Headers["X-Forwarded-For"]; ok && xff != "" {
-		details.ClientIP = strings.Split(xff, ",")[0]
-	} else if xri, ok := capturedRequest.Request.Headers["X-Real-Ip"]; ok && xri != "" {
-		details.ClientIP = xri
+	details.CanReplay = details.CanEdit && !capturedRequest.Request.BodyTruncated && capturedRequest.ServerSentEvents == nil
+	if capturedRequest.Error != "" {
+		details.ErrorDescription = capturedRequest.ErrorKind.Description()
 	}
 
-	requestHeaderNames := make([]string, 0, len(capturedRequest.Request.Headers))
-	for name := range capturedRequest.Request.Headers {
-		requestHeaderNames = append(requestHeaderNames, name)
-	}
-	sort.Strings(requestHeaderNames)
-	for _, name := range requestHeaderNames {
-		details.RequestHeaders = append(details.RequestHeaders, HeaderEntry{Name: name, Value: capturedRequest.Request.Headers[name]})
+	if xff := capturedRequest.Request.Headers.Get("X-Forwarded-For"); xff != "" {
+		details.ClientIP = strings.TrimSpace(strings.Split(xff, ",")[0])
+	} else if xri := capturedRequest.Request.Headers.Get("X-Real-Ip"); xri != "" {
+		details.ClientIP = xri
 	}
 
-	responseHeaderNames := make([]string, 0, len(capturedRequest.Response.Headers))
-	for name := range capturedRequest.Response.Headers {
-		responseHeaderNames = append(responseHeaderNames, name)
-	}
-	sort.Strings(responseHeaderNames)
-	for _, name := range responseHeaderNames {
-		details.ResponseHeaders = append(details.ResponseHeaders, HeaderEntry{Name: name, Value: capturedRequest.Response.Headers[name]})
-	}
+	details.RequestHeaders = headerEntries(capturedRequest.Request.Headers)
+	details.ResponseHeaders = headerEntries(capturedRequest.Response.Headers)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err = s.embeddedTemplates.ExecuteTemplate(w, "_request_detail_content.html", details)
@@ -269,6 +635,42 @@ func (s *HttpServer) handleFunnelRequestDetailFragment(w http.ResponseWriter, r
 	}
 }
 
+// handleFunnelRequestSnippet writes the request out as a command or code that sends
+// it again, e.g. a curl command, as plain text for the copy buttons.
+func (s *HttpServer) handleFunnelRequestSnippet(w http.ResponseWriter, r *http.Request, funnelID string, requestID string, format SnippetFormat) {
+	funnel, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		} else {
+			s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+			http.Error(w, "Error retrieving funnel", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	capturedRequest := findRequestInList(funnel.Requests, requestID)
+
+	if capturedRequest == nil {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	snippet, err := Snippet(format, *capturedRequest)
+	if err != nil {
+		if errors.Is(err, ErrUnknownSnippetFormat) {
+			http.Error(w, "Unknown snippet format", http.StatusNotFound)
+		} else {
+			s.logger.Printf("Error writing %s snippet for request %s: %v", format, requestID, err)
+			http.Error(w, "Error writing snippet", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(snippet))
+}
+
 func (s *HttpServer) handleFunnelRequestBodyFragment(w http.ResponseWriter, r *http.Request, funnelID string, requestID string) {
 	s.serveRequestOrResponseBody(w, r, funnelID, requestID, true)
 }
@@ -296,17 +698,49 @@ func (s *HttpServer) serveRequestOrResponseBody(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	var bodyStr string
+	var body []byte
+	var headers http.Header
+	var notice string
 	if isRequest {
-		bodyStr = string(capturedRequest.Request.Body)
+		body, headers = capturedRequest.Request.Body, capturedRequest.Request.Headers
+		if capturedRequest.Request.BodyTruncated {
+			notice = BodySizeLabel(capturedRequest.Request.BodySize, len(capturedRequest.Request.Body), true)
+		}
 	} else {
-		bodyStr = string(capturedRequest.Response.Body)
+		body, headers = capturedRequest.Response.Body, capturedRequest.Response.Headers
+		if capturedRequest.Response.BodyTruncated {
+			notice = BodySizeLabel(capturedRequest.Response.BodySize, len(capturedRequest.Response.Body), true)
+		}
 	}
+	formatted := FormatBody(body, headers)
 
 	data := struct {
-		Body string
+		URL         string // the fragment's own URL, polled while the body is still streaming
+		Body        string
+		Format      string
+		Notice      string // set when only part of the body was captured
+		Encoding    string // set when the body was decoded for display, e.g. gzip
+		DecodeError string
+		Size        string
+		Fields      []BodyFieldEntry
+		InProgress  bool
 	}{
-		Body: bodyStr,
+		URL:         r.URL.Path,
+		Body:        formatted.Text,
+		Format:      string(formatted.Format),
+		Notice:      notice,
+		Encoding:    formatted.Encoding,
+		DecodeError: formatted.DecodeError,
+		Size:        util.FormatBytes(int64(len(formatted.Decoded()))),
+		InProgress:  capturedRequest.InProgress && !isRequest,
+	}
+	for i, field := range formatted.Fields {
+		entry := BodyFieldEntry{Name: field.Name, Value: field.Value, ContentType: field.ContentType, Size: util.FormatBytes(int64(field.Size))}
+		if field.IsFile() {
+			entry.FileName = field.FileName
+			entry.DownloadURL = fmt.Sprintf("%s/part/%d", r.URL.Path, i)
+		}
+		data.Fields = append(data.Fields, entry)
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -316,3 +750,184 @@ func (s *HttpServer) serveRequestOrResponseBody(w http.ResponseWriter, r *http.R
 		http.Error(w, "Failed to render body content", http.StatusInternalServerError)
 	}
 }
+
+// inlineImageTypes are the image types shown in the body view.  SVG isn't one of them,
+// it can carry scripts and is shown as XML instead.
+var inlineImageTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true,
+	"image/avif": true, "image/bmp": true, "image/x-icon": true, "image/vnd.microsoft.icon": true,
+}
+
+// handleFunnelBodyDownload serves a captured body, decoded, or one of its multipart parts
+// when part isn't negative.  Images are served inline so the body view can show them,
+// anything else is downloaded, it must never be rendered by the inspector's origin.
+func (s *HttpServer) handleFunnelBodyDownload(w http.ResponseWriter, r *http.Request, funnelID string, requestID string, isRequest bool, part int) {
+	funnel, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		} else {
+			s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+			http.Error(w, "Error retrieving funnel", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	capturedRequest := findRequestInList(funnel.Requests, requestID)
+	if capturedRequest == nil {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	direction := "response"
+	body, headers := capturedRequest.Response.Body, capturedRequest.Response.Headers
+	if isRequest {
+		direction = "request"
+		body, headers = capturedRequest.Request.Body, capturedRequest.Request.Headers
+	}
+	formatted := FormatBody(body, headers)
+
+	data := formatted.Decoded()
+	filename := fmt.Sprintf("%s-%s%s", requestID, direction, bodyFileExtension(formatted.MediaType))
+	if part >= 0 {
+		if part >= len(formatted.Fields) {
+			http.Error(w, "Part not found", http.StatusNotFound)
+			return
+		}
+		field := formatted.Fields[part]
+		data = field.Data()
+		filename = fmt.Sprintf("%s-%s-part%d%s", requestID, direction, part, bodyFileExtension(field.ContentType))
+		if field.FileName != "" {
+			filename = path.Base(field.FileName)
+		}
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if part < 0 && formatted.Format == BodyImage && inlineImageTypes[formatted.MediaType] {
+		w.Header().Set("Content-Type", formatted.MediaType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	_, _ = w.Write(data)
+}
+
+// bodyFileExtension returns the usual file extension for a content type, .bin when there isn't one.
+func bodyFileExtension(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ".bin"
+}
+
+func (s *HttpServer) handleFunnelWebSocketFramesFragment(w http.ResponseWriter, r *http.Request, funnelID string, requestID string) {
+	funnel, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		} else {
+			s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+			http.Error(w, "Error retrieving funnel", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	capturedRequest := findRequestInList(funnel.Requests, requestID)
+
+	if capturedRequest == nil || capturedRequest.WebSocket == nil {
+		http.Error(w, "WebSocket connection not found", http.StatusNotFound)
+		return
+	}
+
+	data := struct {
+		FunnelID string
+		UUID     string
+		Count    int
+		Closed   bool
+		Frames   []WebSocketFrameEntry
+	}{
+		FunnelID: funnelID,
+		UUID:     capturedRequest.ID,
+		Count:    capturedRequest.WebSocket.Count(),
+		Closed:   capturedRequest.WebSocket.Closed(),
+	}
+
+	for _, frame := range capturedRequest.WebSocket.Frames() {
+		directionClass := "out"
+		if frame.Direction == WebSocketServerToClient {
+			directionClass = "in"
+		}
+		data.Frames = append(data.Frames, WebSocketFrameEntry{
+			Time:           frame.Timestamp.Format("15:04:05.000"),
+			Arrow:          frame.Direction.Arrow(),
+			Direction:      frame.Direction.String(),
+			DirectionClass: directionClass,
+			Type:           frame.Type(),
+			Size:           util.FormatBytes(frame.Size),
+			Preview:        frame.Preview(0),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = s.embeddedTemplates.ExecuteTemplate(w, "_websocket_frames.html", data)
+	if err != nil {
+		s.logger.Printf("Error executing websocket frames template: %v", err)
+		http.Error(w, "Failed to render websocket frames", http.StatusInternalServerError)
+	}
+}
+
+func (s *HttpServer) handleFunnelSSEEventsFragment(w http.ResponseWriter, r *http.Request, funnelID string, requestID string) {
+	funnel, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		if errors.Is(err, ErrFunnelNotFound) {
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+		} else {
+			s.logger.Printf("Error retrieving funnel %s: %v", funnelID, err)
+			http.Error(w, "Error retrieving funnel", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	capturedRequest := findRequestInList(funnel.Requests, requestID)
+
+	if capturedRequest == nil || capturedRequest.ServerSentEvents == nil {
+		http.Error(w, "Event stream not found", http.StatusNotFound)
+		return
+	}
+
+	data := struct {
+		FunnelID   string
+		UUID       string
+		Count      int
+		InProgress bool
+		Events     []SSEEventEntry
+	}{
+		FunnelID:   funnelID,
+		UUID:       capturedRequest.ID,
+		Count:      capturedRequest.ServerSentEvents.Count(),
+		InProgress: capturedRequest.InProgress,
+	}
+
+	for _, event := range capturedRequest.ServerSentEvents.Events() {
+		eventData := event.Data
+		if event.Truncated() {
+			eventData += "…"
+		}
+		data.Events = append(data.Events, SSEEventEntry{
+			Time:  event.Timestamp.Format("15:04:05.000"),
+			ID:    event.ID,
+			Event: event.Event,
+			Size:  util.FormatBytes(event.Size),
+			Data:  eventData,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = s.embeddedTemplates.ExecuteTemplate(w, "_sse_events.html", data)
+	if err != nil {
+		s.logger.Printf("Error executing sse events template: %v", err)
+		http.Error(w, "Failed to render events", http.StatusInternalServerError)
+	}
+}
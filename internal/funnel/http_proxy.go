@@ -1,7 +1,7 @@
 package funnel
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,8 +11,13 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jonson/tsgrok/internal/util"
 )
 
+// liveUpdateInterval is how often an in progress capture is refreshed in the list
+// and the program notified, however fast data arrives.
+const liveUpdateInterval = 250 * time.Millisecond
+
 func (s *HttpServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 	pathAfterPrefix := strings.TrimPrefix(r.URL.Path, HttpServerPath)
 
@@ -38,7 +43,7 @@ func (s *HttpServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	funnel, err := s.GetFunnelById(funnelIdAndRest.id)
 	if err != nil {
-		if err == ErrFunnelNotFound { // Direct comparison
+		if errors.Is(err, ErrFunnelNotFound) {
 			http.Error(w, ErrFunnelNotFound.Error(), http.StatusNotFound)
 		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError) // Catch-all for other errors from GetFunnelById
@@ -46,6 +51,18 @@ func (s *HttpServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if funnel.Archived {
+		// archived funnels only exist in the inspector
+		http.Error(w, ErrFunnelNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.proxyToTarget(w, r, funnel, funnelIdAndRest.rest, CaptureRequestResponse{ID: uuid.New().String()})
+}
+
+// proxyToTarget forwards r to rest under the funnel's local target, recording the
+// exchange in a capture that starts out as requestResponse, which must have an ID.
+func (s *HttpServer) proxyToTarget(w http.ResponseWriter, r *http.Request, funnel Funnel, rest string, requestResponse CaptureRequestResponse) {
 	targetURLStr := funnel.LocalTarget()
 	if targetURLStr == "" {
 		http.Error(w, ErrFunnelNotReady.Error(), http.StatusNotFound)
@@ -61,92 +78,169 @@ func (s *HttpServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 	proxy.ErrorLog = s.logger
+	proxy.Transport = funnel.Transport()
 
 	originalDirector := proxy.Director
 
-	requestResponse := CaptureRequestResponse{
-		ID:        uuid.New().String(),
-		FunnelID:  funnel.HTTPFunnel.id,
-		Timestamp: time.Now(),
-	}
+	requestResponse.FunnelID = funnel.HTTPFunnel.id
+	requestResponse.Timestamp = time.Now()
+
+	captureLimit := funnel.BodyCaptureLimit()
+	var reqCapture, respCapture *captureBuffer
 
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
 
-		var reqBodyBytes []byte
-		var err error
+		// stream the body to the target, keeping only the first captureLimit bytes
 		if req.Body != nil && req.Body != http.NoBody {
-			reqBodyBytes, err = io.ReadAll(req.Body)
-			if err != nil {
-				s.logger.Printf("Error reading request body: %v", err)
-			} else {
-				err = req.Body.Close()
-				if err != nil {
-					s.logger.Printf("Error closing request body: %v", err)
-				}
-				req.Body = io.NopCloser(bytes.NewReader(reqBodyBytes))
-				req.ContentLength = int64(len(reqBodyBytes))
-				req.GetBody = nil
-			}
+			reqCapture = newCaptureBuffer(captureLimit)
+			req.Body = newTeeReadCloser(req.Body, reqCapture)
 		}
 
 		req.URL.Scheme = targetURL.Scheme
 		req.URL.Host = targetURL.Host
-		req.URL.Path = singleJoiningSlash(targetURL.Path, funnelIdAndRest.rest)
+		req.URL.Path = singleJoiningSlash(targetURL.Path, rest)
 		req.Host = targetURL.Host
 
 		if targetURL.RawPath == "" {
 			req.URL.RawPath = ""
 		}
 
-		headers := make(map[string]string)
-		for k, v := range req.Header {
-			headers[k] = strings.Join(v, ",")
-		}
-
 		requestResponse.Request = CaptureRequest{
 			Method:  req.Method,
 			URL:     req.URL.String(),
-			Body:    reqBodyBytes,
-			Headers: headers,
+			Headers: req.Header.Clone(),
 		}
 	}
 
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		headers := make(map[string]string)
-		for k, v := range resp.Header {
-			headers[k] = strings.Join(v, ",")
-		}
+	// captures of streams and upgraded connections are added to the list as soon as
+	// the response headers arrive, then updated as the body or connection progresses
+	live := false
+	var updates *updateThrottle
+	startLive := func() {
+		live = true
+		requestResponse.InProgress = true
+		requestResponse.Duration = time.Since(requestResponse.Timestamp)
+
+		id := requestResponse.ID
+		updates = newUpdateThrottle(liveUpdateInterval, func() {
+			// always update, so frames and events logged since are accounted for too
+			found := funnel.Requests.Update(id, func(c *CaptureRequestResponse) {
+				if respCapture != nil {
+					c.Response.Body = respCapture.Bytes()
+					c.Response.BodySize = respCapture.Total()
+					c.Response.BodyTruncated = respCapture.Truncated()
+				}
+			})
+			if found {
+				s.events.Publish(CaptureUpdateMsg{FunnelId: funnel.HTTPFunnel.id, RequestId: id})
+			}
+		})
 
+		funnel.Requests.Add(requestResponse)
+		s.events.Publish(ProxyRequestMsg{FunnelId: funnel.HTTPFunnel.id, RequestId: id})
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
 		requestResponse.Response = CaptureResponse{
-			Headers:    headers,
+			Headers:    resp.Header.Clone(),
 			StatusCode: resp.StatusCode,
 		}
 
-		var respBodyBytes []byte
-		var err error
-		if resp.Body != nil && resp.Body != http.NoBody {
-			respBodyBytes, err = io.ReadAll(resp.Body)
-			if err != nil {
-				return fmt.Errorf("failed to read response body: %w", err)
-			} else {
-				err = resp.Body.Close()
-				if err != nil {
-					s.logger.Printf("Error closing response body: %v", err)
-				}
-				resp.Body = io.NopCloser(bytes.NewReader(respBodyBytes))
-				resp.ContentLength = int64(len(respBodyBytes))
-				resp.Header.Del("Transfer-Encoding")
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			// the body is the connection to the target, it must stay writable for the
+			// proxy to copy the client's side of the conversation into it
+			conn, ok := resp.Body.(io.ReadWriteCloser)
+			if ok && isWebSocketUpgrade(resp.Header) {
+				requestResponse.WebSocket = newWebSocketLog(util.DefaultMaxWebSocketFrames)
+			}
+
+			// ServeHTTP doesn't return until the connection closes
+			startLive()
+			if requestResponse.WebSocket != nil {
+				resp.Body = newWebSocketTap(conn, requestResponse.WebSocket, util.WebSocketPreviewBytes, updates.trigger)
 			}
+			return nil
 		}
 
-		requestResponse.Response.Body = respBodyBytes
-		requestResponse.Duration = time.Since(requestResponse.Timestamp)
+		if resp.Body == nil || resp.Body == http.NoBody {
+			return nil
+		}
+
+		// the body is captured as it is copied to the client, rather than read up front
+		respCapture = newCaptureBuffer(captureLimit)
+		if !isStreamingResponse(resp) {
+			resp.Body = newTeeReadCloser(resp.Body, respCapture)
+			return nil
+		}
+
+		// streams may never end, so show what has arrived so far
+		var capture io.Writer = respCapture
+		if isEventStream(resp.Header) {
+			events := newSSELog(util.DefaultMaxSSEEvents)
+			requestResponse.ServerSentEvents = events
+			capture = io.MultiWriter(respCapture, &sseParser{previewLimit: util.SSEPreviewBytes, onEvent: events.add})
+		}
+		startLive()
+		resp.Body = newTeeReadCloser(resp.Body, io.MultiWriter(capture, triggerWriter(updates.trigger)))
 		return nil
 	}
 
-	proxy.ServeHTTP(w, r)
+	proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
+		kind := classifyProxyError(err)
+		if kind != ProxyErrorCanceled {
+			s.logger.Printf("Error proxying request for funnel %s to %s: %v", funnel.ID(), targetURLStr, err)
+		}
+
+		requestResponse.Error = err.Error()
+		requestResponse.ErrorKind = kind
+		requestResponse.Response = CaptureResponse{StatusCode: kind.StatusCode()}
+		if kind != ProxyErrorCanceled {
+			s.writeProxyError(rw, req, funnel, kind)
+		}
+	}
 
-	funnel.Requests.Add(requestResponse)
-	s.messageBus.Send(ProxyRequestMsg{FunnelId: funnel.HTTPFunnel.id})
+	// deferred, as the proxy panics with http.ErrAbortHandler when the client goes
+	// away mid-response, and the capture still has to be completed
+	defer func() {
+		requestResponse.Duration = time.Since(requestResponse.Timestamp)
+		if reqCapture != nil {
+			requestResponse.Request.Body = reqCapture.Bytes()
+			requestResponse.Request.BodySize = reqCapture.Total()
+			requestResponse.Request.BodyTruncated = reqCapture.Truncated()
+		}
+		if respCapture != nil {
+			requestResponse.Response.Body = respCapture.Bytes()
+			requestResponse.Response.BodySize = respCapture.Total()
+			requestResponse.Response.BodyTruncated = respCapture.Truncated()
+		}
+
+		if s.store != nil {
+			if err := s.store.Save(funnel, requestResponse); err != nil {
+				s.logger.Printf("Error saving capture for funnel %s: %v", funnel.ID(), err)
+				s.events.Publish(ErrorMsg{FunnelId: funnel.ID(), Err: fmt.Errorf("saving capture: %w", err)})
+			}
+		}
+
+		if live {
+			updates.stop()
+			requestResponse.InProgress = false
+			found := funnel.Requests.Update(requestResponse.ID, func(c *CaptureRequestResponse) {
+				*c = requestResponse
+			})
+			if found {
+				s.events.Publish(CaptureUpdateMsg{FunnelId: funnel.HTTPFunnel.id, RequestId: requestResponse.ID})
+				return
+			}
+			// pushed out by newer captures while it was open, it is added again as
+			// the newest, like any other capture that just finished
+		}
+
+		funnel.Requests.Add(requestResponse)
+		s.events.Publish(ProxyRequestMsg{FunnelId: funnel.HTTPFunnel.id, RequestId: requestResponse.ID})
+	}()
+
+	// ServeHTTP returns once the response body has been fully streamed to the client,
+	// or the upgraded connection has closed
+	proxy.ServeHTTP(w, r)
 }
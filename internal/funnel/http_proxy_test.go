@@ -0,0 +1,163 @@
+package funnel
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// recordingBus collects the events published by the server, and hands them to
+// its subscribers before Publish returns so tests don't have to wait for them.
+type recordingBus struct {
+	mu             sync.Mutex
+	msgs           []util.Event
+	subscribers    map[int]func(util.Event)
+	nextSubscriber int
+}
+
+func (b *recordingBus) Publish(event util.Event) {
+	b.mu.Lock()
+	b.msgs = append(b.msgs, event)
+	subscribers := make([]func(util.Event), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+func (b *recordingBus) Subscribe(fn func(util.Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(util.Event))
+	}
+	key := b.nextSubscriber
+	b.nextSubscriber++
+	b.subscribers[key] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, key)
+	}
+}
+
+// testInspectorPort is the port test servers are told they listen on, they never do.
+const testInspectorPort = 4040
+
+// newInspectorRequest returns a request addressed to the test server's inspector,
+// as the browser sends them.
+func newInspectorRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Host = fmt.Sprintf("localhost:%d", testInspectorPort)
+	return req
+}
+
+// newTestProxy returns an HttpServer with a single funnel proxying to target.
+func newTestProxy(t *testing.T, target string, transport *http.Transport) (*HttpServer, Funnel) {
+	t.Helper()
+
+	registry := NewFunnelRegistry()
+	f := Funnel{
+		HTTPFunnel: &HTTPFunnel{
+			id:          "test-funnel",
+			localTarget: target,
+			transport:   transport,
+			inspect:     true,
+		},
+		Requests: &RequestList{maxLength: 10},
+	}
+	registry.AddFunnel(f)
+
+	s, err := NewHttpServer(testInspectorPort, &recordingBus{}, registry, stdlog.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("NewHttpServer() unexpected error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, f
+}
+
+func TestHandleRequest_HTTPSTarget(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		_, _ = w.Write([]byte("echo:" + string(body)))
+	}))
+	defer backend.Close()
+
+	transport, err := newLocalTransport(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, f := newTestProxy(t, backend.URL, transport)
+
+	req := httptest.NewRequest(http.MethodPost, HttpServerPath+"test-funnel/hello?x=1", strings.NewReader("ping"))
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Body.String(); got != "echo:ping" {
+		t.Errorf("Expected body %q, got %q", "echo:ping", got)
+	}
+
+	if f.Requests.Length != 1 {
+		t.Fatalf("Expected 1 captured request, got %d", f.Requests.Length)
+	}
+	captured := f.Requests.Head.Request
+	if captured.Path() != "/hello" {
+		t.Errorf("Expected captured path /hello, got %q", captured.Path())
+	}
+	if string(captured.Request.Body) != "ping" || string(captured.Response.Body) != "echo:ping" {
+		t.Errorf("Unexpected captured bodies %q / %q", captured.Request.Body, captured.Response.Body)
+	}
+	if got := captured.Response.Headers.Values("Set-Cookie"); len(got) != 2 || got[0] != "a=1" || got[1] != "b=2" {
+		t.Errorf("Expected both Set-Cookie headers to be captured separately, got %q", got)
+	}
+}
+
+func TestHandleRequest_BoundedCapture(t *testing.T) {
+	responseBody := strings.Repeat("r", 1000)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(body) != 500 {
+			t.Errorf("Backend received %d bytes, want 500", len(body))
+		}
+		_, _ = w.Write([]byte(responseBody))
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL, nil)
+	f.MaxBodyBytes = 16
+	s.funnelRegistry.AddFunnel(f)
+
+	req := httptest.NewRequest(http.MethodPut, HttpServerPath+"test-funnel/upload", strings.NewReader(strings.Repeat("q", 500)))
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, req)
+
+	if got := rec.Body.String(); got != responseBody {
+		t.Fatalf("Client received %d bytes, want the full %d byte body", len(got), len(responseBody))
+	}
+
+	captured := f.Requests.Head.Request
+	if len(captured.Request.Body) != 16 || captured.Request.BodySize != 500 || !captured.Request.BodyTruncated {
+		t.Errorf("Unexpected request capture: %d bytes kept, size %d, truncated %v",
+			len(captured.Request.Body), captured.Request.BodySize, captured.Request.BodyTruncated)
+	}
+	if len(captured.Response.Body) != 16 || captured.Response.BodySize != 1000 || !captured.Response.BodyTruncated {
+		t.Errorf("Unexpected response capture: %d bytes kept, size %d, truncated %v",
+			len(captured.Response.Body), captured.Response.BodySize, captured.Response.BodyTruncated)
+	}
+}
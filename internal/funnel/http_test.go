@@ -1,6 +1,9 @@
 package funnel
 
 import (
+	"io"
+	stdlog "log"
+	"net/http"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -149,3 +152,48 @@ func Test_singleJoiningSlash(t *testing.T) {
 		})
 	}
 }
+
+func Test_headerEntries(t *testing.T) {
+	headers := http.Header{
+		"Set-Cookie":   {"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT"},
+		"Content-Type": {"text/html"},
+	}
+
+	want := []HeaderEntry{
+		{Name: "Content-Type", Value: "text/html"},
+		{Name: "Set-Cookie", Value: "a=1; Path=/"},
+		{Name: "Set-Cookie", Value: "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT"},
+	}
+	if diff := cmp.Diff(want, headerEntries(headers)); diff != "" {
+		t.Errorf("headerEntries() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := headerEntries(nil); len(got) != 0 {
+		t.Errorf("headerEntries(nil) = %v, want no entries", got)
+	}
+}
+
+func TestHttpServer_CloseReleasesSubscriptions(t *testing.T) {
+	bus := &recordingBus{}
+	registry := NewFunnelRegistry()
+	s, err := NewHttpServer(testInspectorPort, bus, registry, stdlog.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("NewHttpServer() unexpected error: %v", err)
+	}
+	if len(bus.subscribers) != 1 || len(registry.subscribers) != 1 {
+		t.Fatalf("Expected a subscription each, got %d events and %d registry", len(bus.subscribers), len(registry.subscribers))
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() unexpected error: %v", err)
+	}
+	if len(bus.subscribers) != 0 || len(registry.subscribers) != 0 {
+		t.Errorf("Expected no subscriptions after Close, got %d events and %d registry", len(bus.subscribers), len(registry.subscribers))
+	}
+
+	// the registry's changes no longer reach the bus
+	registry.AddFunnel(Funnel{HTTPFunnel: &HTTPFunnel{id: "a"}})
+	if len(bus.msgs) != 0 {
+		t.Errorf("Expected no events after Close, got %v", bus.msgs)
+	}
+}
@@ -8,6 +8,14 @@ var (
 	ErrFunnelNotFound    = errors.New("funnel not found")
 	ErrFunnelNotReady    = errors.New("funnel has no local target configured")
 	ErrTargetURLParse    = errors.New("failed to parse funnel target URL")
+	ErrFunnelArchived    = errors.New("funnel is archived, its captures are read-only")
+	ErrRequestNotFound   = errors.New("request not found")
+
+	ErrReplayWebSocket      = errors.New("websocket connections can't be replayed")
+	ErrReplayEventStream    = errors.New("event streams can't be replayed")
+	ErrReplayBodyTruncated  = errors.New("only part of the request body was captured, it can't be replayed")
+	ErrReplayCaptureMissing = errors.New("request was sent but its capture was evicted straight away")
+	ErrComposeAbsolutePath  = errors.New("path must be relative to the local target, e.g. /hooks?id=1")
 )
 
 // DisplayFunnel is used for displaying funnel information in the inspector.
@@ -15,6 +23,17 @@ type DisplayFunnel struct {
 	ID          string
 	LocalTarget string
 	RemoteURL   string
+	Inspect     bool
+	Archived    bool
+}
+
+// FunnelFormData is the new funnel form on the inspector's index page.
+type FunnelFormData struct {
+	Name       string
+	Target     string
+	RemotePort uint16
+	Inspect    bool
+	Error      string // why the funnel couldn't be created
 }
 
 // HeaderEntry is used for displaying request/response headers.
@@ -43,7 +62,65 @@ type ClientRequestDetails struct {
 	ResponseHeaders []HeaderEntry
 	RequestBody     string
 	ResponseBody    string
+	RequestSize     string // human readable body size, noting truncation
+	ResponseSize    string // human readable body size, noting truncation
 	QueryParams     []QueryParamEntry
+	WebSocket       bool // the request was upgraded to a websocket, its frames are served separately
+	Events          bool // the response is a text/event-stream, its events are served separately
+	InProgress      bool // the response is still streaming or the connection is still open
+
+	Error            string // why the target couldn't be reached, empty when it responded
+	ErrorDescription string
+
+	Origin    string // "replay", "edited" or "composed" for requests that didn't come through the funnel
+	ReplayOf  string // the capture this request was replayed or edited from
+	CanReplay bool   // the request can be sent to the local target again
+	CanEdit   bool   // the request can be edited in the composer
+
+	Snippets []SnippetFormat // the request can be copied as each of these
+}
+
+// ComposeFormData is the structure passed to the _compose_form.html template.
+type ComposeFormData struct {
+	FunnelID    string
+	LocalTarget string
+	BasedOn     string // the capture being edited, empty for a new request
+	Method      string
+	Path        string
+	Headers     string // one "Name: value" per line
+	Body        string
+	Notice      string // e.g. only part of the original body was captured
+	Error       string // why the request couldn't be sent
+}
+
+// BodyFieldEntry is used for displaying a form field or multipart part of a body.
+type BodyFieldEntry struct {
+	Name        string
+	Value       string
+	FileName    string
+	ContentType string
+	Size        string
+	DownloadURL string // set for file parts, which aren't shown
+}
+
+// SSEEventEntry is used for displaying a captured server-sent event.
+type SSEEventEntry struct {
+	Time  string
+	ID    string
+	Event string
+	Size  string
+	Data  string
+}
+
+// WebSocketFrameEntry is used for displaying a captured websocket frame.
+type WebSocketFrameEntry struct {
+	Time           string
+	Arrow          string
+	Direction      string
+	DirectionClass string // "out" for frames sent to the target, "in" for frames received from it
+	Type           string
+	Size           string
+	Preview        string
 }
 
 // FunnelIdAndRest holds the extracted funnel ID and the rest of the path.
@@ -0,0 +1,140 @@
+package funnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// liveEventBuffer is how many events a browser may fall behind by before newer
+// ones are dropped.  Every event only prompts the page to refresh what it shows,
+// so the next one delivered catches the page up.
+const liveEventBuffer = 16
+
+// liveKeepAliveInterval is how often an idle event stream is written to, so
+// proxies in between don't time it out.
+const liveKeepAliveInterval = 30 * time.Second
+
+// liveEvent is pushed to the browsers watching the web inspector.
+type liveEvent struct {
+	Name      string `json:"-"`      // the SSE event, "funnel" or "request"
+	Change    string `json:"change"` // added, removed, updated, or cleared for requests
+	FunnelID  string `json:"funnel"`
+	RequestID string `json:"request,omitempty"`
+}
+
+// liveUpdates fans events out to the event streams of the web inspector.
+type liveUpdates struct {
+	mu          sync.Mutex
+	subscribers map[*liveSubscriber]struct{}
+}
+
+// liveSubscriber is an open event stream.  Without a funnel it follows every
+// funnel being added, removed or updated, for the funnel list; with one it
+// follows that funnel and its requests.
+type liveSubscriber struct {
+	funnelID string
+	events   chan liveEvent
+}
+
+func newLiveUpdates() *liveUpdates {
+	return &liveUpdates{subscribers: make(map[*liveSubscriber]struct{})}
+}
+
+func (l *liveUpdates) subscribe(funnelID string) *liveSubscriber {
+	sub := &liveSubscriber{funnelID: funnelID, events: make(chan liveEvent, liveEventBuffer)}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (l *liveUpdates) unsubscribe(sub *liveSubscriber) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.subscribers, sub)
+}
+
+// publish hands the event to every subscriber interested in it, without waiting
+// for any of them.
+func (l *liveUpdates) publish(e liveEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for sub := range l.subscribers {
+		if sub.funnelID == "" && e.Name != "funnel" {
+			continue
+		}
+		if sub.funnelID != "" && sub.funnelID != e.FunnelID {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default: // falling behind, see liveEventBuffer
+		}
+	}
+}
+
+// follow is the live updates' subscription to the server's events.
+func (l *liveUpdates) follow(event util.Event) {
+	switch e := event.(type) {
+	case FunnelChangedMsg:
+		l.publish(liveEvent{Name: "funnel", Change: string(e.Change), FunnelID: e.FunnelId})
+	case ProxyRequestMsg:
+		l.publish(liveEvent{Name: "request", Change: "added", FunnelID: e.FunnelId, RequestID: e.RequestId})
+	case CaptureUpdateMsg:
+		l.publish(liveEvent{Name: "request", Change: "updated", FunnelID: e.FunnelId, RequestID: e.RequestId})
+	case RequestsClearedMsg:
+		l.publish(liveEvent{Name: "request", Change: "cleared", FunnelID: e.FunnelId})
+	}
+}
+
+// handleLiveEvents streams the inspector's live updates as server-sent events, of
+// every funnel for the funnel list, or of a single funnel and its requests when
+// the funnel query parameter is given.
+func (s *HttpServer) handleLiveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	funnelID := r.URL.Query().Get("funnel")
+	if funnelID != "" {
+		if _, err := s.GetFunnelById(funnelID); err != nil {
+			http.Error(w, "Funnel not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	sub := s.live.subscribe(funnelID)
+	defer s.live.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, "retry: 2000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-sub.events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				s.logger.Printf("Error encoding live event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, data)
+		}
+		flusher.Flush()
+	}
+}
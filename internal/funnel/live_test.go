@@ -0,0 +1,161 @@
+package funnel
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLiveEvent returns the next event and its data from an event stream.
+func readLiveEvent(t *testing.T, events *bufio.Reader) (string, string) {
+	t.Helper()
+	var name, data string
+	for {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading the event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// openLiveEvents connects to the server's event stream with the given query.
+func openLiveEvents(t *testing.T, s *HttpServer, query string) *bufio.Reader {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(s.handleLiveEvents))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// waitForSubscribers waits for n event streams to be subscribed, so nothing
+// published after is missed.
+func waitForSubscribers(t *testing.T, s *HttpServer, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.live.mu.Lock()
+		subscribed := len(s.live.subscribers)
+		s.live.mu.Unlock()
+		if subscribed == n {
+			return
+		}
+	}
+	t.Fatalf("Expected %d event streams to subscribe", n)
+}
+
+func TestHandleLiveEvents(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	s, _ := newTestProxy(t, backend.URL, nil)
+
+	funnelEvents := openLiveEvents(t, s, "?funnel=test-funnel")
+	indexEvents := openLiveEvents(t, s, "")
+	waitForSubscribers(t, s, 2)
+
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, httptest.NewRequest(http.MethodGet, HttpServerPath+"test-funnel/hello", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the request to be proxied, got %d", rec.Code)
+	}
+
+	name, data := readLiveEvent(t, funnelEvents)
+	if name != "request" || !strings.Contains(data, `"change":"added"`) || !strings.Contains(data, `"funnel":"test-funnel"`) {
+		t.Errorf("Expected the captured request, got %s %s", name, data)
+	}
+
+	// the funnel list only follows the funnels
+	s.funnelRegistry.AddFunnel(Funnel{HTTPFunnel: &HTTPFunnel{id: "other"}})
+	s.funnelRegistry.FunnelUpdated("test-funnel")
+	s.funnelRegistry.RemoveFunnel("other")
+	for _, want := range []string{`{"change":"added","funnel":"other"}`, `{"change":"updated","funnel":"test-funnel"}`, `{"change":"removed","funnel":"other"}`} {
+		if name, data := readLiveEvent(t, indexEvents); name != "funnel" || data != want {
+			t.Errorf("Expected funnel event %s, got %s %s", want, name, data)
+		}
+	}
+
+	// a funnel's page only follows that funnel
+	if name, data := readLiveEvent(t, funnelEvents); name != "funnel" || data != `{"change":"updated","funnel":"test-funnel"}` {
+		t.Errorf("Expected the funnel's update, got %s %s", name, data)
+	}
+
+	// clearing the requests, e.g. through the API, reloads the request list
+	rec = httptest.NewRecorder()
+	s.handleAPI(rec, newInspectorRequest(http.MethodDelete, APIPath+"funnels/test-funnel/requests", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the requests to be cleared, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if name, data := readLiveEvent(t, funnelEvents); name != "request" || data != `{"change":"cleared","funnel":"test-funnel"}` {
+		t.Errorf("Expected the requests to be cleared, got %s %s", name, data)
+	}
+}
+
+func TestHandleLiveEvents_UnknownFunnel(t *testing.T) {
+	s, _ := newTestProxy(t, "http://localhost:8080", nil)
+	rec := httptest.NewRecorder()
+	s.handleLiveEvents(rec, httptest.NewRequest(http.MethodGet, "/events?funnel=nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
+
+func TestLiveUpdates_SlowSubscriber(t *testing.T) {
+	live := newLiveUpdates()
+	sub := live.subscribe("f")
+	for range liveEventBuffer * 2 {
+		live.follow(ProxyRequestMsg{FunnelId: "f", RequestId: "r"}) // must not block on a subscriber that isn't reading
+	}
+	if len(sub.events) != liveEventBuffer {
+		t.Errorf("Expected %d events buffered, got %d", liveEventBuffer, len(sub.events))
+	}
+}
+
+func TestServeFunnelRequestsPage_RequestList(t *testing.T) {
+	s, f := newTestProxy(t, "http://localhost:8080", nil)
+	f.Requests.Add(CaptureRequestResponse{ID: "ok", Timestamp: time.Now(), Request: CaptureRequest{Method: http.MethodGet, URL: "http://localhost:8080/ok"}, Response: CaptureResponse{StatusCode: 200}})
+	f.Requests.Add(CaptureRequestResponse{ID: "broken", Timestamp: time.Now(), Duration: 1234567 * time.Microsecond, Request: CaptureRequest{Method: http.MethodPost, URL: "http://localhost:8080/broken"}, Response: CaptureResponse{StatusCode: 500}})
+
+	rec := httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel/requests?q=status%3A5xx", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "<html") || !strings.Contains(body, `data-request-id="broken"`) || strings.Contains(body, `data-request-id="ok"`) {
+		t.Errorf("Expected only the matching requests, got:\n%s", body)
+	}
+	if !strings.Contains(body, `id="filter-status" hx-swap-oob="true"`) || !strings.Contains(body, "1 of 2 requests") {
+		t.Errorf("Expected the filter's count to be swapped out of band, got:\n%s", body)
+	}
+	if !strings.Contains(body, `<span class="duration">1.2s</span>`) {
+		t.Errorf("Expected the duration rounded as in the TUI, got:\n%s", body)
+	}
+}
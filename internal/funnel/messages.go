@@ -1,5 +1,51 @@
 package funnel
 
+// The events published on the server's util.EventBus, followed by the TUI, the
+// web inspector's live updates and the headless console output.
+
+// ProxyRequestMsg is published when a request is captured.  Streamed responses
+// and upgraded connections are published once their headers arrive, then
+// followed by CaptureUpdateMsg.
 type ProxyRequestMsg struct {
+	FunnelId  string
+	RequestId string
+}
+
+// RequestsClearedMsg is published when a funnel's captured requests are cleared,
+// e.g. through the API.
+type RequestsClearedMsg struct {
+	FunnelId string
+}
+
+func (m RequestsClearedMsg) CoalesceKey() string { return "cleared/" + m.FunnelId }
+
+// FunnelChangedMsg is published when a funnel is added to, removed from or
+// updated in the registry, from the TUI, the web inspector or the API.
+type FunnelChangedMsg struct {
+	FunnelId string
+	Change   FunnelChange
+}
+
+// CoalesceKey only merges updates, a funnel being added or removed always counts.
+func (m FunnelChangedMsg) CoalesceKey() string {
+	if m.Change != FunnelUpdated {
+		return ""
+	}
+	return "funnel/" + m.FunnelId
+}
+
+// CaptureUpdateMsg is published when an in progress capture, a stream or an
+// upgraded connection, receives more data, and once more when it completes.
+type CaptureUpdateMsg struct {
+	FunnelId  string
+	RequestId string
+}
+
+func (m CaptureUpdateMsg) CoalesceKey() string { return "capture/" + m.FunnelId + "/" + m.RequestId }
+
+// ErrorMsg is published when something fails in the background, with nobody
+// waiting on the result to be told, e.g. saving a capture.
+type ErrorMsg struct {
 	FunnelId string
+	Err      error
 }
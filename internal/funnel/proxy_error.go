@@ -0,0 +1,142 @@
+package funnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// ProxyErrorKind classifies why a request couldn't be proxied to the local target.
+type ProxyErrorKind string
+
+const (
+	ProxyErrorRefused  ProxyErrorKind = "refused"  // nothing is listening on the target
+	ProxyErrorTimeout  ProxyErrorKind = "timeout"  // the target didn't answer in time
+	ProxyErrorTLS      ProxyErrorKind = "tls"      // the TLS handshake with an https target failed
+	ProxyErrorDNS      ProxyErrorKind = "dns"      // the target's host name couldn't be resolved
+	ProxyErrorCanceled ProxyErrorKind = "canceled" // the public client went away first
+	ProxyErrorOther    ProxyErrorKind = "error"
+)
+
+// Description is a short human readable explanation of the failure.
+func (k ProxyErrorKind) Description() string {
+	switch k {
+	case ProxyErrorRefused:
+		return "connection refused"
+	case ProxyErrorTimeout:
+		return "timed out waiting for the target"
+	case ProxyErrorTLS:
+		return "TLS handshake with the target failed"
+	case ProxyErrorDNS:
+		return "target host could not be resolved"
+	case ProxyErrorCanceled:
+		return "client canceled the request"
+	}
+	return "proxy error"
+}
+
+// StatusCode is the status returned to the public client for the failure.
+func (k ProxyErrorKind) StatusCode() int {
+	if k == ProxyErrorTimeout {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// classifyProxyError works out why the transport failed to reach the target.
+func classifyProxyError(err error) ProxyErrorKind {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ProxyErrorCanceled
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ProxyErrorRefused
+	case errors.As(err, &dnsErr):
+		return ProxyErrorDNS
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownAuthorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return ProxyErrorTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ProxyErrorTimeout
+	}
+	return ProxyErrorOther
+}
+
+// ProxyErrorPage is the data passed to the error page template.
+type ProxyErrorPage struct {
+	Status      int
+	StatusText  string
+	Kind        ProxyErrorKind
+	Description string
+	Funnel      string
+}
+
+// LoadErrorPage parses the html/template at path, used in place of the built in
+// page when the local target can't be reached.  It receives a ProxyErrorPage.
+func LoadErrorPage(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error page: %w", err)
+	}
+	tmpl, err := template.New("error_page").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid error page %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// writeProxyError responds to the public client after the target couldn't be
+// reached.  Browsers get the funnel's error page, or the built in one, anything
+// else a short plain text message.
+func (s *HttpServer) writeProxyError(w http.ResponseWriter, r *http.Request, funnel Funnel, kind ProxyErrorKind) {
+	status := kind.StatusCode()
+	page := ProxyErrorPage{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		Kind:        kind,
+		Description: kind.Description(),
+		Funnel:      funnelName(funnel),
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, "%d %s: %s\n", status, page.StatusText, page.Description)
+		return
+	}
+
+	// render up front so a broken custom page still results in a response
+	var buf bytes.Buffer
+	var err error
+	if funnel.ErrorPage != nil {
+		err = funnel.ErrorPage.Execute(&buf, page)
+	} else {
+		err = s.embeddedTemplates.ExecuteTemplate(&buf, "proxy_error.html", page)
+	}
+	if err != nil {
+		s.logger.Printf("Error rendering error page for funnel %s: %v", funnel.ID(), err)
+		http.Error(w, fmt.Sprintf("%d %s", status, page.StatusText), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
@@ -0,0 +1,116 @@
+package funnel
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_classifyProxyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ProxyErrorKind
+	}{
+		{
+			name: "Connection refused",
+			err:  &url.Error{Op: "Get", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}},
+			want: ProxyErrorRefused,
+		},
+		{name: "Dial timeout", err: &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, want: ProxyErrorTimeout},
+		{name: "Deadline exceeded", err: fmt.Errorf("waiting: %w", context.DeadlineExceeded), want: ProxyErrorTimeout},
+		{name: "Client canceled", err: context.Canceled, want: ProxyErrorCanceled},
+		{name: "Unknown CA", err: &url.Error{Op: "Get", URL: "https://localhost", Err: x509.UnknownAuthorityError{}}, want: ProxyErrorTLS},
+		{name: "DNS failure", err: &net.DNSError{Err: "no such host", Name: "nope.invalid"}, want: ProxyErrorDNS},
+		{name: "Anything else", err: errors.New("unexpected EOF"), want: ProxyErrorOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyProxyError(tt.err); got != tt.want {
+				t.Errorf("classifyProxyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// closedTarget returns the URL of a local port nothing is listening on.
+func closedTarget(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return "http://" + addr
+}
+
+func TestHandleRequest_TargetDown(t *testing.T) {
+	customPage := filepath.Join(t.TempDir(), "down.html")
+	if err := os.WriteFile(customPage, []byte("<h1>{{ .Funnel }} is down ({{ .Status }})</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errorPage, err := LoadErrorPage(customPage)
+	if err != nil {
+		t.Fatalf("LoadErrorPage() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		accept    string
+		errorPage bool
+		wantType  string
+		wantBody  string
+	}{
+		{name: "API client gets plain text", accept: "application/json", wantType: "text/plain", wantBody: "502 Bad Gateway: connection refused"},
+		{name: "Browser gets built in page", accept: "text/html,*/*", wantType: "text/html", wantBody: "<h1>502 Bad Gateway</h1>"},
+		{name: "Browser gets custom page", accept: "text/html", errorPage: true, wantType: "text/html", wantBody: "<h1>test-funnel is down (502)</h1>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, f := newTestProxy(t, closedTarget(t), nil)
+			if tt.errorPage {
+				f.ErrorPage = errorPage
+				s.funnelRegistry.AddFunnel(f)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, HttpServerPath+"test-funnel/", nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			s.handleRequest(rec, req)
+
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("Expected status 502, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.wantType) {
+				t.Errorf("Expected content type %s, got %q", tt.wantType, got)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("Expected body to contain %q, got %q", tt.wantBody, rec.Body.String())
+			}
+
+			captured := f.Requests.Head.Request
+			if captured.ErrorKind != ProxyErrorRefused || captured.Error == "" || captured.StatusCode() != http.StatusBadGateway {
+				t.Errorf("Unexpected capture: kind %q, error %q, status %d", captured.ErrorKind, captured.Error, captured.StatusCode())
+			}
+		})
+	}
+}
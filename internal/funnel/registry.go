@@ -1,23 +1,149 @@
 package funnel
 
+import (
+	"sort"
+	"sync"
+
+	"github.com/jonson/tsgrok/internal/util"
+)
+
+// FunnelChange is what happened to a funnel in the registry.
+type FunnelChange string
+
+const (
+	FunnelAdded   FunnelChange = "added"
+	FunnelRemoved FunnelChange = "removed"
+	FunnelUpdated FunnelChange = "updated" // e.g. switched between inspected and pass-through
+)
+
+// FunnelRegistry holds every funnel by ID.  It is shared by the TUI, the http
+// server's handlers and the commands run in the background, so it is safe for
+// concurrent use.
 type FunnelRegistry struct {
-	Funnels map[string]Funnel
+	mu             sync.RWMutex
+	funnels        map[string]Funnel
+	budget         *CaptureBudget // memory budget shared by the captures of every funnel
+	subscribers    map[int]func(change FunnelChange, id string)
+	nextSubscriber int
 }
 
 func (f *FunnelRegistry) AddFunnel(funnel Funnel) {
-	f.Funnels[funnel.HTTPFunnel.id] = funnel
+	f.mu.Lock()
+	previous, ok := f.funnels[funnel.HTTPFunnel.id]
+	f.funnels[funnel.HTTPFunnel.id] = funnel
+	f.mu.Unlock()
+
+	// a funnel added again under the same ID replaces the old one and its captures
+	if ok && previous.Requests != nil && previous.Requests != funnel.Requests {
+		f.budget.detach(previous.Requests)
+	}
+	if funnel.Requests != nil {
+		f.budget.attach(funnel.Requests)
+	}
+	f.notify(FunnelAdded, funnel.HTTPFunnel.id)
 }
 
 func (f *FunnelRegistry) RemoveFunnel(id string) {
-	delete(f.Funnels, id)
+	f.mu.Lock()
+	funnel, ok := f.funnels[id]
+	delete(f.funnels, id)
+	f.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if funnel.Requests != nil {
+		f.budget.detach(funnel.Requests)
+	}
+	f.notify(FunnelRemoved, id)
+}
+
+// FunnelUpdated tells the registry a funnel's state changed in place, e.g. with
+// SetInspect, so the web inspector can show it.
+func (f *FunnelRegistry) FunnelUpdated(id string) {
+	f.mu.RLock()
+	_, ok := f.funnels[id]
+	f.mu.RUnlock()
+
+	if ok {
+		f.notify(FunnelUpdated, id)
+	}
 }
 
+// GetFunnel returns the funnel with the given ID, or ErrFunnelNotFound.
 func (f *FunnelRegistry) GetFunnel(id string) (Funnel, error) {
-	return f.Funnels[id], nil
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	funnel, ok := f.funnels[id]
+	if !ok {
+		return Funnel{}, ErrFunnelNotFound
+	}
+	return funnel, nil
+}
+
+// Funnels returns a snapshot of every funnel, sorted by ID.
+func (f *FunnelRegistry) Funnels() []Funnel {
+	f.mu.RLock()
+	funnels := make([]Funnel, 0, len(f.funnels))
+	for _, funnel := range f.funnels {
+		funnels = append(funnels, funnel)
+	}
+	f.mu.RUnlock()
+
+	sort.Slice(funnels, func(i, j int) bool {
+		return funnels[i].ID() < funnels[j].ID()
+	})
+	return funnels
+}
+
+// Len returns the number of funnels.
+func (f *FunnelRegistry) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.funnels)
+}
+
+// CaptureBudget returns the memory budget shared by every funnel's captures.
+func (f *FunnelRegistry) CaptureBudget() *CaptureBudget {
+	return f.budget
+}
+
+// Subscribe calls fn after every funnel that is added, removed or updated, until
+// the returned function is called.  fn is called on the goroutine making the
+// change, after the registry is unlocked, so it may read the registry but must
+// not block.
+func (f *FunnelRegistry) Subscribe(fn func(change FunnelChange, id string)) (unsubscribe func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := f.nextSubscriber
+	f.nextSubscriber++
+	f.subscribers[key] = fn
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subscribers, key)
+	}
+}
+
+func (f *FunnelRegistry) notify(change FunnelChange, id string) {
+	f.mu.RLock()
+	subscribers := make([]func(FunnelChange, string), 0, len(f.subscribers))
+	for _, fn := range f.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	f.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(change, id)
+	}
 }
 
 func NewFunnelRegistry() *FunnelRegistry {
 	return &FunnelRegistry{
-		Funnels: make(map[string]Funnel),
+		funnels:     make(map[string]Funnel),
+		budget:      NewCaptureBudget(util.DefaultMaxCaptureBytes),
+		subscribers: make(map[int]func(change FunnelChange, id string)),
 	}
 }
@@ -0,0 +1,123 @@
+package funnel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type registryChange struct {
+	Change FunnelChange
+	ID     string
+}
+
+func TestFunnelRegistry(t *testing.T) {
+	registry := NewFunnelRegistry()
+
+	var changes []registryChange
+	unsubscribe := registry.Subscribe(func(change FunnelChange, id string) {
+		// subscribers are called with the registry unlocked
+		_, err := registry.GetFunnel(id)
+		if (change == FunnelRemoved) != errors.Is(err, ErrFunnelNotFound) {
+			t.Errorf("%s %s: unexpected GetFunnel error %v", change, id, err)
+		}
+		changes = append(changes, registryChange{change, id})
+	})
+
+	if _, err := registry.GetFunnel("b"); !errors.Is(err, ErrFunnelNotFound) {
+		t.Errorf("Expected ErrFunnelNotFound, got %v", err)
+	}
+
+	registry.AddFunnel(Funnel{HTTPFunnel: &HTTPFunnel{id: "b"}, Requests: NewRequestList(0)})
+	registry.AddFunnel(Funnel{HTTPFunnel: &HTTPFunnel{id: "a"}})
+	registry.FunnelUpdated("a")
+	registry.FunnelUpdated("nope")
+	registry.RemoveFunnel("nope")
+
+	ids := []string{}
+	for _, f := range registry.Funnels() {
+		ids = append(ids, f.ID())
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, ids); diff != "" || registry.Len() != 2 {
+		t.Errorf("Funnels mismatch (-want +got):\n%s", diff)
+	}
+	if f, err := registry.GetFunnel("b"); err != nil || f.ID() != "b" {
+		t.Errorf("Expected funnel b, got %v, %v", f.ID(), err)
+	}
+
+	registry.RemoveFunnel("b")
+	unsubscribe()
+	registry.RemoveFunnel("a")
+
+	want := []registryChange{{FunnelAdded, "b"}, {FunnelAdded, "a"}, {FunnelUpdated, "a"}, {FunnelRemoved, "b"}}
+	if diff := cmp.Diff(want, changes); diff != "" {
+		t.Errorf("Changes mismatch (-want +got):\n%s", diff)
+	}
+	if registry.Len() != 0 {
+		t.Errorf("Expected no funnels left, got %d", registry.Len())
+	}
+}
+
+func TestFunnelRegistry_ReplaceDetachesCaptures(t *testing.T) {
+	registry := NewFunnelRegistry()
+	budget := registry.CaptureBudget()
+
+	old := NewRequestList(0)
+	old.Add(newSizedRequest("old", time.Now(), 1000))
+	registry.AddFunnel(Funnel{HTTPFunnel: &HTTPFunnel{id: "a"}, Requests: old})
+
+	replacement := NewRequestList(0)
+	replacement.Add(newSizedRequest("new", time.Now(), 500))
+	registry.AddFunnel(Funnel{HTTPFunnel: &HTTPFunnel{id: "a"}, Requests: replacement})
+
+	if budget.Used() != replacement.Bytes() {
+		t.Errorf("Expected %d bytes used, got %d", replacement.Bytes(), budget.Used())
+	}
+
+	// the old list is no longer accounted for, growing it doesn't touch the budget
+	old.Add(newSizedRequest("later", time.Now(), 1000))
+	if budget.Used() != replacement.Bytes() {
+		t.Errorf("Expected %d bytes used after the old list grew, got %d", replacement.Bytes(), budget.Used())
+	}
+
+	registry.RemoveFunnel("a")
+	if budget.Used() != 0 {
+		t.Errorf("Expected no bytes used, got %d", budget.Used())
+	}
+}
+
+func TestFunnelRegistry_Concurrent(t *testing.T) {
+	registry := NewFunnelRegistry()
+	var mu sync.Mutex
+	seen := 0
+	registry.Subscribe(func(change FunnelChange, id string) {
+		registry.Funnels()
+		mu.Lock()
+		seen++
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				id := fmt.Sprintf("%d-%d", i, j)
+				registry.AddFunnel(Funnel{HTTPFunnel: &HTTPFunnel{id: id}, Requests: NewRequestList(0)})
+				_, _ = registry.GetFunnel(id)
+				registry.FunnelUpdated(id)
+				registry.RemoveFunnel(id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if registry.Len() != 0 || seen != 8*50*3 {
+		t.Errorf("Expected every funnel to come and go, got %d left and %d changes", registry.Len(), seen)
+	}
+}
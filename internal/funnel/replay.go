@@ -0,0 +1,91 @@
+package funnel
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Replay sends a captured request to the funnel's local target again, as it was
+// first received, and returns the new capture, which refers back to the original.
+// The response goes nowhere but the capture, the public client isn't involved.
+func (s *HttpServer) Replay(ctx context.Context, funnelID string, requestID string) (*CaptureRequestResponse, error) {
+	funnel, err := s.liveFunnel(funnelID)
+	if err != nil {
+		return nil, err
+	}
+
+	original := findRequestInList(funnel.Requests, requestID)
+	if original == nil {
+		return nil, ErrRequestNotFound
+	}
+	if original.WebSocket != nil {
+		return nil, ErrReplayWebSocket
+	}
+	if original.ServerSentEvents != nil {
+		// the replay wouldn't finish until the target closed the stream
+		return nil, ErrReplayEventStream
+	}
+	if original.Request.BodyTruncated {
+		return nil, ErrReplayBodyTruncated
+	}
+
+	composed, err := NewComposedRequest(funnel, *original)
+	if err != nil {
+		return nil, err
+	}
+	return s.send(ctx, funnel, composed, CaptureRequestResponse{ReplayOf: original.ID})
+}
+
+// liveFunnel returns the funnel with the given ID, provided requests can be sent
+// through it.
+func (s *HttpServer) liveFunnel(funnelID string) (Funnel, error) {
+	funnel, err := s.GetFunnelById(funnelID)
+	if err != nil {
+		return Funnel{}, err
+	}
+	if funnel.Archived {
+		return Funnel{}, ErrFunnelArchived
+	}
+	return funnel, nil
+}
+
+// send proxies req to the funnel's local target, capturing it in a capture that
+// starts out as requestResponse, and returns the completed capture.
+func (s *HttpServer) send(ctx context.Context, funnel Funnel, req ComposedRequest, requestResponse CaptureRequestResponse) (*CaptureRequestResponse, error) {
+	httpReq, rest, err := req.httpRequest(ctx, funnel)
+	if err != nil {
+		return nil, err
+	}
+
+	requestResponse.ID = uuid.New().String()
+	func() {
+		// the proxy aborts the handler if the target fails mid-response, the capture
+		// has been completed by then
+		defer func() {
+			if v := recover(); v != nil && v != http.ErrAbortHandler {
+				panic(v)
+			}
+		}()
+		s.proxyToTarget(&discardResponseWriter{header: http.Header{}}, httpReq, funnel, rest, requestResponse)
+	}()
+
+	sent := findRequestInList(funnel.Requests, requestResponse.ID)
+	if sent == nil {
+		// evicted straight away, the capture budget is smaller than the capture
+		return nil, ErrReplayCaptureMissing
+	}
+	return sent, nil
+}
+
+// discardResponseWriter is where the responses to replayed and composed requests
+// go, they are only of interest in the capture.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponseWriter) WriteHeader(statusCode int)  {}
+func (w *discardResponseWriter) Flush()                      {}
@@ -0,0 +1,177 @@
+package funnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// receivedRequest is what the backend saw of a request.
+type receivedRequest struct {
+	Method        string
+	URI           string
+	Body          string
+	Signature     string
+	ForwardedFor  string
+	ContentLength int64
+}
+
+func TestReplay(t *testing.T) {
+	var mu sync.Mutex
+	var received []receivedRequest
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, receivedRequest{
+			Method:        r.Method,
+			URI:           r.RequestURI,
+			Body:          string(body),
+			Signature:     r.Header.Get("X-Signature"),
+			ForwardedFor:  r.Header.Get("X-Forwarded-For"),
+			ContentLength: r.ContentLength,
+		})
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL+"/api", nil)
+
+	req := httptest.NewRequest(http.MethodPost, HttpServerPath+"test-funnel/hooks/github?delivery=1", strings.NewReader(`{"action":"opened"}`))
+	req.Header.Set("X-Signature", "sha256=abc")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7") // as set by the funnel
+	s.handleRequest(httptest.NewRecorder(), req)
+	if f.Requests.Head == nil {
+		t.Fatal("Expected the request to be captured")
+	}
+	original := f.Requests.Head.Request
+
+	replayed, err := s.Replay(context.Background(), "test-funnel", original.ID)
+	if err != nil {
+		t.Fatalf("Replay() unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 {
+		t.Fatalf("Expected the backend to receive 2 requests, got %d", len(received))
+	}
+	// the replay doesn't have a client address, so nothing is appended to X-Forwarded-For
+	want := receivedRequest{
+		Method:        http.MethodPost,
+		URI:           "/api/hooks/github?delivery=1",
+		Body:          `{"action":"opened"}`,
+		Signature:     "sha256=abc",
+		ForwardedFor:  "203.0.113.7",
+		ContentLength: int64(len(`{"action":"opened"}`)),
+	}
+	if diff := cmp.Diff(want, received[1]); diff != "" {
+		t.Errorf("Replayed request mismatch (-want +got):\n%s", diff)
+	}
+
+	if replayed.ID == original.ID || replayed.ReplayOf != original.ID {
+		t.Errorf("Expected a new capture replaying %s, got ID %s replaying %q", original.ID, replayed.ID, replayed.ReplayOf)
+	}
+	if replayed.StatusCode() != http.StatusAccepted || replayed.Request.URL != original.Request.URL {
+		t.Errorf("Unexpected replay capture: status %d, URL %q", replayed.StatusCode(), replayed.Request.URL)
+	}
+	if diff := cmp.Diff([]string{replayed.ID, original.ID}, listIDs(f.Requests)); diff != "" {
+		t.Errorf("Request list mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReplay_Rejected(t *testing.T) {
+	s, f := newTestProxy(t, closedTarget(t), nil)
+	f.Requests.Add(CaptureRequestResponse{
+		ID:        "truncated",
+		Timestamp: time.Now(),
+		Request:   CaptureRequest{Method: http.MethodPost, URL: "http://localhost/upload", Body: []byte("part"), BodySize: 100, BodyTruncated: true},
+	})
+	f.Requests.Add(CaptureRequestResponse{
+		ID:        "websocket",
+		Timestamp: time.Now(),
+		Request:   CaptureRequest{Method: http.MethodGet, URL: "http://localhost/ws"},
+		WebSocket: newWebSocketLog(10),
+	})
+	f.Requests.Add(CaptureRequestResponse{
+		ID:               "events",
+		Timestamp:        time.Now(),
+		Request:          CaptureRequest{Method: http.MethodGet, URL: "http://localhost/events"},
+		ServerSentEvents: newSSELog(10),
+	})
+	archived := NewArchivedFunnel("archive-test", "http://localhost", "https://test.example.ts.net", NewRequestList(0))
+	archived.Requests.Add(CaptureRequestResponse{ID: "stored", Request: CaptureRequest{Method: http.MethodGet, URL: "http://localhost/"}})
+	s.funnelRegistry.AddFunnel(archived)
+
+	tests := []struct {
+		name      string
+		funnelID  string
+		requestID string
+		want      error
+	}{
+		{name: "unknown funnel", funnelID: "missing", requestID: "truncated", want: ErrFunnelNotFound},
+		{name: "unknown request", funnelID: "test-funnel", requestID: "missing", want: ErrRequestNotFound},
+		{name: "truncated body", funnelID: "test-funnel", requestID: "truncated", want: ErrReplayBodyTruncated},
+		{name: "websocket", funnelID: "test-funnel", requestID: "websocket", want: ErrReplayWebSocket},
+		{name: "event stream", funnelID: "test-funnel", requestID: "events", want: ErrReplayEventStream},
+		{name: "archived funnel", funnelID: "archive-test", requestID: "stored", want: ErrFunnelArchived},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Replay(context.Background(), tt.funnelID, tt.requestID)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Replay() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if f.Requests.Length != 3 {
+		t.Errorf("Expected rejected replays not to be captured, the list holds %d requests", f.Requests.Length)
+	}
+}
+
+func TestHandleFunnelRequestReplay(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL, nil)
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HttpServerPath+"test-funnel/status", nil))
+	original := f.Requests.Head.Request
+
+	rec := httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel/request/"+original.ID+"/replay", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be rejected with 405, got %d", rec.Code)
+	}
+
+	req := newInspectorRequest(http.MethodPost, "/inspect/test-funnel/request/"+original.ID+"/replay", nil)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rec = httptest.NewRecorder()
+	s.handleFunnelInspect(rec, req)
+	if rec.Code != http.StatusForbidden || f.Requests.Length != 1 {
+		t.Errorf("Expected a post from another site to be refused, got %d with %d requests captured", rec.Code, f.Requests.Length)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleFunnelInspect(rec, newInspectorRequest(http.MethodPost, "/inspect/test-funnel/request/"+original.ID+"/replay", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if f.Requests.Length != 2 || f.Requests.Head.Request.ReplayOf != original.ID {
+		t.Fatalf("Expected the replay to be captured, the list holds %d requests", f.Requests.Length)
+	}
+	// the response is the new capture's details, linking back to the original
+	body := rec.Body.String()
+	if !strings.Contains(body, f.Requests.Head.Request.ID) || !strings.Contains(body, "/inspect/test-funnel/request/"+original.ID+`"`) {
+		t.Errorf("Expected the replay's details linking to the original, got:\n%s", body)
+	}
+}
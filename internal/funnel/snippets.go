@@ -0,0 +1,221 @@
+package funnel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SnippetFormat is a way of writing a captured request out, so it can be sent
+// again from outside tsgrok.
+type SnippetFormat string
+
+const (
+	SnippetCurl   SnippetFormat = "curl"
+	SnippetHTTPie SnippetFormat = "httpie"
+	SnippetRaw    SnippetFormat = "raw"
+	SnippetGo     SnippetFormat = "go"
+)
+
+// SnippetFormats lists every format, in the order they are offered.
+var SnippetFormats = []SnippetFormat{SnippetCurl, SnippetHTTPie, SnippetRaw, SnippetGo}
+
+var ErrUnknownSnippetFormat = errors.New("unknown snippet format")
+
+// Label is the format's name as shown to the user.
+func (f SnippetFormat) Label() string {
+	switch f {
+	case SnippetCurl:
+		return "curl"
+	case SnippetHTTPie:
+		return "HTTPie"
+	case SnippetRaw:
+		return "raw HTTP"
+	case SnippetGo:
+		return "Go"
+	}
+	return string(f)
+}
+
+// Snippet writes the captured request in the given format.  The request is
+// addressed to the local target, as the proxy sent it.
+func Snippet(format SnippetFormat, capture CaptureRequestResponse) (string, error) {
+	switch format {
+	case SnippetCurl:
+		return curlSnippet(capture.Request), nil
+	case SnippetHTTPie:
+		return httpieSnippet(capture.Request), nil
+	case SnippetRaw:
+		return rawSnippet(capture.Request)
+	case SnippetGo:
+		return goSnippet(capture.Request), nil
+	}
+	return "", ErrUnknownSnippetFormat
+}
+
+// snippetHeaders returns the request's headers as sent, less Content-Length,
+// which the tools work out from the body themselves.
+func snippetHeaders(req CaptureRequest) []HeaderEntry {
+	var entries []HeaderEntry
+	for _, entry := range headerEntries(req.Headers) {
+		if http.CanonicalHeaderKey(entry.Name) != "Content-Length" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// snippetNote returns a comment about the body when it can't be reproduced exactly.
+func snippetNote(req CaptureRequest) string {
+	if req.BodyTruncated {
+		return fmt.Sprintf("only the first %d of %d body bytes were captured", len(req.Body), req.BodySize)
+	}
+	return ""
+}
+
+// textBody reports whether the body can be written into a snippet as text.
+func textBody(body []byte) bool {
+	return utf8.Valid(body) && !strings.ContainsRune(string(body), 0)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func curlSnippet(req CaptureRequest) string {
+	var lines []string
+	var b strings.Builder
+	if note := snippetNote(req); note != "" {
+		b.WriteString("# " + note + "\n")
+	}
+
+	cmd := "curl"
+	switch req.Method {
+	case http.MethodGet, "":
+	case http.MethodHead:
+		cmd += " --head"
+	default:
+		cmd += " -X " + req.Method
+	}
+	lines = append(lines, cmd+" "+shellQuote(req.URL))
+	for _, entry := range snippetHeaders(req) {
+		if entry.Value == "" {
+			// "Name:" would remove the header, "Name;" sends it empty
+			lines = append(lines, "-H "+shellQuote(entry.Name+";"))
+		} else {
+			lines = append(lines, "-H "+shellQuote(entry.Name+": "+entry.Value))
+		}
+	}
+	if len(req.Body) > 0 {
+		if textBody(req.Body) {
+			lines = append(lines, "--data-binary "+shellQuote(string(req.Body)))
+		} else {
+			b.WriteString(fmt.Sprintf("# the %d byte body is binary, save it to body.bin\n", len(req.Body)))
+			lines = append(lines, "--data-binary @body.bin")
+		}
+	}
+	b.WriteString(strings.Join(lines, " \\\n  "))
+	return b.String()
+}
+
+func httpieSnippet(req CaptureRequest) string {
+	var b strings.Builder
+	if note := snippetNote(req); note != "" {
+		b.WriteString("# " + note + "\n")
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	lines := []string{"http"}
+	if len(req.Body) > 0 {
+		if textBody(req.Body) {
+			lines[0] += " --raw " + shellQuote(string(req.Body))
+		} else {
+			b.WriteString(fmt.Sprintf("# the %d byte body is binary, save it to body.bin\n", len(req.Body)))
+			lines[0] = "http < body.bin"
+		}
+	}
+	lines[0] += " " + method + " " + shellQuote(req.URL)
+	for _, entry := range snippetHeaders(req) {
+		if entry.Value == "" {
+			// "Name:" would remove the header, "Name;" sends it empty
+			lines = append(lines, shellQuote(entry.Name+";"))
+		} else {
+			lines = append(lines, shellQuote(entry.Name+":"+entry.Value))
+		}
+	}
+	b.WriteString(strings.Join(lines, " \\\n  "))
+	return b.String()
+}
+
+// rawSnippet writes the request as an HTTP/1.1 message, as it went over the wire
+// to the local target.
+func rawSnippet(req CaptureRequest) (string, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return "", err
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", method, u.RequestURI())
+	fmt.Fprintf(&b, "Host: %s\r\n", u.Host)
+	for _, entry := range headerEntries(req.Headers) {
+		fmt.Fprintf(&b, "%s: %s\r\n", entry.Name, entry.Value)
+	}
+	if len(req.Body) > 0 && req.Headers.Get("Content-Length") == "" && req.Headers.Get("Transfer-Encoding") == "" {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(req.Body))
+	}
+	b.WriteString("\r\n")
+	b.Write(req.Body)
+	return b.String(), nil
+}
+
+func goSnippet(req CaptureRequest) string {
+	var b strings.Builder
+	if note := snippetNote(req); note != "" {
+		b.WriteString("// " + note + "\n")
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	body := "nil"
+	if len(req.Body) > 0 {
+		fmt.Fprintf(&b, "body := strings.NewReader(%s)\n", goStringLiteral(string(req.Body)))
+		body = "body"
+	}
+	fmt.Fprintf(&b, "req, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(method), strconv.Quote(req.URL), body)
+	b.WriteString("if err != nil {\n\treturn err\n}\n")
+
+	seen := make(map[string]bool)
+	for _, entry := range snippetHeaders(req) {
+		call := "Set"
+		if seen[entry.Name] {
+			call = "Add"
+		}
+		seen[entry.Name] = true
+		fmt.Fprintf(&b, "req.Header.%s(%s, %s)\n", call, strconv.Quote(entry.Name), strconv.Quote(entry.Value))
+	}
+	return b.String()
+}
+
+// goStringLiteral prefers a raw string literal, which keeps bodies like JSON
+// readable, falling back to an interpreted one.
+func goStringLiteral(s string) string {
+	if textBody([]byte(s)) && !strings.ContainsAny(s, "`\r") {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,162 @@
+package funnel
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSnippet(t *testing.T) {
+	post := CaptureRequestResponse{Request: CaptureRequest{
+		Method: http.MethodPost,
+		URL:    "http://localhost:8080/hooks?source=github",
+		Headers: http.Header{
+			"Content-Type":   {"application/json"},
+			"Content-Length": {"19"},
+			"Accept":         {"text/html", "application/json"},
+		},
+		Body:     []byte(`{"msg":"it's here"}`),
+		BodySize: 19,
+	}}
+	get := CaptureRequestResponse{Request: CaptureRequest{
+		Method:  http.MethodGet,
+		URL:     "http://localhost:8080/",
+		Headers: http.Header{"X-Empty": {""}},
+	}}
+	binary := CaptureRequestResponse{Request: CaptureRequest{
+		Method:        http.MethodPut,
+		URL:           "http://localhost:8080/upload",
+		Body:          []byte{0xff, 0x00, 0x01},
+		BodySize:      10,
+		BodyTruncated: true,
+	}}
+
+	tests := []struct {
+		name    string
+		format  SnippetFormat
+		capture CaptureRequestResponse
+		want    string
+	}{
+		{
+			name:    "curl",
+			format:  SnippetCurl,
+			capture: post,
+			want: `curl -X POST 'http://localhost:8080/hooks?source=github' \
+  -H 'Accept: text/html' \
+  -H 'Accept: application/json' \
+  -H 'Content-Type: application/json' \
+  --data-binary '{"msg":"it'\''s here"}'`,
+		},
+		{
+			name:    "curl get",
+			format:  SnippetCurl,
+			capture: get,
+			want: `curl 'http://localhost:8080/' \
+  -H 'X-Empty;'`,
+		},
+		{
+			name:    "curl binary",
+			format:  SnippetCurl,
+			capture: binary,
+			want: `# only the first 3 of 10 body bytes were captured
+# the 3 byte body is binary, save it to body.bin
+curl -X PUT 'http://localhost:8080/upload' \
+  --data-binary @body.bin`,
+		},
+		{
+			name:    "httpie",
+			format:  SnippetHTTPie,
+			capture: post,
+			want: `http --raw '{"msg":"it'\''s here"}' POST 'http://localhost:8080/hooks?source=github' \
+  'Accept:text/html' \
+  'Accept:application/json' \
+  'Content-Type:application/json'`,
+		},
+		{
+			name:    "httpie empty header",
+			format:  SnippetHTTPie,
+			capture: get,
+			want: `http GET 'http://localhost:8080/' \
+  'X-Empty;'`,
+		},
+		{
+			name:    "raw",
+			format:  SnippetRaw,
+			capture: post,
+			want: "POST /hooks?source=github HTTP/1.1\r\n" +
+				"Host: localhost:8080\r\n" +
+				"Accept: text/html\r\n" +
+				"Accept: application/json\r\n" +
+				"Content-Length: 19\r\n" +
+				"Content-Type: application/json\r\n" +
+				"\r\n" +
+				`{"msg":"it's here"}`,
+		},
+		{
+			name:    "raw without content length",
+			format:  SnippetRaw,
+			capture: binary,
+			want:    "PUT /upload HTTP/1.1\r\nHost: localhost:8080\r\nContent-Length: 3\r\n\r\n\xff\x00\x01",
+		},
+		{
+			name:    "go",
+			format:  SnippetGo,
+			capture: post,
+			want: "body := strings.NewReader(`{\"msg\":\"it's here\"}`)\n" +
+				"req, err := http.NewRequest(\"POST\", \"http://localhost:8080/hooks?source=github\", body)\n" +
+				"if err != nil {\n\treturn err\n}\n" +
+				"req.Header.Set(\"Accept\", \"text/html\")\n" +
+				"req.Header.Add(\"Accept\", \"application/json\")\n" +
+				"req.Header.Set(\"Content-Type\", \"application/json\")\n",
+		},
+		{
+			name:    "go binary",
+			format:  SnippetGo,
+			capture: binary,
+			want: "// only the first 3 of 10 body bytes were captured\n" +
+				"body := strings.NewReader(\"\\xff\\x00\\x01\")\n" +
+				"req, err := http.NewRequest(\"PUT\", \"http://localhost:8080/upload\", body)\n" +
+				"if err != nil {\n\treturn err\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Snippet(tt.format, tt.capture)
+			if err != nil {
+				t.Fatalf("Snippet() unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Snippet() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := Snippet("powershell", post); !errors.Is(err, ErrUnknownSnippetFormat) {
+		t.Errorf("Snippet() with an unknown format error = %v, want %v", err, ErrUnknownSnippetFormat)
+	}
+}
+
+func TestHandleFunnelRequestSnippet(t *testing.T) {
+	s, f := newTestProxy(t, "http://localhost:8080", nil)
+	f.Requests.Add(CaptureRequestResponse{
+		ID:        "req",
+		Timestamp: time.Now(),
+		Request:   CaptureRequest{Method: http.MethodDelete, URL: "http://localhost:8080/items/1"},
+	})
+
+	rec := httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel/request/req/snippet/curl", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "curl -X DELETE 'http://localhost:8080/items/1'" {
+		t.Errorf("Expected the curl command, got %d: %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel/request/req/snippet/powershell", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown format to return 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,202 @@
+package funnel
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEEvent is a single server-sent event, as dispatched to an EventSource.
+type SSEEvent struct {
+	ID        string
+	Event     string // event type, "message" when the stream doesn't set one
+	Data      string // first util.SSEPreviewBytes bytes of the data
+	Size      int64  // size of the data in bytes
+	Timestamp time.Time
+}
+
+// Truncated reports whether only part of the data was captured.
+func (e SSEEvent) Truncated() bool {
+	return int64(len(e.Data)) < e.Size
+}
+
+// SSELog is the event log of a text/event-stream response.  Like WebSocketLog it
+// is shared by every copy of the CaptureRequestResponse and grows while the
+// stream is open.
+type SSELog struct {
+	mu        sync.Mutex
+	events    []SSEEvent
+	count     int // total events seen, including those no longer kept
+	maxEvents int // most recent events to keep, <= 0 keeps all
+	bytes     int64
+}
+
+func newSSELog(maxEvents int) *SSELog {
+	return &SSELog{maxEvents: maxEvents}
+}
+
+func (l *SSELog) add(event SSEEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count++
+	if l.maxEvents > 0 && len(l.events) == l.maxEvents {
+		// drop the oldest event
+		l.bytes -= int64(len(l.events[0].Data))
+		copy(l.events, l.events[1:])
+		l.events = l.events[:len(l.events)-1]
+	}
+	l.events = append(l.events, event)
+	l.bytes += int64(len(event.Data))
+}
+
+// size returns the bytes of event data kept by the log.
+func (l *SSELog) size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bytes
+}
+
+// Events returns a copy of the kept events, oldest first.
+func (l *SSELog) Events() []SSEEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]SSEEvent(nil), l.events...)
+}
+
+// Count returns the number of events seen on the stream.
+func (l *SSELog) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// isEventStream reports whether the headers describe a text/event-stream body.
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// isStreamingResponse reports whether a response is streamed to the client as it
+// is produced, rather than having a known length.  These are the responses
+// httputil.ReverseProxy flushes immediately.
+func isStreamingResponse(resp *http.Response) bool {
+	return isEventStream(resp.Header) || resp.ContentLength == -1
+}
+
+// sseParser decodes events from a text/event-stream body as it is copied to the
+// client.  Lines may arrive split across any number of writes.
+type sseParser struct {
+	previewLimit int
+	onEvent      func(SSEEvent)
+
+	line    []byte // the current line, up to lineLimit bytes of it
+	lineLen int    // full length of the current line
+
+	id      string
+	event   string
+	data    []byte
+	size    int64
+	hasData bool
+}
+
+// lineLimit bounds how much of a single line is buffered, enough for the field
+// name and the part of the value that is kept.
+func (p *sseParser) lineLimit() int {
+	return p.previewLimit + 64
+}
+
+// Write never fails, so the parser can't interfere with the stream.
+func (p *sseParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		chunk := b
+		if i >= 0 {
+			chunk = b[:i]
+		}
+		if keep := p.lineLimit() - len(p.line); keep > 0 {
+			p.line = append(p.line, chunk[:min(keep, len(chunk))]...)
+		}
+		p.lineLen += len(chunk)
+
+		if i < 0 {
+			break
+		}
+		p.processLine()
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+func (p *sseParser) processLine() {
+	line := bytes.TrimSuffix(p.line, []byte("\r"))
+	lineLen := p.lineLen
+	if len(line) < len(p.line) {
+		lineLen--
+	}
+	p.line = p.line[:0]
+	p.lineLen = 0
+
+	if len(line) == 0 {
+		p.dispatch()
+		return
+	}
+	if line[0] == ':' {
+		return // comment, often used as a keep-alive
+	}
+
+	field, value, found := strings.Cut(string(line), ":")
+	valueLen := 0
+	if found {
+		valueLen = lineLen - len(field) - 1
+		if strings.HasPrefix(value, " ") {
+			value = value[1:]
+			valueLen--
+		}
+	}
+
+	switch field {
+	case "data":
+		if p.hasData {
+			p.appendData([]byte("\n"), 1)
+		}
+		p.appendData([]byte(value), valueLen)
+		p.hasData = true
+	case "event":
+		p.event = value
+	case "id":
+		p.id = value
+	}
+}
+
+func (p *sseParser) appendData(value []byte, size int) {
+	if keep := p.previewLimit - len(p.data); keep > 0 {
+		p.data = append(p.data, value[:min(keep, len(value))]...)
+	}
+	p.size += int64(size)
+}
+
+func (p *sseParser) dispatch() {
+	if p.hasData && p.onEvent != nil {
+		event := p.event
+		if event == "" {
+			event = "message"
+		}
+		p.onEvent(SSEEvent{
+			ID:        p.id,
+			Event:     event,
+			Data:      strings.ToValidUTF8(string(p.data), "?"),
+			Size:      p.size,
+			Timestamp: time.Now(),
+		})
+	}
+	// the last event id carries over to later events, everything else is reset
+	p.event = ""
+	p.data = p.data[:0]
+	p.size = 0
+	p.hasData = false
+}
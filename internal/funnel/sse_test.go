@@ -0,0 +1,171 @@
+package funnel
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSSEParser(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"data: first\n\n" +
+		"event: update\r\nid: 7\r\ndata: line one\r\ndata: line two\r\n\r\n" +
+		"data:no space\n" +
+		"retry: 1000\n\n" +
+		"event: ignored without data\n\n" +
+		"data: " + strings.Repeat("x", 40) + "\n\n"
+
+	want := []SSEEvent{
+		{Event: "message", Data: "first", Size: 5},
+		{ID: "7", Event: "update", Data: "line one\nline two", Size: 17},
+		{ID: "7", Event: "message", Data: "no space", Size: 8},
+		{ID: "7", Event: "message", Data: strings.Repeat("x", 32), Size: 40},
+	}
+
+	// feed the stream in every chunk size, events must not depend on how writes are split
+	for _, chunkSize := range []int{1, 2, 5, 13, len(stream)} {
+		var events []SSEEvent
+		p := &sseParser{previewLimit: 32, onEvent: func(e SSEEvent) { events = append(events, e) }}
+
+		for rest := stream; len(rest) > 0; {
+			n := min(chunkSize, len(rest))
+			if written, err := p.Write([]byte(rest[:n])); written != n || err != nil {
+				t.Fatalf("Write() = %d, %v; want %d, nil", written, err, n)
+			}
+			rest = rest[n:]
+		}
+
+		if diff := cmp.Diff(want, events, cmpopts.IgnoreFields(SSEEvent{}, "Timestamp")); diff != "" {
+			t.Errorf("chunk size %d: events mismatch (-want +got):\n%s", chunkSize, diff)
+		}
+	}
+}
+
+func TestHandleRequest_EventStream(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: tick\ndata: one\n\n"))
+		w.(http.Flusher).Flush()
+
+		<-release
+		_, _ = w.Write([]byte("event: tick\ndata: two\n\n"))
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL, nil)
+	proxy := httptest.NewServer(http.HandlerFunc(s.handleRequest))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + HttpServerPath + "test-funnel/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the first event must reach the client while the backend is still holding the stream open
+	reader := bufio.NewReader(resp.Body)
+	done := make(chan string)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		line2, _ := reader.ReadString('\n')
+		done <- line + line2
+	}()
+	select {
+	case got := <-done:
+		if got != "event: tick\ndata: one\n" {
+			t.Errorf("Client received %q before the stream ended", got)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatalf("First event was not flushed to the client")
+	}
+
+	captured := f.Requests.Find(f.Requests.Head.Request.ID)
+	if captured == nil || !captured.InProgress || captured.ServerSentEvents == nil {
+		t.Fatalf("Expected an in progress capture with an event log, got %+v", captured)
+	}
+	waitFor(t, func() bool { return captured.ServerSentEvents.Count() == 1 })
+
+	close(release)
+	waitFor(t, func() bool {
+		c := f.Requests.Find(captured.ID)
+		return c != nil && !c.InProgress
+	})
+
+	final := f.Requests.Find(captured.ID)
+	if got := string(final.Response.Body); got != "event: tick\ndata: one\n\nevent: tick\ndata: two\n\n" {
+		t.Errorf("Captured body = %q", got)
+	}
+	if events := final.ServerSentEvents.Events(); len(events) != 2 || events[1].Data != "two" {
+		t.Errorf("Expected 2 captured events, got %+v", events)
+	}
+	if f.Requests.Length != 1 {
+		t.Errorf("Expected the stream to be captured once, got %d captures", f.Requests.Length)
+	}
+}
+
+func TestHandleRequest_EventStreamOutlivesMaxRequests(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: one\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL, nil)
+	f.Requests.SetMaxLength(2)
+
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HttpServerPath+"test-funnel/events", nil))
+	}()
+	waitFor(t, func() bool { return f.Requests.Len() == 1 })
+	streamID := f.Requests.Head.Request.ID
+
+	// the stream is the oldest capture, so newer ones push it out of the list
+	for range 2 {
+		s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HttpServerPath+"test-funnel/ping", nil))
+	}
+	if f.Requests.Find(streamID) != nil {
+		t.Fatal("Expected the open stream to be pushed out of the list")
+	}
+
+	close(release)
+	<-streamed
+	final := f.Requests.Find(streamID)
+	if final == nil || final.InProgress || final.ServerSentEvents.Count() != 1 {
+		t.Fatalf("Expected the finished stream to be added back, got %+v", final)
+	}
+	if f.Requests.Head.Request.ID != streamID || f.Requests.Len() != 2 {
+		t.Errorf("Expected the stream to be the newest of 2 captures, got %v", listIDs(f.Requests))
+	}
+	bus := s.events.(*recordingBus)
+	if last := bus.msgs[len(bus.msgs)-1]; last != (ProxyRequestMsg{FunnelId: "test-funnel", RequestId: streamID}) {
+		t.Errorf("Expected the stream to be announced as a new capture, got %#v", last)
+	}
+}
+
+// waitFor polls cond until it is true, failing the test if it takes too long.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
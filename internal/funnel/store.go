@@ -0,0 +1,551 @@
+package funnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storeFileExt is the extension of the files in a CaptureStore, one per funnel name.
+const storeFileExt = ".jsonl"
+
+// storeMaxLineBytes bounds a single stored capture, well above what the body and
+// frame limits allow.
+const storeMaxLineBytes = 256 << 20
+
+// storeQueueLength is how many captures may wait to be written before Save starts
+// refusing them.
+const storeQueueLength = 256
+
+// storePruneInterval is how often captures past the store's maxAge are pruned while
+// it is open.
+const storePruneInterval = 10 * time.Minute
+
+// CaptureStore appends finished captures to disk as they arrive, so they can be
+// browsed after tsgrok restarts.  Captures are kept as JSON lines, one file per
+// funnel name, so a funnel recreated with the same name continues its history.
+//
+// Captures are written, and the store pruned, by a goroutine of the store's own,
+// so the proxy never waits on the disk.
+type CaptureStore struct {
+	dir      string
+	maxAge   time.Duration // captures older than this are pruned, <= 0 keeps them
+	maxBytes int64         // size of the store before the oldest captures are pruned, <= 0 is unlimited
+
+	queueMu sync.RWMutex
+	queue   chan storeWrite // to the writer, closed by Close
+	closed  bool
+	done    chan struct{} // closed once the writer has returned
+
+	mu      sync.Mutex
+	files   map[string]*os.File // open for appending, by file name
+	size    int64               // bytes across all files
+	onError func(funnelID string, err error)
+}
+
+// storeWrite is a capture queued to be written, or a request to be told once the
+// captures queued before it have been.
+type storeWrite struct {
+	funnelID string
+	file     string
+	record   storedCapture
+	flushed  chan struct{}
+}
+
+// OpenCaptureStore opens the store in dir, creating it if needed, and prunes
+// captures past the retention limits.
+func OpenCaptureStore(dir string, maxAge time.Duration, maxBytes int64) (*CaptureStore, error) {
+	return openCaptureStore(dir, maxAge, maxBytes, storePruneInterval)
+}
+
+func openCaptureStore(dir string, maxAge time.Duration, maxBytes int64, pruneInterval time.Duration) (*CaptureStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create capture store %s: %w", dir, err)
+	}
+	s := &CaptureStore{
+		dir:      dir,
+		maxAge:   maxAge,
+		maxBytes: maxBytes,
+		queue:    make(chan storeWrite, storeQueueLength),
+		done:     make(chan struct{}),
+		files:    make(map[string]*os.File),
+	}
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	go s.run(pruneInterval)
+	return s, nil
+}
+
+// Dir returns the directory the captures are stored in.
+func (s *CaptureStore) Dir() string {
+	return s.dir
+}
+
+// Save queues a finished capture of the funnel to be appended to the store.  It
+// only fails when the store is closed or too far behind to take the capture, the
+// errors writing it are reported to the handler given to setErrorHandler.
+func (s *CaptureStore) Save(funnel Funnel, capture CaptureRequestResponse) error {
+	s.queueMu.RLock()
+	defer s.queueMu.RUnlock()
+	if s.closed {
+		return errors.New("capture store is closed")
+	}
+
+	write := storeWrite{
+		funnelID: funnel.ID(),
+		file:     storeFileName(funnelName(funnel)),
+		record:   newStoredCapture(funnel, capture),
+	}
+	select {
+	case s.queue <- write:
+		return nil
+	default:
+		return fmt.Errorf("capture store is too far behind, capture %s was dropped", capture.ID)
+	}
+}
+
+// setErrorHandler has fn called with the errors writing and pruning the store,
+// funnelID is empty for the latter.
+func (s *CaptureStore) setErrorHandler(fn func(funnelID string, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onError = fn
+}
+
+// run writes the queued captures until the queue is closed, pruning the captures
+// past maxAge every pruneInterval.
+func (s *CaptureStore) run(pruneInterval time.Duration) {
+	defer close(s.done)
+
+	var prunes <-chan time.Time // never ticks without a maxAge
+	if s.maxAge > 0 {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		prunes = ticker.C
+	}
+
+	for {
+		select {
+		case write, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			if write.flushed != nil {
+				close(write.flushed)
+				continue
+			}
+			if err := s.write(write); err != nil {
+				s.reportError(write.funnelID, err)
+			}
+		case <-prunes:
+			s.mu.Lock()
+			err := s.prune()
+			s.mu.Unlock()
+			if err != nil {
+				s.reportError("", err)
+			}
+		}
+	}
+}
+
+func (s *CaptureStore) reportError(funnelID string, err error) {
+	s.mu.Lock()
+	onError := s.onError
+	s.mu.Unlock()
+	if onError != nil {
+		onError(funnelID, err)
+	}
+}
+
+// write appends a queued capture to its file, pruning the store if that takes it
+// over maxBytes.
+func (s *CaptureStore) write(write storeWrite) error {
+	line, err := json.Marshal(write.record)
+	if err != nil {
+		return fmt.Errorf("failed to encode capture %s: %w", write.record.ID, err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[write.file]
+	if !ok {
+		file, err = os.OpenFile(filepath.Join(s.dir, write.file), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open capture store: %w", err)
+		}
+		s.files[write.file] = file
+	}
+	n, err := file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write capture %s: %w", write.record.ID, err)
+	}
+
+	if s.maxBytes > 0 && s.size > s.maxBytes {
+		return s.prune()
+	}
+	return nil
+}
+
+// flush waits for the captures saved so far to be written.
+func (s *CaptureStore) flush() {
+	s.queueMu.RLock()
+	if s.closed {
+		s.queueMu.RUnlock()
+		return // Close has written them
+	}
+	flushed := make(chan struct{})
+	s.queue <- storeWrite{flushed: flushed}
+	s.queueMu.RUnlock()
+	<-flushed
+}
+
+// Load returns the stored captures as archived funnels, one per funnel name,
+// including those saved but not yet written.
+func (s *CaptureStore) Load() ([]Funnel, error) {
+	s.flush()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.fileNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var funnels []Funnel
+	for _, name := range names {
+		var records []storedCapture
+		err := readStoreFile(filepath.Join(s.dir, name), func(line []byte) {
+			var record storedCapture
+			if json.Unmarshal(line, &record) == nil {
+				records = append(records, record)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			continue
+		}
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].Timestamp.Before(records[j].Timestamp)
+		})
+
+		// the funnel is described by its most recent capture
+		latest := records[len(records)-1]
+		id := "archive-" + strings.TrimSuffix(name, storeFileExt)
+		requests := NewRequestList(0)
+		for _, record := range records {
+			capture := record.capture()
+			capture.FunnelID = id
+			requests.Add(capture)
+		}
+		funnels = append(funnels, NewArchivedFunnel(id, latest.LocalTarget, latest.RemoteTarget, requests))
+	}
+	return funnels, nil
+}
+
+// Close writes the captures still queued, then closes the files the store is
+// appending to.
+func (s *CaptureStore) Close() error {
+	s.queueMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.queueMu.Unlock()
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFiles()
+}
+
+func (s *CaptureStore) closeFiles() error {
+	var errs []error
+	for name, file := range s.files {
+		errs = append(errs, file.Close())
+		delete(s.files, name)
+	}
+	return errors.Join(errs...)
+}
+
+// fileNames lists the store's files, s.mu must be held.
+func (s *CaptureStore) fileNames() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture store: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), storeFileExt) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// prune rewrites the store without captures older than maxAge, then drops the
+// oldest captures until it is back under three quarters of maxBytes, so it isn't
+// rewritten again on the next save.  The files are left alone when nothing had to
+// be dropped.  s.mu must be held.
+func (s *CaptureStore) prune() error {
+	if err := s.closeFiles(); err != nil {
+		return fmt.Errorf("failed to close capture store: %w", err)
+	}
+
+	names, err := s.fileNames()
+	if err != nil {
+		return err
+	}
+
+	type storedLine struct {
+		file      string
+		timestamp time.Time
+		data      []byte
+	}
+	var lines []storedLine
+	var size int64
+	dropped := 0
+	for _, name := range names {
+		err := readStoreFile(filepath.Join(s.dir, name), func(line []byte) {
+			var header struct {
+				Timestamp time.Time `json:"timestamp"`
+			}
+			if json.Unmarshal(line, &header) != nil {
+				dropped++ // e.g. a partial line from a crash
+				return
+			}
+			if s.maxAge > 0 && time.Since(header.Timestamp) > s.maxAge {
+				dropped++
+				return
+			}
+			lines = append(lines, storedLine{file: name, timestamp: header.Timestamp, data: append([]byte(nil), line...)})
+			size += int64(len(line) + 1)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].timestamp.Before(lines[j].timestamp)
+	})
+	if s.maxBytes > 0 && size > s.maxBytes {
+		target := s.maxBytes * 3 / 4
+		for len(lines) > 0 && size > target {
+			size -= int64(len(lines[0].data) + 1)
+			lines = lines[1:]
+			dropped++
+		}
+	}
+	s.size = size
+	if dropped == 0 {
+		return nil
+	}
+
+	contents := make(map[string]*bytes.Buffer, len(names))
+	for _, name := range names {
+		contents[name] = &bytes.Buffer{}
+	}
+	for _, line := range lines {
+		contents[line.file].Write(line.data)
+		contents[line.file].WriteByte('\n')
+	}
+	for name, content := range contents {
+		if err := writeStoreFile(filepath.Join(s.dir, name), content.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStoreFile calls fn with each line of the file.
+func readStoreFile(path string, fn func(line []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read capture store: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), storeMaxLineBytes)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			fn(scanner.Bytes())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read capture store %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeStoreFile replaces the file at path with data, removing it when data is empty.
+func writeStoreFile(path string, data []byte) error {
+	if len(data) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to prune capture store: %w", err)
+		}
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to prune capture store: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to prune capture store: %w", err)
+	}
+	return nil
+}
+
+// storeFileName maps a funnel name to the name of its file in the store.
+func storeFileName(funnelName string) string {
+	return safeFileName(funnelName) + storeFileExt
+}
+
+// safeFileName replaces anything but letters, digits, dashes and underscores in name.
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
+
+// storedCapture is a capture as written to the store.
+type storedCapture struct {
+	Funnel       string `json:"funnel"`
+	RemoteTarget string `json:"remote_target"`
+	LocalTarget  string `json:"local_target"`
+
+	ID        string           `json:"id"`
+	Timestamp time.Time        `json:"timestamp"`
+	Duration  time.Duration    `json:"duration"`
+	Request   storedMessage    `json:"request"`
+	Response  storedMessage    `json:"response"`
+	Error     string           `json:"error,omitempty"`
+	ErrorKind ProxyErrorKind   `json:"error_kind,omitempty"`
+	ReplayOf  string           `json:"replay_of,omitempty"`
+	Composed  bool             `json:"composed,omitempty"`
+	WebSocket *storedWebSocket `json:"websocket,omitempty"`
+	Events    *storedEvents    `json:"events,omitempty"`
+}
+
+type storedMessage struct {
+	Method        string      `json:"method,omitempty"`
+	URL           string      `json:"url,omitempty"`
+	StatusCode    int         `json:"status,omitempty"`
+	Headers       http.Header `json:"headers,omitempty"`
+	Body          []byte      `json:"body,omitempty"`
+	BodySize      int64       `json:"body_size,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+type storedWebSocket struct {
+	Frames []WebSocketFrame `json:"frames"`
+	Count  int              `json:"count"`
+}
+
+type storedEvents struct {
+	Events []SSEEvent `json:"events"`
+	Count  int        `json:"count"`
+}
+
+func newStoredCapture(funnel Funnel, c CaptureRequestResponse) storedCapture {
+	record := storedCapture{
+		Funnel:       funnelName(funnel),
+		RemoteTarget: funnel.RemoteTarget(),
+		LocalTarget:  funnel.LocalTarget(),
+		ID:           c.ID,
+		Timestamp:    c.Timestamp,
+		Duration:     c.Duration,
+		Request: storedMessage{
+			Method:        c.Request.Method,
+			URL:           c.Request.URL,
+			Headers:       c.Request.Headers,
+			Body:          c.Request.Body,
+			BodySize:      c.Request.BodySize,
+			BodyTruncated: c.Request.BodyTruncated,
+		},
+		Response: storedMessage{
+			StatusCode:    c.Response.StatusCode,
+			Headers:       c.Response.Headers,
+			Body:          c.Response.Body,
+			BodySize:      c.Response.BodySize,
+			BodyTruncated: c.Response.BodyTruncated,
+		},
+		Error:     c.Error,
+		ErrorKind: c.ErrorKind,
+		ReplayOf:  c.ReplayOf,
+		Composed:  c.Composed,
+	}
+	if c.WebSocket != nil {
+		record.WebSocket = &storedWebSocket{Frames: c.WebSocket.Frames(), Count: c.WebSocket.Count()}
+	}
+	if c.ServerSentEvents != nil {
+		record.Events = &storedEvents{Events: c.ServerSentEvents.Events(), Count: c.ServerSentEvents.Count()}
+	}
+	return record
+}
+
+// capture restores the stored capture, its frame and event logs are closed.
+func (r storedCapture) capture() CaptureRequestResponse {
+	c := CaptureRequestResponse{
+		ID:        r.ID,
+		Timestamp: r.Timestamp,
+		Duration:  r.Duration,
+		Request: CaptureRequest{
+			Method:        r.Request.Method,
+			URL:           r.Request.URL,
+			Headers:       r.Request.Headers,
+			Body:          r.Request.Body,
+			BodySize:      r.Request.BodySize,
+			BodyTruncated: r.Request.BodyTruncated,
+		},
+		Response: CaptureResponse{
+			StatusCode:    r.Response.StatusCode,
+			Headers:       r.Response.Headers,
+			Body:          r.Response.Body,
+			BodySize:      r.Response.BodySize,
+			BodyTruncated: r.Response.BodyTruncated,
+		},
+		Error:     r.Error,
+		ErrorKind: r.ErrorKind,
+		ReplayOf:  r.ReplayOf,
+		Composed:  r.Composed,
+	}
+	if r.WebSocket != nil {
+		log := &WebSocketLog{frames: r.WebSocket.Frames, count: r.WebSocket.Count, closed: true}
+		for _, frame := range log.frames {
+			log.bytes += int64(len(frame.Payload))
+		}
+		c.WebSocket = log
+	}
+	if r.Events != nil {
+		log := &SSELog{events: r.Events.Events, count: r.Events.Count}
+		for _, event := range log.events {
+			log.bytes += int64(len(event.Data))
+		}
+		c.ServerSentEvents = log
+	}
+	return c
+}
@@ -0,0 +1,242 @@
+package funnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func newStoreTestFunnel(name string) Funnel {
+	return Funnel{HTTPFunnel: &HTTPFunnel{
+		id:           "live-" + name,
+		localTarget:  "http://localhost:8080",
+		remoteTarget: "https://" + name + ".example.ts.net",
+	}}
+}
+
+func TestCaptureStore_SaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenCaptureStore(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenCaptureStore() unexpected error: %v", err)
+	}
+
+	start := time.Now().Add(-time.Minute).Round(0)
+	events := newSSELog(10)
+	events.add(SSEEvent{Event: "message", Data: "hi", Size: 2, Timestamp: start})
+	captures := []CaptureRequestResponse{
+		{
+			ID:        "first",
+			Timestamp: start,
+			Duration:  15 * time.Millisecond,
+			Request:   CaptureRequest{Method: "POST", URL: "http://localhost:8080/hook", Body: []byte("{}"), BodySize: 2, Headers: http.Header{"Content-Type": {"application/json"}}},
+			Response:  CaptureResponse{StatusCode: 200, Body: []byte("ok"), BodySize: 10, BodyTruncated: true, Headers: http.Header{"Set-Cookie": {"a=1", "b=2"}}},
+		},
+		{
+			ID:        "second",
+			Timestamp: start.Add(time.Second),
+			Request:   CaptureRequest{Method: "GET", URL: "http://localhost:8080/stream"},
+			Response:  CaptureResponse{StatusCode: 200},
+			// the logs are only compared by their contents below
+			ServerSentEvents: events,
+		},
+		{
+			ID:        "third",
+			Timestamp: start.Add(2 * time.Second),
+			Request:   CaptureRequest{Method: "GET", URL: "http://localhost:8080/"},
+			Response:  CaptureResponse{StatusCode: 502},
+			Error:     "dial tcp: connection refused",
+			ErrorKind: ProxyErrorRefused,
+		},
+	}
+	for _, c := range captures {
+		if err := store.Save(newStoreTestFunnel("webhook"), c); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+	if err := store.Save(newStoreTestFunnel("other"), CaptureRequestResponse{ID: "elsewhere", Timestamp: start}); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+
+	// a partial line, e.g. from a crash mid-write, is skipped
+	file, err := os.OpenFile(filepath.Join(dir, "webhook.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString(`{"id":"partial","timest`)
+	file.Close()
+
+	store, err = OpenCaptureStore(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenCaptureStore() unexpected error: %v", err)
+	}
+	defer store.Close()
+	funnels, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if len(funnels) != 2 {
+		t.Fatalf("Expected 2 archived funnels, got %d", len(funnels))
+	}
+
+	var webhook Funnel
+	for _, f := range funnels {
+		if f.Name() == "webhook" {
+			webhook = f
+		}
+	}
+	if !webhook.Archived || webhook.ID() != "archive-webhook" || webhook.LocalTarget() != "http://localhost:8080" {
+		t.Fatalf("Unexpected archived funnel: archived %v, id %q, target %q", webhook.Archived, webhook.ID(), webhook.LocalTarget())
+	}
+
+	var got []CaptureRequestResponse
+	for node := webhook.Requests.Head; node != nil; node = node.Next {
+		got = append(got, node.Request)
+	}
+	want := []CaptureRequestResponse{captures[2], captures[1], captures[0]} // newest first
+	for i := range want {
+		want[i].FunnelID = "archive-webhook"
+	}
+	opts := cmp.Options{
+		cmpopts.IgnoreFields(CaptureRequestResponse{}, "ServerSentEvents"),
+		cmpopts.EquateEmpty(),
+	}
+	if diff := cmp.Diff(want, got, opts); diff != "" {
+		t.Errorf("Loaded captures mismatch (-want +got):\n%s", diff)
+	}
+	if got[1].ServerSentEvents == nil || cmp.Diff(events.Events(), got[1].ServerSentEvents.Events()) != "" {
+		t.Errorf("Expected the server-sent events to be restored, got %+v", got[1].ServerSentEvents)
+	}
+}
+
+func TestCaptureStore_Retention(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenCaptureStore(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenCaptureStore() unexpected error: %v", err)
+	}
+	now := time.Now().Round(time.Second) // so every line has the same length
+	body := []byte(strings.Repeat("x", 1000))
+	for i, age := range []time.Duration{48 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		c := CaptureRequestResponse{ID: string(rune('a' + i)), Timestamp: now.Add(-age), Response: CaptureResponse{Body: body}}
+		if err := store.Save(newStoreTestFunnel("webhook"), c); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+	store.Close()
+	info, err := os.Stat(filepath.Join(dir, "webhook.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineSize := info.Size() / 4
+
+	// the capture from two days ago is too old, and the other three don't fit so are
+	// pruned to three quarters of the limit
+	store, err = OpenCaptureStore(dir, 24*time.Hour, 3*lineSize-1)
+	if err != nil {
+		t.Fatalf("OpenCaptureStore() unexpected error: %v", err)
+	}
+	defer store.Close()
+	funnels, err := store.Load()
+	if err != nil || len(funnels) != 1 {
+		t.Fatalf("Load() = %d funnels, %v; want 1 funnel", len(funnels), err)
+	}
+	if diff := cmp.Diff([]string{"d", "c"}, listIDs(funnels[0].Requests)); diff != "" {
+		t.Errorf("Retained captures mismatch (-want +got):\n%s", diff)
+	}
+
+	// going over the limit while running prunes the oldest captures straight away
+	if err := store.Save(newStoreTestFunnel("another"), CaptureRequestResponse{ID: "e", Timestamp: now, Response: CaptureResponse{Body: body}}); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+	funnels, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	var ids []string
+	for _, f := range funnels {
+		ids = append(ids, listIDs(f.Requests)...)
+	}
+	if diff := cmp.Diff([]string{"e", "d"}, ids, cmpopts.SortSlices(func(a, b string) bool { return a > b })); diff != "" {
+		t.Errorf("Captures after pruning mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCaptureStore_PrunesWhileOpen(t *testing.T) {
+	store, err := openCaptureStore(t.TempDir(), time.Hour, 0, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("openCaptureStore() unexpected error: %v", err)
+	}
+	// expires a moment after it is written
+	expiring := CaptureRequestResponse{ID: "expiring", Timestamp: time.Now().Add(-time.Hour + 50*time.Millisecond)}
+	for _, c := range []CaptureRequestResponse{expiring, {ID: "recent", Timestamp: time.Now()}} {
+		if err := store.Save(newStoreTestFunnel("webhook"), c); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+
+	var ids []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		funnels, err := store.Load()
+		if err != nil || len(funnels) != 1 {
+			t.Fatalf("Load() = %d funnels, %v; want 1 funnel", len(funnels), err)
+		}
+		if ids = listIDs(funnels[0].Requests); len(ids) == 1 {
+			break
+		}
+	}
+	if diff := cmp.Diff([]string{"recent"}, ids); diff != "" {
+		t.Errorf("Expected the expired capture to be pruned (-want +got):\n%s", diff)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+	if err := store.Save(newStoreTestFunnel("webhook"), CaptureRequestResponse{ID: "late"}); err == nil {
+		t.Error("Expected Save() to fail once the store is closed")
+	}
+}
+
+func TestHandleRequest_SavesCaptures(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("stored"))
+	}))
+	defer backend.Close()
+
+	s, _ := newTestProxy(t, backend.URL, nil)
+	store, err := OpenCaptureStore(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("OpenCaptureStore() unexpected error: %v", err)
+	}
+	defer store.Close()
+	s.SetCaptureStore(store)
+
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HttpServerPath+"test-funnel/saved", nil))
+
+	funnels, err := store.Load()
+	if err != nil || len(funnels) != 1 || funnels[0].Requests.Head == nil {
+		t.Fatalf("Load() = %d funnels, %v; want the saved capture", len(funnels), err)
+	}
+	saved := funnels[0].Requests.Head.Request
+	if saved.Path() != "/saved" || string(saved.Response.Body) != "stored" {
+		t.Errorf("Unexpected saved capture: path %q, body %q", saved.Path(), saved.Response.Body)
+	}
+
+	// archived funnels aren't proxied
+	s.funnelRegistry.AddFunnel(funnels[0])
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, httptest.NewRequest(http.MethodGet, HttpServerPath+funnels[0].ID()+"/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an archived funnel to return 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package funnel
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_newLocalTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		insecure   bool
+		caCertFile string
+		wantErr    bool
+	}{
+		{name: "Default rejects self-signed", wantErr: true},
+		{name: "Insecure skips verification", insecure: true},
+		{name: "Custom CA is trusted", caCertFile: caFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := newLocalTransport(tt.insecure, tt.caCertFile)
+			if err != nil {
+				t.Fatalf("newLocalTransport() unexpected error: %v", err)
+			}
+			defer transport.CloseIdleConnections()
+
+			client := &http.Client{Transport: transport}
+			resp, err := client.Get(server.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNoContent {
+					t.Errorf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCACertPool_Invalid(t *testing.T) {
+	if _, err := LoadCACertPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "bad.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCACertPool(notPEM); err == nil {
+		t.Errorf("Expected error for file without certificates")
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"html/template"
 	stdlog "log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -76,39 +78,95 @@ type HTTPFunnelOptions struct {
 	RemotePort uint16 // remote port to tunnel to use.  one of 443, 8443, 10000
 	HTTPS      bool   // local server uses TLS
 	Insecure   bool   // ignore TLS certificate errors for local server
+	CACertFile string // optional PEM bundle of extra CAs to trust for the local server
 	// Mount      string // mount point for the local server, almost always "/" unless you want to serve a subdirectory
 	Inspect bool // hijack to local tsgrok server
 }
 
 type HTTPFunnel struct {
 	id             string
+	dnsName        string // dns name of the node serving the funnel
+	remotePort     uint16
 	remoteTarget   string
 	internalTarget string
 	localTarget    string
-	inspect        bool
+	insecure       bool            // local target is https with verification disabled
+	transport      *http.Transport // used by the inspection proxy to reach the local target
+
+	mu      sync.RWMutex
+	inspect bool // traffic is routed through the inspection proxy rather than straight to the local target
+}
+
+// Inspect reports whether traffic is routed through the local inspection proxy.
+func (h *HTTPFunnel) Inspect() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.inspect
+}
+
+// serveTarget returns the proxy target tailscale should forward funnel traffic to.
+// Inspected funnels go to the tsgrok inspection server, others straight to the local target.
+func (h *HTTPFunnel) serveTarget(inspect bool) string {
+	if inspect {
+		return h.internalTarget
+	}
+	if h.insecure {
+		return strings.Replace(h.localTarget, "https://", "https+insecure://", 1)
+	}
+	return h.localTarget
+}
+
+// SetInspect switches the funnel between inspected and pass-through mode by
+// repointing the node's serve config, without recreating the node.
+func (c *TailscaleClient) SetInspect(h *HTTPFunnel, inspect bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.inspect == inspect {
+		return nil
+	}
+
+	ctx := context.Background()
+	sc, err := c.ts.GetServeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
+	}
+
+	if err := applyWebServe(sc, h.dnsName, h.remotePort, true, "/", h.serveTarget(inspect)); err != nil {
+		return err
+	}
+
+	if err := c.ts.SetServeConfig(ctx, sc); err != nil {
+		return err
+	}
+
+	h.inspect = inspect
+	return nil
 }
 
-func (c *TailscaleClient) CreateHTTPFunnel(opts HTTPFunnelOptions) (HTTPFunnel, error) {
+func (c *TailscaleClient) CreateHTTPFunnel(ctx context.Context, opts HTTPFunnelOptions) (*HTTPFunnel, error) {
 
 	if opts.ID == "" {
 		opts.ID = uuid.New().String()
 	}
 
-	if opts.RemotePort != 443 && opts.RemotePort != 8443 && opts.RemotePort != 10000 {
-		return HTTPFunnel{}, fmt.Errorf("invalid remote port %d", opts.RemotePort)
+	if err := ValidateRemotePort(opts.RemotePort); err != nil {
+		return nil, err
 	}
 
-	// todo: get ctx
-	status, err := c.ts.StatusWithoutPeers(context.Background())
+	status, err := c.ts.StatusWithoutPeers(ctx)
 	if err != nil {
-		return HTTPFunnel{}, err
+		return nil, err
 	}
 	c.status = status
 
-	sc, err := c.ts.GetServeConfig(context.Background())
+	sc, err := c.ts.GetServeConfig(ctx)
 
 	if err != nil {
-		return HTTPFunnel{}, err
+		return nil, err
 	}
 
 	if sc == nil {
@@ -127,35 +185,48 @@ func (c *TailscaleClient) CreateHTTPFunnel(opts HTTPFunnelOptions) (HTTPFunnel,
 	// look up the host from the config.  remove the trailing '.' if it exists
 	host := strings.TrimSuffix(status.Self.DNSName, ".")
 
-	// set the scheme for the local server
+	// set the scheme for the local server.  "+insecure" is only understood by tailscale,
+	// the inspection proxy handles it through its transport instead.
 	scheme := "http"
 	if opts.HTTPS {
 		scheme = "https"
 	}
-	if opts.HTTPS && opts.Insecure {
-		scheme = "https+insecure"
+
+	transport, err := newLocalTransport(opts.HTTPS && opts.Insecure, opts.CACertFile)
+	if err != nil {
+		return nil, err
 	}
 
 	internalPort := uint16(util.DefaultPort)
 	internalMount := fmt.Sprintf("/tsgrok/%s", opts.ID)
 
-	remoteTarget := fmt.Sprintf("https://%s:%d", host, opts.RemotePort)
-	internalTarget := fmt.Sprintf("%s://localhost:%d%s", scheme, internalPort, internalMount)
-	localTarget := fmt.Sprintf("%s://localhost:%d", scheme, opts.LocalPort)
+	httpFunnel := &HTTPFunnel{
+		id:           opts.ID,
+		dnsName:      host,
+		remotePort:   opts.RemotePort,
+		remoteTarget: fmt.Sprintf("https://%s:%d", host, opts.RemotePort),
+		// the inspection server is always plain http, regardless of the local target
+		internalTarget: fmt.Sprintf("http://localhost:%d%s", internalPort, internalMount),
+		localTarget:    fmt.Sprintf("%s://localhost:%d", scheme, opts.LocalPort),
+		insecure:       opts.HTTPS && opts.Insecure,
+		transport:      transport,
+		inspect:        opts.Inspect,
+	}
+	remoteTarget := httpFunnel.remoteTarget
 
 	// Ensure the mount point starts with a slash if not empty
 	safeMount := "/"
 
 	// useTLS arg is always true for funnels, they are not allowed to use http
-	err = applyWebServe(sc, host, opts.RemotePort, true, safeMount, internalTarget)
+	err = applyWebServe(sc, host, opts.RemotePort, true, safeMount, httpFunnel.serveTarget(opts.Inspect))
 	if err != nil {
-		return HTTPFunnel{}, err
+		return nil, err
 	}
 
 	sc.SetFunnel(host, opts.RemotePort, true)
 
-	if err := c.ts.SetServeConfig(context.Background(), sc); err != nil {
-		return HTTPFunnel{}, err
+	if err := c.ts.SetServeConfig(ctx, sc); err != nil {
+		return nil, err
 	}
 
 	// we fire off a request to the new funnel url to trigger certificate generation
@@ -171,46 +242,101 @@ func (c *TailscaleClient) CreateHTTPFunnel(opts HTTPFunnelOptions) (HTTPFunnel,
 		}
 	}()
 
-	return HTTPFunnel{
-		id:             opts.ID,
-		remoteTarget:   remoteTarget,
-		internalTarget: internalTarget,
-		localTarget:    localTarget,
-		inspect:        opts.Inspect,
-	}, nil
+	return httpFunnel, nil
 }
 
 func (c *TailscaleClient) Logout() error {
 	return c.ts.Logout(context.Background())
 }
 
-func CreateEphemeralFunnel(name string, target string, logger *stdlog.Logger) (Funnel, error) {
+// EphemeralFunnelOptions holds configuration for creating a funnel on its own ephemeral node.
+type EphemeralFunnelOptions struct {
+	Name         string // tailscale node name
+	Target       string // local target, e.g. 8080, localhost:8080 or http://localhost:8080
+	RemotePort   uint16 // public port, one of 443, 8443, 10000.  defaults to 443
+	Inspect      bool   // route traffic through the local tsgrok inspection proxy
+	CACertFile   string // optional PEM bundle of extra CAs to trust for an https target
+	MaxRequests  int    // captured requests to keep, defaults to util.GetMaxRequests()
+	MaxBodyBytes int64  // bytes of each body to capture, defaults to util.DefaultMaxBodyBytes
+	ErrorPage    string // optional html/template returned to the public client when the target can't be reached
+}
+
+// ErrFunnelPortNotAllowed is returned when the tailnet policy doesn't allow a funnel on the requested port.
+var ErrFunnelPortNotAllowed = errors.New("remote port not allowed for funnels by tailnet policy")
+
+// ValidateRemotePort checks that port is one of the ports tailscale allows funnels on.
+func ValidateRemotePort(port uint16) error {
+	if port != 443 && port != 8443 && port != 10000 {
+		return fmt.Errorf("invalid remote port %d, must be one of 443, 8443 or 10000", port)
+	}
+	return nil
+}
+
+// ParseLocalTarget expands a user supplied target (port, host:port or URL) into a URL
+// and ensures it includes a port.
+func ParseLocalTarget(target string) (*url.URL, error) {
 	target, err := ipn.ExpandProxyTargetValue(target, []string{"http", "https", "https+insecure"}, "http")
 	if err != nil {
-		return Funnel{}, err
+		return nil, err
 	}
 
 	targetURL, err := url.Parse(target)
 	if err != nil {
+		return nil, err
+	}
+
+	if targetURL.Port() == "" {
+		return nil, fmt.Errorf("no port specified in target")
+	}
+	return targetURL, nil
+}
+
+// CreateEphemeralFunnel brings up an ephemeral node named after the funnel and
+// funnels its remote port to the target.  Cancelling ctx gives up on the startup,
+// shutting the node down again.
+func CreateEphemeralFunnel(ctx context.Context, opts EphemeralFunnelOptions, logger *stdlog.Logger) (Funnel, error) {
+	if opts.RemotePort == 0 {
+		opts.RemotePort = 443
+	}
+	if err := ValidateRemotePort(opts.RemotePort); err != nil {
 		return Funnel{}, err
 	}
+	if opts.MaxRequests == 0 {
+		opts.MaxRequests = util.GetMaxRequests()
+	}
 
-	localPort := targetURL.Port()
-	if localPort == "" {
-		return Funnel{}, fmt.Errorf("no port specified in target")
+	targetURL, err := ParseLocalTarget(opts.Target)
+	if err != nil {
+		return Funnel{}, err
 	}
+
+	localPort := targetURL.Port()
 	localPortInt, err := strconv.Atoi(localPort)
 	if err != nil {
 		return Funnel{}, fmt.Errorf("invalid port %s", localPort)
 	}
 
+	// fail fast on a bad CA bundle or error page, before bringing up a node
+	if opts.CACertFile != "" {
+		if _, err := LoadCACertPool(opts.CACertFile); err != nil {
+			return Funnel{}, err
+		}
+	}
+	var errorPage *template.Template
+	if opts.ErrorPage != "" {
+		errorPage, err = LoadErrorPage(opts.ErrorPage)
+		if err != nil {
+			return Funnel{}, err
+		}
+	}
+
 	memStore, err := mem.New(nil, "tsgrok")
 	if err != nil {
 		return Funnel{}, err
 	}
 
 	ts := &tsnet.Server{
-		Hostname:  name,
+		Hostname:  opts.Name,
 		Ephemeral: true,
 		Store:     memStore,
 		AuthKey:   util.GetAuthKey(),
@@ -220,14 +346,24 @@ func CreateEphemeralFunnel(name string, target string, logger *stdlog.Logger) (F
 
 	// we have already checked for auth key, so this would infer bad auth or some other error
 	// if it doesn't start up in a reasonable amount of time
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	upCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
-	st, err := ts.Up(ctx)
+	st, err := ts.Up(upCtx)
 	if err != nil {
 		return Funnel{}, err
 	}
 
+	// shut the node down again if we fail to set up the funnel on it
+	success := false
+	defer func() {
+		if !success {
+			if err := ts.Close(); err != nil {
+				logger.Printf("Error closing node %s: %v", opts.Name, err)
+			}
+		}
+	}()
+
 	localClient, err := ts.LocalClient()
 	if err != nil {
 		return Funnel{}, err
@@ -238,14 +374,10 @@ func CreateEphemeralFunnel(name string, target string, logger *stdlog.Logger) (F
 		return Funnel{}, fmt.Errorf("locally created ephmeral node cannot create funnels: %v", err)
 	}
 
-	remotePort := uint16(443)
+	remotePort := opts.RemotePort
 	err = ipn.CheckFunnelPort(remotePort, st.Self)
 	if err != nil {
-		return Funnel{}, fmt.Errorf("locally created ephmeral node cannot create funnel on port %d: %v", remotePort, err)
-	}
-
-	if err := ipn.CheckFunnelAccess(remotePort, st.Self); err != nil {
-		return Funnel{}, fmt.Errorf("locally created ephmeral node cannot create funnel on port %d: %v", remotePort, err)
+		return Funnel{}, fmt.Errorf("%w: %v (funnel ports are set by the \"funnel\" nodeAttr in your tailnet policy file)", ErrFunnelPortNotAllowed, err)
 	}
 
 	// ok go create the funnel
@@ -255,21 +387,26 @@ func CreateEphemeralFunnel(name string, target string, logger *stdlog.Logger) (F
 	}
 
 	funnelID := uuid.New().String()
-	httpFunnel, err := tsClient.CreateHTTPFunnel(HTTPFunnelOptions{
+	httpFunnel, err := tsClient.CreateHTTPFunnel(ctx, HTTPFunnelOptions{
 		ID:         funnelID,
 		LocalPort:  uint16(localPortInt),
 		RemotePort: remotePort,
-		HTTPS:      false,
-		Inspect:    true,
+		HTTPS:      targetURL.Scheme == "https" || targetURL.Scheme == "https+insecure",
+		Insecure:   targetURL.Scheme == "https+insecure",
+		CACertFile: opts.CACertFile,
+		Inspect:    opts.Inspect,
 	})
 
 	if err != nil {
 		return Funnel{}, err
 	}
 
+	success = true
 	return Funnel{
-		HTTPFunnel: &httpFunnel,
-		Client:     &tsClient,
-		Requests:   &RequestList{maxLength: 100},
+		HTTPFunnel:   httpFunnel,
+		Client:       &tsClient,
+		Requests:     NewRequestList(opts.MaxRequests),
+		MaxBodyBytes: opts.MaxBodyBytes,
+		ErrorPage:    errorPage,
 	}, nil
 }
@@ -0,0 +1,37 @@
+package funnel
+
+import "testing"
+
+func TestHTTPFunnel_serveTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		funnel  *HTTPFunnel
+		inspect bool
+		want    string
+	}{
+		{
+			name:    "Inspected funnels go to the inspection server",
+			funnel:  &HTTPFunnel{internalTarget: "http://localhost:4141/tsgrok/abc", localTarget: "https://localhost:8443"},
+			inspect: true,
+			want:    "http://localhost:4141/tsgrok/abc",
+		},
+		{
+			name:   "Pass-through goes to the local target",
+			funnel: &HTTPFunnel{internalTarget: "http://localhost:4141/tsgrok/abc", localTarget: "http://localhost:8080"},
+			want:   "http://localhost:8080",
+		},
+		{
+			name:   "Pass-through keeps insecure for tailscale",
+			funnel: &HTTPFunnel{internalTarget: "http://localhost:4141/tsgrok/abc", localTarget: "https://localhost:8443", insecure: true},
+			want:   "https+insecure://localhost:8443",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.funnel.serveTarget(tt.inspect); got != tt.want {
+				t.Errorf("serveTarget(%v) = %q, want %q", tt.inspect, got, tt.want)
+			}
+		})
+	}
+}
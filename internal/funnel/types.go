@@ -2,10 +2,13 @@ package funnel
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/jonson/tsgrok/internal/util"
 )
 
 type RequestListNode struct {
@@ -19,22 +22,23 @@ type RequestList struct {
 	Tail      *RequestListNode
 	Length    int
 	maxLength int
+	bytes     int64          // approximate memory held by the captures
+	budget    *CaptureBudget // shared budget the list is accounted against, if any
 	mu        sync.Mutex
 }
 
+// NewRequestList returns a list keeping at most maxLength requests, <= 0 keeps all of them.
+func NewRequestList(maxLength int) *RequestList {
+	return &RequestList{maxLength: maxLength}
+}
+
 func (r *RequestList) Add(request CaptureRequestResponse) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
+	var released int64
 	if r.maxLength > 0 && r.Length == r.maxLength {
 		// remove the oldest request
-		r.Tail = r.Tail.Prev
-		if r.Tail == nil {
-			r.Head = nil
-		} else {
-			r.Tail.Next = nil
-		}
-		r.Length--
+		released = r.removeOldest()
 	}
 
 	node := &RequestListNode{Request: request}
@@ -48,6 +52,160 @@ func (r *RequestList) Add(request CaptureRequestResponse) {
 		r.Head = node
 	}
 	r.Length++
+
+	size := request.footprint()
+	r.bytes += size
+	budget := r.budget
+	r.mu.Unlock()
+
+	if budget != nil {
+		budget.adjust(size - released)
+	}
+}
+
+// removeOldest drops the tail of the list, returning its footprint.  r.mu must be held.
+func (r *RequestList) removeOldest() int64 {
+	if r.Tail == nil {
+		return 0
+	}
+	return r.remove(r.Tail)
+}
+
+// oldestFinished returns the oldest capture that isn't in progress, or nil if there
+// is none.  r.mu must be held.
+func (r *RequestList) oldestFinished() *RequestListNode {
+	for node := r.Tail; node != nil; node = node.Prev {
+		if !node.Request.InProgress {
+			return node
+		}
+	}
+	return nil
+}
+
+// remove unlinks the node from the list, returning its footprint.  r.mu must be held.
+func (r *RequestList) remove(node *RequestListNode) int64 {
+	if node.Prev == nil {
+		r.Head = node.Next
+	} else {
+		node.Prev.Next = node.Next
+	}
+	if node.Next == nil {
+		r.Tail = node.Prev
+	} else {
+		node.Next.Prev = node.Prev
+	}
+	node.Prev, node.Next = nil, nil
+
+	size := node.Request.footprint()
+	r.Length--
+	r.bytes -= size
+	return size
+}
+
+// MaxLength returns how many requests the list keeps, <= 0 meaning no limit.
+func (r *RequestList) MaxLength() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.maxLength
+}
+
+// SetMaxLength changes how many requests the list keeps, dropping the oldest ones
+// if it is now over the limit.
+func (r *RequestList) SetMaxLength(maxLength int) {
+	r.mu.Lock()
+	r.maxLength = maxLength
+	var released int64
+	for maxLength > 0 && r.Length > maxLength {
+		released += r.removeOldest()
+	}
+	budget := r.budget
+	r.mu.Unlock()
+
+	if budget != nil && released > 0 {
+		budget.adjust(-released)
+	}
+}
+
+// Len returns the number of requests in the list.
+func (r *RequestList) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Length
+}
+
+// Clear drops every request in the list.
+func (r *RequestList) Clear() {
+	r.mu.Lock()
+	released := r.bytes
+	r.Head = nil
+	r.Tail = nil
+	r.Length = 0
+	r.bytes = 0
+	budget := r.budget
+	r.mu.Unlock()
+
+	if budget != nil && released > 0 {
+		budget.adjust(-released)
+	}
+}
+
+// Bytes returns the approximate memory held by the list's captures.
+func (r *RequestList) Bytes() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bytes
+}
+
+// Find returns a copy of the request with the given ID, or nil if it is not in the list.
+func (r *RequestList) Find(id string) *CaptureRequestResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for node := r.Head; node != nil; node = node.Next {
+		if node.Request.ID == id {
+			crCopy := node.Request
+			return &crCopy
+		}
+	}
+	return nil
+}
+
+// All returns a copy of every request in the list, newest first.
+func (r *RequestList) All() []CaptureRequestResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	requests := make([]CaptureRequestResponse, 0, r.Length)
+	for node := r.Head; node != nil; node = node.Next {
+		requests = append(requests, node.Request)
+	}
+	return requests
+}
+
+// Update calls fn with the request with the given ID, reporting whether it was found.
+// The list's footprint is recalculated, as captures in progress grow over time.
+func (r *RequestList) Update(id string, fn func(*CaptureRequestResponse)) bool {
+	r.mu.Lock()
+
+	var delta int64
+	found := false
+	for node := r.Head; node != nil; node = node.Next {
+		if node.Request.ID == id {
+			before := node.Request.footprint()
+			fn(&node.Request)
+			delta = node.Request.footprint() - before
+			r.bytes += delta
+			found = true
+			break
+		}
+	}
+	budget := r.budget
+	r.mu.Unlock()
+
+	if budget != nil && delta != 0 {
+		budget.adjust(delta)
+	}
+	return found
 }
 
 type RequestResponse struct {
@@ -57,16 +215,20 @@ type RequestResponse struct {
 }
 
 type CaptureRequest struct {
-	Method  string
-	URL     string
-	Body    []byte
-	Headers map[string]string
+	Method        string
+	URL           string
+	Body          []byte // captured up to the funnel's MaxBodyBytes (--max-body-bytes)
+	BodySize      int64  // real size of the body as streamed to the target
+	BodyTruncated bool   // body was larger than what was captured
+	Headers       http.Header
 }
 
 type CaptureResponse struct {
-	StatusCode int
-	Body       []byte
-	Headers    map[string]string
+	StatusCode    int
+	Body          []byte // captured up to the funnel's MaxBodyBytes (--max-body-bytes)
+	BodySize      int64  // real size of the body as streamed to the client
+	BodyTruncated bool   // body was larger than what was captured
+	Headers       http.Header
 }
 
 type CaptureRequestResponse struct {
@@ -76,6 +238,53 @@ type CaptureRequestResponse struct {
 	Request   CaptureRequest
 	Response  CaptureResponse
 	Duration  time.Duration
+	// InProgress is set while a streamed response or upgraded connection is still
+	// open, the capture is updated in place until it completes
+	InProgress       bool
+	WebSocket        *WebSocketLog // frames of an upgraded websocket connection, nil for plain requests
+	ServerSentEvents *SSELog       // events of a text/event-stream response, nil for other responses
+	// Error is set when the target couldn't be reached, the response is the error
+	// returned to the public client rather than one from the target
+	Error     string
+	ErrorKind ProxyErrorKind
+	// ReplayOf is the ID of the capture this request was replayed or edited from,
+	// empty for requests that came in through the funnel
+	ReplayOf string
+	Composed bool // the request was written or edited by hand rather than replayed as it was
+}
+
+// Origin describes where a request that didn't come in through the funnel came
+// from: "replay", "edited" or "composed", empty for requests from the funnel.
+func (r *CaptureRequestResponse) Origin() string {
+	switch {
+	case r.Composed && r.ReplayOf != "":
+		return "edited"
+	case r.Composed:
+		return "composed"
+	case r.ReplayOf != "":
+		return "replay"
+	}
+	return ""
+}
+
+// footprint approximates the memory held by the capture, its bodies, headers and
+// any frames or events logged so far.
+func (r *CaptureRequestResponse) footprint() int64 {
+	size := int64(len(r.Request.URL) + len(r.Request.Body) + len(r.Response.Body) + len(r.Error))
+	for _, headers := range []http.Header{r.Request.Headers, r.Response.Headers} {
+		for name, values := range headers {
+			for _, value := range values {
+				size += int64(len(name) + len(value))
+			}
+		}
+	}
+	if r.WebSocket != nil {
+		size += r.WebSocket.size()
+	}
+	if r.ServerSentEvents != nil {
+		size += r.ServerSentEvents.size()
+	}
+	return size
 }
 
 func (r *CaptureRequestResponse) Method() string {
@@ -104,8 +313,15 @@ func (r *CaptureRequestResponse) StatusCode() int {
 }
 
 func (r *CaptureRequestResponse) Type() string {
+	if r.Error != "" {
+		return "✗ " + string(r.ErrorKind)
+	}
+	if r.WebSocket != nil {
+		return "ws"
+	}
+
 	// use the response content-type header to determine the type of the request
-	contentType := r.Response.Headers["Content-Type"]
+	contentType := r.Response.Headers.Get("Content-Type")
 	if contentType == "" {
 		return ""
 	}
@@ -129,6 +345,9 @@ func (r *CaptureRequestResponse) Type() string {
 	if strings.HasPrefix(contentType, "text/plain") {
 		return "txt"
 	}
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return "sse"
+	}
 	// split the content type by "/" and take the first part
 	parts := strings.Split(contentType, "/")
 	if len(parts) > 0 {
@@ -137,6 +356,23 @@ func (r *CaptureRequestResponse) Type() string {
 	return ""
 }
 
+// BodySizeLabel describes a captured body's size, noting when only part of it was captured.
+func BodySizeLabel(size int64, captured int, truncated bool) string {
+	label := util.FormatBytes(size)
+	if truncated {
+		label += fmt.Sprintf(" (truncated, first %s captured)", util.FormatBytes(int64(captured)))
+	}
+	return label
+}
+
+// DurationLabel is the rounded duration, or a note that the request is still open.
+func (r *CaptureRequestResponse) DurationLabel() string {
+	if r.InProgress {
+		return "live"
+	}
+	return r.RoundedDuration()
+}
+
 func (r *CaptureRequestResponse) RoundedDuration() string {
 	if r.Duration.Seconds() >= 1 {
 		// we want one decimal place
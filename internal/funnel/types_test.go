@@ -2,6 +2,7 @@ package funnel
 
 import (
 	"fmt"
+	"net/http"
 	"sync"
 	"testing"
 	"time"
@@ -241,6 +242,7 @@ func TestCaptureRequestResponse_Type(t *testing.T) {
 		{"CSS", "text/css", "css"},
 		{"JavaScript", "text/javascript", "js"},
 		{"Plain Text", "text/plain", "txt"},
+		{"Server-Sent Events", "text/event-stream", "sse"},
 		{"Image PNG", "image/png", "image"},
 		{"Video MP4", "video/mp4", "video"},
 		{"Application Octet Stream", "application/octet-stream", "application"},
@@ -251,9 +253,9 @@ func TestCaptureRequestResponse_Type(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			headers := make(map[string]string)
+			headers := make(http.Header)
 			if tc.contentType != "" {
-				headers["Content-Type"] = tc.contentType
+				headers.Set("Content-Type", tc.contentType)
 			}
 			crr := CaptureRequestResponse{
 				Response: CaptureResponse{Headers: headers},
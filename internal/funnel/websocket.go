@@ -0,0 +1,345 @@
+package funnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket opcodes, see RFC 6455 section 5.2.
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xA
+)
+
+// WebSocketDirection is the direction a frame travelled through the proxy.
+type WebSocketDirection int
+
+const (
+	WebSocketClientToServer WebSocketDirection = iota // sent by the public client to the local target
+	WebSocketServerToClient                           // sent by the local target to the public client
+)
+
+// Arrow returns a short symbol for the direction, relative to the local target.
+func (d WebSocketDirection) Arrow() string {
+	if d == WebSocketClientToServer {
+		return "→"
+	}
+	return "←"
+}
+
+func (d WebSocketDirection) String() string {
+	if d == WebSocketClientToServer {
+		return "client → server"
+	}
+	return "server → client"
+}
+
+// WebSocketFrame is a single frame seen on an upgraded connection.
+type WebSocketFrame struct {
+	Direction  WebSocketDirection
+	Opcode     byte
+	Final      bool
+	Compressed bool // RSV1 is set, the payload is compressed by permessage-deflate
+	Size       int64
+	Timestamp  time.Time
+	Payload    []byte // first util.WebSocketPreviewBytes bytes of the payload, unmasked
+}
+
+// Type returns a readable name for the frame's opcode.
+func (f WebSocketFrame) Type() string {
+	switch f.Opcode {
+	case wsOpContinuation:
+		return "cont"
+	case wsOpText:
+		return "text"
+	case wsOpBinary:
+		return "binary"
+	case wsOpClose:
+		return "close"
+	case wsOpPing:
+		return "ping"
+	case wsOpPong:
+		return "pong"
+	}
+	return fmt.Sprintf("op%#x", f.Opcode)
+}
+
+// Truncated reports whether only part of the payload was captured.
+func (f WebSocketFrame) Truncated() bool {
+	return int64(len(f.Payload)) < f.Size
+}
+
+// Preview renders the captured payload on a single line of at most maxLen characters.
+// Text is shown as is, close frames as their status code and reason, and anything
+// else as hex.
+func (f WebSocketFrame) Preview(maxLen int) string {
+	payload := f.Payload
+	if f.Compressed {
+		return "(compressed)"
+	}
+
+	var preview string
+	truncated := f.Truncated()
+	switch {
+	case f.Opcode == wsOpClose && len(payload) >= 2:
+		preview = fmt.Sprintf("%d %s", binary.BigEndian.Uint16(payload), strings.ToValidUTF8(string(payload[2:]), "?"))
+	case f.Opcode == wsOpText || (f.Opcode == wsOpContinuation && utf8.Valid(payload)):
+		preview = strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f {
+				return ' '
+			}
+			return r
+		}, strings.ToValidUTF8(string(payload), "?"))
+	default:
+		preview = fmt.Sprintf("% x", payload)
+	}
+
+	if maxLen > 0 && utf8.RuneCountInString(preview) > maxLen {
+		preview = string([]rune(preview)[:maxLen])
+		truncated = true
+	}
+	if truncated {
+		preview += "…"
+	}
+	return preview
+}
+
+// WebSocketLog is the frame log of a single upgraded connection.  It is shared by
+// every copy of the CaptureRequestResponse, and is appended to while the
+// connection is open.
+type WebSocketLog struct {
+	mu        sync.Mutex
+	frames    []WebSocketFrame
+	count     int // total frames seen, including those no longer kept
+	maxFrames int // most recent frames to keep, <= 0 keeps all
+	bytes     int64
+	closed    bool
+}
+
+func newWebSocketLog(maxFrames int) *WebSocketLog {
+	return &WebSocketLog{maxFrames: maxFrames}
+}
+
+func (l *WebSocketLog) add(frame WebSocketFrame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count++
+	if l.maxFrames > 0 && len(l.frames) == l.maxFrames {
+		// drop the oldest frame
+		l.bytes -= int64(len(l.frames[0].Payload))
+		copy(l.frames, l.frames[1:])
+		l.frames = l.frames[:len(l.frames)-1]
+	}
+	l.frames = append(l.frames, frame)
+	l.bytes += int64(len(frame.Payload))
+}
+
+// size returns the bytes of payload kept by the log.
+func (l *WebSocketLog) size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bytes
+}
+
+func (l *WebSocketLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+}
+
+// Frames returns a copy of the kept frames, oldest first.
+func (l *WebSocketLog) Frames() []WebSocketFrame {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]WebSocketFrame(nil), l.frames...)
+}
+
+// Count returns the number of frames seen on the connection.
+func (l *WebSocketLog) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Closed reports whether the connection has been closed.
+func (l *WebSocketLog) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// isWebSocketUpgrade reports whether the headers negotiate the websocket protocol.
+func isWebSocketUpgrade(header http.Header) bool {
+	return strings.EqualFold(header.Get("Upgrade"), "websocket")
+}
+
+// wsFrameParser decodes frames from one direction of a websocket connection.  It
+// is fed the raw bytes as they are copied, so frames may arrive split across any
+// number of writes.
+type wsFrameParser struct {
+	direction    WebSocketDirection
+	previewLimit int
+	onFrame      func(WebSocketFrame)
+
+	header    [14]byte // largest possible frame header
+	headerLen int
+
+	inPayload bool
+	frame     WebSocketFrame
+	masked    bool
+	maskKey   [4]byte
+	offset    int64 // payload bytes seen so far
+}
+
+// headerSize returns the size of the frame header being read, as far as it is known.
+func (p *wsFrameParser) headerSize() int {
+	if p.headerLen < 2 {
+		return 2
+	}
+	size := 2
+	switch p.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if p.header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size
+}
+
+func (p *wsFrameParser) startFrame() {
+	h := p.header[:p.headerLen]
+	p.frame = WebSocketFrame{
+		Direction:  p.direction,
+		Opcode:     h[0] & 0x0f,
+		Final:      h[0]&0x80 != 0,
+		Compressed: h[0]&0x40 != 0,
+		Timestamp:  time.Now(),
+	}
+	p.masked = h[1]&0x80 != 0
+
+	pos := 2
+	switch length := h[1] & 0x7f; length {
+	case 126:
+		p.frame.Size = int64(binary.BigEndian.Uint16(h[pos:]))
+		pos += 2
+	case 127:
+		p.frame.Size = int64(binary.BigEndian.Uint64(h[pos:]) & (1<<63 - 1))
+		pos += 8
+	default:
+		p.frame.Size = int64(length)
+	}
+	if p.masked {
+		copy(p.maskKey[:], h[pos:pos+4])
+	}
+
+	p.headerLen = 0
+	p.offset = 0
+	p.inPayload = true
+	if p.frame.Size == 0 {
+		p.finishFrame()
+	}
+}
+
+func (p *wsFrameParser) finishFrame() {
+	p.inPayload = false
+	if p.onFrame != nil {
+		p.onFrame(p.frame)
+	}
+}
+
+// Write never fails, so the parser can't interfere with the connection.
+func (p *wsFrameParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if !p.inPayload {
+			need := p.headerSize() - p.headerLen
+			copied := copy(p.header[p.headerLen:p.headerLen+min(need, len(b))], b)
+			p.headerLen += copied
+			b = b[copied:]
+			// the size is only known once the first two bytes are in
+			if p.headerLen >= 2 && p.headerLen == p.headerSize() {
+				p.startFrame()
+			}
+			continue
+		}
+
+		chunk := b[:min(int64(len(b)), p.frame.Size-p.offset)]
+		if keep := p.previewLimit - len(p.frame.Payload); keep > 0 {
+			kept := chunk[:min(keep, len(chunk))]
+			start := len(p.frame.Payload)
+			p.frame.Payload = append(p.frame.Payload, kept...)
+			if p.masked {
+				for i := range kept {
+					p.frame.Payload[start+i] ^= p.maskKey[(p.offset+int64(i))%4]
+				}
+			}
+		}
+		p.offset += int64(len(chunk))
+		b = b[len(chunk):]
+		if p.offset == p.frame.Size {
+			p.finishFrame()
+		}
+	}
+	return n, nil
+}
+
+// webSocketTap wraps the target side of an upgraded connection, recording the
+// frames read from the target and written to it by the client.
+type webSocketTap struct {
+	io.ReadWriteCloser
+	fromServer *wsFrameParser
+	toServer   *wsFrameParser
+	log        *WebSocketLog
+}
+
+// newWebSocketTap returns conn wrapped so its frames are added to log, calling
+// onFrame after each one.
+func newWebSocketTap(conn io.ReadWriteCloser, log *WebSocketLog, previewLimit int, onFrame func()) *webSocketTap {
+	record := func(frame WebSocketFrame) {
+		log.add(frame)
+		if onFrame != nil {
+			onFrame()
+		}
+	}
+	return &webSocketTap{
+		ReadWriteCloser: conn,
+		fromServer:      &wsFrameParser{direction: WebSocketServerToClient, previewLimit: previewLimit, onFrame: record},
+		toServer:        &wsFrameParser{direction: WebSocketClientToServer, previewLimit: previewLimit, onFrame: record},
+		log:             log,
+	}
+}
+
+func (t *webSocketTap) Read(b []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(b)
+	if n > 0 {
+		_, _ = t.fromServer.Write(b[:n])
+	}
+	return n, err
+}
+
+func (t *webSocketTap) Write(b []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(b)
+	if n > 0 {
+		_, _ = t.toServer.Write(b[:n])
+	}
+	return n, err
+}
+
+func (t *webSocketTap) Close() error {
+	t.log.close()
+	return t.ReadWriteCloser.Close()
+}
@@ -0,0 +1,219 @@
+package funnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsFrame encodes a single unfragmented frame, masking it with key when non-nil.
+func wsFrame(opcode byte, payload []byte, key []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0x80 | opcode)
+
+	maskBit := byte(0)
+	if key != nil {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		b.WriteByte(maskBit | byte(len(payload)))
+	case len(payload) <= 0xffff:
+		b.WriteByte(maskBit | 126)
+		_ = binary.Write(&b, binary.BigEndian, uint16(len(payload)))
+	default:
+		b.WriteByte(maskBit | 127)
+		_ = binary.Write(&b, binary.BigEndian, uint64(len(payload)))
+	}
+
+	if key == nil {
+		b.Write(payload)
+		return b.Bytes()
+	}
+	b.Write(key)
+	for i, c := range payload {
+		b.WriteByte(c ^ key[i%4])
+	}
+	return b.Bytes()
+}
+
+func TestWsFrameParser(t *testing.T) {
+	key := []byte{1, 2, 3, 4}
+	large := bytes.Repeat([]byte("x"), 300)
+
+	stream := bytes.Join([][]byte{
+		wsFrame(wsOpText, []byte("hello"), key),
+		wsFrame(wsOpBinary, large, nil),
+		wsFrame(wsOpPing, nil, key),
+		wsFrame(wsOpClose, []byte{0x03, 0xe8, 'b', 'y', 'e'}, nil),
+	}, nil)
+
+	// feed the stream in every chunk size, frames must not depend on how writes are split
+	for _, chunkSize := range []int{1, 2, 3, 7, 64, len(stream)} {
+		var frames []WebSocketFrame
+		p := &wsFrameParser{previewLimit: 16, onFrame: func(f WebSocketFrame) { frames = append(frames, f) }}
+
+		for rest := stream; len(rest) > 0; {
+			n := min(chunkSize, len(rest))
+			if written, err := p.Write(rest[:n]); written != n || err != nil {
+				t.Fatalf("Write() = %d, %v; want %d, nil", written, err, n)
+			}
+			rest = rest[n:]
+		}
+
+		if len(frames) != 4 {
+			t.Fatalf("chunk size %d: got %d frames, want 4", chunkSize, len(frames))
+		}
+		if frames[0].Type() != "text" || string(frames[0].Payload) != "hello" || frames[0].Size != 5 {
+			t.Errorf("chunk size %d: text frame = %s %q (%d bytes)", chunkSize, frames[0].Type(), frames[0].Payload, frames[0].Size)
+		}
+		if frames[1].Type() != "binary" || frames[1].Size != 300 || len(frames[1].Payload) != 16 || !frames[1].Truncated() {
+			t.Errorf("chunk size %d: binary frame = %s, %d bytes, %d captured", chunkSize, frames[1].Type(), frames[1].Size, len(frames[1].Payload))
+		}
+		if frames[2].Type() != "ping" || frames[2].Size != 0 {
+			t.Errorf("chunk size %d: ping frame = %s, %d bytes", chunkSize, frames[2].Type(), frames[2].Size)
+		}
+		if got := frames[3].Preview(0); got != "1000 bye" {
+			t.Errorf("chunk size %d: close frame preview = %q, want %q", chunkSize, got, "1000 bye")
+		}
+	}
+}
+
+func TestWebSocketFrame_Preview(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame WebSocketFrame
+		max   int
+		want  string
+	}{
+		{name: "Text", frame: WebSocketFrame{Opcode: wsOpText, Payload: []byte("hi\nthere"), Size: 8}, want: "hi there"},
+		{name: "Text cut to max", frame: WebSocketFrame{Opcode: wsOpText, Payload: []byte("abcdef"), Size: 6}, max: 3, want: "abc…"},
+		{name: "Truncated capture", frame: WebSocketFrame{Opcode: wsOpText, Payload: []byte("abc"), Size: 10}, want: "abc…"},
+		{name: "Binary", frame: WebSocketFrame{Opcode: wsOpBinary, Payload: []byte{0xde, 0xad}, Size: 2}, want: "de ad"},
+		{name: "Compressed", frame: WebSocketFrame{Opcode: wsOpText, Compressed: true, Payload: []byte{0x01}, Size: 1}, want: "(compressed)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.frame.Preview(tt.max); got != tt.want {
+				t.Errorf("Preview(%d) = %q, want %q", tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleRequest_WebSocket(t *testing.T) {
+	// a minimal websocket echo server, replying to each frame with an unmasked text frame
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r.Header) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() unexpected error: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+
+		var received []WebSocketFrame
+		p := &wsFrameParser{previewLimit: 1024, onFrame: func(f WebSocketFrame) { received = append(received, f) }}
+		buf := make([]byte, 1024)
+		for len(received) == 0 {
+			n, err := rw.Read(buf)
+			if err != nil {
+				return
+			}
+			_, _ = p.Write(buf[:n])
+		}
+		_, _ = conn.Write(wsFrame(wsOpText, append([]byte("echo:"), received[0].Payload...), nil))
+		_, _ = rw.Read(buf) // wait for the client to hang up
+	}))
+	defer backend.Close()
+
+	s, f := newTestProxy(t, backend.URL, nil)
+	proxy := httptest.NewServer(http.HandlerFunc(s.handleRequest))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+HttpServerPath+"test-funnel/socket", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+
+	// the handshake is recorded while the connection is still open
+	captured := f.Requests.Find(f.Requests.Head.Request.ID)
+	if captured == nil || captured.WebSocket == nil {
+		t.Fatalf("Expected the handshake to be captured with a websocket log")
+	}
+
+	if _, err := conn.Write(wsFrame(wsOpText, []byte("ping"), []byte{9, 8, 7, 6})); err != nil {
+		t.Fatal(err)
+	}
+
+	var echoed []WebSocketFrame
+	p := &wsFrameParser{previewLimit: 1024, onFrame: func(f WebSocketFrame) { echoed = append(echoed, f) }}
+	buf := make([]byte, 1024)
+	for len(echoed) == 0 {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() unexpected error: %v", err)
+		}
+		_, _ = p.Write(buf[:n])
+	}
+	if got := string(echoed[0].Payload); got != "echo:ping" {
+		t.Errorf("Client received %q, want %q", got, "echo:ping")
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !captured.WebSocket.Closed() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !captured.WebSocket.Closed() {
+		t.Errorf("Expected the websocket log to be closed after the client hung up")
+	}
+
+	frames := captured.WebSocket.Frames()
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames in the log, got %d", len(frames))
+	}
+	if frames[0].Direction != WebSocketClientToServer || string(frames[0].Payload) != "ping" {
+		t.Errorf("First frame = %v %q, want client → server %q", frames[0].Direction, frames[0].Payload, "ping")
+	}
+	if frames[1].Direction != WebSocketServerToClient || string(frames[1].Payload) != "echo:ping" {
+		t.Errorf("Second frame = %v %q, want server → client %q", frames[1].Direction, frames[1].Payload, "echo:ping")
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleFunnelInspect(rec, httptest.NewRequest(http.MethodGet, "/inspect/test-funnel/request/"+captured.ID+"/frames", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "echo:ping") {
+		t.Errorf("Frames fragment = %d %q, want the echoed frame", rec.Code, rec.Body.String())
+	}
+}
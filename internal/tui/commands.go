@@ -1,23 +1,40 @@
 package tui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	stdlog "log"
+	"os"
+	"time"
 
 	"github.com/atotto/clipboard"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/jonson/tsgrok/internal/funnel"
+	"github.com/jonson/tsgrok/internal/util"
 )
 
 // createFunnelCmd calls the backend function to create a funnel
 // and returns a message indicating success or failure.
-func createFunnelCmd(name, target string, logger *stdlog.Logger) tea.Cmd {
+func createFunnelCmd(opts funnel.EphemeralFunnelOptions, logger *stdlog.Logger) tea.Cmd {
 	return func() tea.Msg {
-		funnel, err := funnel.CreateEphemeralFunnel(name, target, logger)
+		funnel, err := funnel.CreateEphemeralFunnel(context.Background(), opts, logger)
 		if err != nil {
-			return funnelCreateErrMsg{err}
+			return funnelCreateErrMsg{err: err}
 		}
-		return funnelCreatedMsg{funnel}
+		return funnelCreatedMsg{funnel: funnel}
+	}
+}
+
+// createStartupFunnelCmd creates a funnel that was requested at launch (e.g. from
+// the config file).  Its messages are flagged so they don't disturb the create view.
+func createStartupFunnelCmd(opts funnel.EphemeralFunnelOptions, logger *stdlog.Logger) tea.Cmd {
+	return func() tea.Msg {
+		f, err := funnel.CreateEphemeralFunnel(context.Background(), opts, logger)
+		if err != nil {
+			return funnelCreateErrMsg{err: fmt.Errorf("%s: %w", opts.Name, err), startup: true}
+		}
+		return funnelCreatedMsg{funnel: f, startup: true}
 	}
 }
 
@@ -45,8 +62,93 @@ func deleteFunnelCmd(id string, registry *funnel.FunnelRegistry) tea.Cmd {
 	}
 }
 
-// copyToClipboardCmd writes the given text to the system clipboard.
-func copyToClipboardCmd(text string) tea.Cmd {
+// setInspectCmd switches a funnel between inspected and pass-through mode.
+func setInspectCmd(id string, inspect bool, registry *funnel.FunnelRegistry) tea.Cmd {
+	return func() tea.Msg {
+		f, err := registry.GetFunnel(id)
+		if err != nil {
+			return funnelInspectErrMsg{id: id, err: err}
+		}
+		if err := f.SetInspect(inspect); err != nil {
+			return funnelInspectErrMsg{id: id, err: err}
+		}
+		registry.FunnelUpdated(id)
+		return funnelInspectChangedMsg{id: id, inspect: inspect}
+	}
+}
+
+// exportHARCmd writes a funnel's captured requests that match the filter to a HAR
+// file in the working directory.
+func exportHARCmd(id string, registry *funnel.FunnelRegistry, filter funnel.Filter) tea.Cmd {
+	return func() tea.Msg {
+		f, err := registry.GetFunnel(id)
+		if err != nil {
+			return harExportErrMsg{err: err}
+		}
+		captures := filter.Apply(f.Requests.All())
+		path := funnel.HARFileName(f, time.Now())
+
+		file, err := os.Create(path)
+		if err != nil {
+			return harExportErrMsg{err: err}
+		}
+		if err := funnel.NewHAR(f, captures).Write(file); err != nil {
+			file.Close()
+			return harExportErrMsg{err: err}
+		}
+		if err := file.Close(); err != nil {
+			return harExportErrMsg{err: err}
+		}
+		return harExportedMsg{path: path, count: len(captures)}
+	}
+}
+
+// replayRequestCmd sends a captured request to its funnel's local target again.
+func replayRequestCmd(server *funnel.HttpServer, funnelID string, requestID string) tea.Cmd {
+	return func() tea.Msg {
+		if server == nil {
+			return requestReplayErrMsg{err: errors.New("no proxy server is running")}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), util.ReplayTimeout)
+		defer cancel()
+		replayed, err := server.Replay(ctx, funnelID, requestID)
+		if err != nil {
+			return requestReplayErrMsg{err: err}
+		}
+		return requestReplayedMsg{request: replayed}
+	}
+}
+
+// sendComposedCmd sends a request written in the compose view to the funnel's local
+// target.  basedOn is the ID of the capture it was edited from, if any.
+func sendComposedCmd(server *funnel.HttpServer, funnelID string, request funnel.ComposedRequest, basedOn string) tea.Cmd {
+	return func() tea.Msg {
+		if server == nil {
+			return composeErrMsg{err: errors.New("no proxy server is running")}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), util.ReplayTimeout)
+		defer cancel()
+		sent, err := server.Send(ctx, funnelID, request, basedOn)
+		if err != nil {
+			return composeErrMsg{err: err}
+		}
+		return requestComposedMsg{request: sent}
+	}
+}
+
+// copySnippetCmd copies the request to the clipboard in the given format, e.g. as
+// a curl command.
+func copySnippetCmd(format funnel.SnippetFormat, request funnel.CaptureRequestResponse) tea.Cmd {
+	snippet, err := funnel.Snippet(format, request)
+	if err != nil {
+		return func() tea.Msg { return clipboardWriteErrorMsg{err: err} }
+	}
+	return copyToClipboardCmd(snippet, format.Label()+" request")
+}
+
+// copyToClipboardCmd writes the given text to the system clipboard, what names it
+// in the status message, e.g. "URL".
+func copyToClipboardCmd(text string, what string) tea.Cmd {
 	return func() tea.Msg {
 		err := clipboard.WriteAll(text)
 		if err != nil {
@@ -54,7 +156,7 @@ func copyToClipboardCmd(text string) tea.Cmd {
 			return clipboardWriteErrorMsg{err: err}
 		} else {
 			// Optionally return a success message
-			return clipboardWriteSuccessMsg{}
+			return clipboardWriteSuccessMsg{what: what}
 		}
 	}
 }
@@ -7,11 +7,13 @@ import (
 )
 
 type funnelCreatedMsg struct {
-	funnel funnel.Funnel
+	funnel  funnel.Funnel
+	startup bool // created at launch rather than from the create view
 }
 
 type funnelCreateErrMsg struct {
-	err error
+	err     error
+	startup bool // created at launch rather than from the create view
 }
 
 // Ensure funnelCreateErrMsg implements the error interface
@@ -33,7 +35,58 @@ func (e funnelDeleteErrMsg) Error() string {
 	return fmt.Sprintf("failed to delete funnel %s: %v", e.id, e.err)
 }
 
-type clipboardWriteSuccessMsg struct{}
+type funnelInspectChangedMsg struct {
+	id      string // ID of the funnel that was toggled
+	inspect bool   // new inspection state
+}
+
+type funnelInspectErrMsg struct {
+	id  string // ID of the funnel that failed to toggle
+	err error
+}
+
+// Ensure funnelInspectErrMsg implements the error interface
+func (e funnelInspectErrMsg) Error() string {
+	return fmt.Sprintf("failed to toggle inspection: %v", e.err)
+}
+
+type harExportedMsg struct {
+	path  string // file the requests were written to
+	count int    // number of requests exported
+}
+
+type harExportErrMsg struct{ err error }
+
+// Ensure harExportErrMsg implements the error interface
+func (e harExportErrMsg) Error() string {
+	return fmt.Sprintf("HAR export failed: %v", e.err)
+}
+
+type requestReplayedMsg struct {
+	request *funnel.CaptureRequestResponse // the new capture, its ReplayOf is the original
+}
+
+type requestReplayErrMsg struct{ err error }
+
+// Ensure requestReplayErrMsg implements the error interface
+func (e requestReplayErrMsg) Error() string {
+	return fmt.Sprintf("replay failed: %v", e.err)
+}
+
+type requestComposedMsg struct {
+	request *funnel.CaptureRequestResponse // capture of the request sent from the compose view
+}
+
+type composeErrMsg struct{ err error }
+
+// Ensure composeErrMsg implements the error interface
+func (e composeErrMsg) Error() string {
+	return fmt.Sprintf("sending request failed: %v", e.err)
+}
+
+type clipboardWriteSuccessMsg struct {
+	what string // what was copied, e.g. "URL"
+}
 type clipboardWriteErrorMsg struct{ err error }
 type clearStatusMsg struct{}
 
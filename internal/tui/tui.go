@@ -3,6 +3,7 @@ package tui
 import (
 	"fmt"
 	stdlog "log"
+	"net/http"
 	"os"
 	"sort"
 	"strconv"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -101,13 +103,19 @@ List View:
   n          : New Funnel
   d          : Delete Selected Funnel
   c          : Copy Public URL of Selected Funnel
+  i          : Toggle Inspection (capture) / Pass-through
+  h          : Change How Many Requests the Funnel Keeps
   enter      : View Funnel Details
 
 Create View:
-  tab        : Switch Input Fields
+  tab        : Switch Input Fields (name, target, remote port)
   enter      : Create Funnel
   esc        : Cancel Creation
 
+History View:
+  enter      : Save
+  esc        : Cancel
+
 Confirm Delete View:
   y          : Confirm Deletion
   n / esc    : Cancel Deletion
@@ -117,10 +125,33 @@ Detail View:
   shift+tab / ← / h: Previous Tab
   c          : Copy Public URL (Info Tab)
   enter      : View Request Details (Request Log Tab)
+  /          : Filter Requests, e.g. status:5xx method:POST path:/webhooks/* (Request Log Tab)
+  e          : Export Requests as HAR, only the matching ones when filtered (Request Log Tab)
+  r          : Replay Request to the Local Target (Request Log Tab)
+  m          : Edit Request and Resend (Request Log Tab)
+  n          : Compose a New Request (Request Log Tab)
   esc    : Back to List View
 
+Request Filter:
+  enter      : Keep Filter and Return to the Request Log
+  esc        : Clear Filter
+
 Request Detail View:
+  tab    : Switch Between the Request and Response Body
+  v      : Toggle Between the Formatted and Raw Body
+  ↑/↓ / pgup/pgdn: Scroll the Body
+  r      : Replay Request to the Local Target
+  m      : Edit Request and Resend
+  c      : Copy Request as a curl Command
+  h      : Copy Request as an HTTPie Command
+  w      : Copy Request as Raw HTTP/1.1
+  g      : Copy Request as Go Code
   esc    : Back to Request Log
+
+Compose View:
+  tab / shift+tab: Switch Fields (method, path, headers, body)
+  ctrl+s     : Send Request
+  esc        : Cancel
 `
 
 // viewState indicates which view is currently active
@@ -133,8 +164,18 @@ const (
 	viewDetail                         // View showing details for a selected funnel
 	viewHelp                           // View displaying keybindings/help
 	viewRequestDetail                  // View showing details of a specific proxied request
+	viewHistory                        // View for changing how many requests a funnel keeps
+	viewCompose                        // View for editing a request before sending it to the local target
 )
 
+// Options configures the TUI.
+type Options struct {
+	StartupFunnels []funnel.EphemeralFunnelOptions // created at launch, e.g. from the config file
+	MaxRequests    int                             // captured requests kept by funnels created from the TUI
+	ReadOnly       bool                            // only browse the funnels already in the registry, e.g. imported HAR files
+	Server         *funnel.HttpServer              // proxies replayed requests, replay is unavailable when nil
+}
+
 // --- Model ---
 
 type model struct {
@@ -146,10 +187,36 @@ type model struct {
 	// State for viewCreate
 	funnelNameInput   textinput.Model
 	funnelTargetInput textinput.Model
+	funnelPortInput   textinput.Model
 	inputFocusIndex   int
 	createErrMsg      string // To store creation errors
 	isCreating        bool   // Flag to indicate creation is in progress
 
+	// Funnels requested at launch (e.g. from the config file)
+	startupFunnels []funnel.EphemeralFunnelOptions
+	pendingFunnels int  // Startup funnels still being created
+	maxRequests    int  // History length for funnels created from the TUI
+	readOnly       bool // Funnels can't be created, e.g. when viewing HAR files
+	server         *funnel.HttpServer
+
+	// State for viewHistory
+	historyInput    textinput.Model
+	historyFunnelID string // ID of the funnel whose history length is being changed
+	historyErrMsg   string
+
+	// State for viewCompose
+	composeMethodInput textinput.Model
+	composePathInput   textinput.Model
+	composeHeadersArea textarea.Model
+	composeBodyArea    textarea.Model
+	composeFocusIndex  int
+	composeFunnelID    string    // ID of the funnel the request is sent through
+	composeBasedOn     string    // ID of the capture being edited, empty for a new request
+	composeNotice      string    // e.g. only part of the original body was captured
+	composeErrMsg      string    // why the request couldn't be sent
+	isSending          bool      // Flag to indicate the request is being sent
+	composeReturnState viewState // view to go back to when cancelled
+
 	// State for viewConfirmDelete
 	deletingFunnelID string // ID of the funnel being confirmed for deletion
 	spinner          spinner.Model
@@ -158,6 +225,13 @@ type model struct {
 	detailedFunnelID string // ID of the funnel being viewed
 	detailTabIndex   int    // 0 for Info, 1 for Requests
 
+	// Request log filter, applied as it is typed
+	filterInput   textinput.Model
+	requestFilter funnel.Filter // last expression that parsed
+	filterErrMsg  string        // why the expression being typed doesn't parse
+	matchedCount  int           // requests shown in the request log
+	totalCount    int           // requests captured by the funnel
+
 	// Status message state
 	statusMessage string // Message to display temporarily
 	tickerActive  bool   // Flag to track if the status clear timer is running
@@ -175,10 +249,15 @@ type model struct {
 	requestTable    table.Model
 	selectedRequest *funnel.CaptureRequestResponse // The request being inspected in viewRequestDetail
 
+	// State for viewRequestDetail
+	bodyViewport viewport.Model
+	bodyTabIndex int  // 0 for the request body, 1 for the response body
+	bodyRaw      bool // show bodies as captured rather than pretty printed
+
 	logger *stdlog.Logger
 }
 
-func InitialModel(funnelRegistry *funnel.FunnelRegistry, logger *stdlog.Logger) model {
+func InitialModel(funnelRegistry *funnel.FunnelRegistry, logger *stdlog.Logger, opts Options) model {
 	nameInput := textinput.New()
 	nameInput.Placeholder = "my-funnel-name"
 	nameInput.Focus()
@@ -190,30 +269,85 @@ func InitialModel(funnelRegistry *funnel.FunnelRegistry, logger *stdlog.Logger)
 	targetInput.CharLimit = 256
 	targetInput.Width = 30
 
+	portInput := textinput.New()
+	portInput.Placeholder = "443"
+	portInput.CharLimit = 5
+	portInput.Width = 30
+
+	historyInput := textinput.New()
+	historyInput.CharLimit = 7
+	historyInput.Width = 30
+
+	filterInput := textinput.New()
+	filterInput.Prompt = "/ "
+	filterInput.Placeholder = `status:5xx method:POST path:/webhooks/* body:"invoice"`
+	filterInput.CharLimit = 512
+
+	composeMethodInput := textinput.New()
+	composeMethodInput.Placeholder = "GET"
+	composeMethodInput.CharLimit = 16
+	composeMethodInput.Width = 10
+
+	composePathInput := textinput.New()
+	composePathInput.Placeholder = "/path?query"
+	composePathInput.CharLimit = 2048
+
+	composeHeadersArea := textarea.New()
+	composeHeadersArea.Placeholder = "Content-Type: application/json"
+	composeHeadersArea.ShowLineNumbers = false
+	composeHeadersArea.CharLimit = 0 // no limits, headers and bodies are pasted in whole
+	composeHeadersArea.MaxHeight = 0
+	composeHeadersArea.SetHeight(6)
+
+	composeBodyArea := textarea.New()
+	composeBodyArea.ShowLineNumbers = false
+	composeBodyArea.CharLimit = 0
+	composeBodyArea.MaxHeight = 0
+	composeBodyArea.SetHeight(10)
+
 	// Initialize spinner
 	sp := spinner.New()
 	sp.Style = lipgloss.NewStyle().Foreground(greenColor)
 	sp.Spinner = spinner.Dot
 
+	bodyViewport := viewport.New(1, 1)
+
 	viewport := viewport.New(1, 1)
 	viewport.SetContent(helpContent)
 
-	return model{
-		width:             0,        // Placeholder, actual width will be set later
-		height:            0,        // Placeholder, actual height will be set later
-		state:             viewList, // Start in list view
-		funnelNameInput:   nameInput,
-		funnelTargetInput: targetInput,
-		inputFocusIndex:   0, // Focus name input first
-		funnelRegistry:    funnelRegistry,
-		table:             createInitialTable(), // Call helper to create the table
-		requestTable:      createRequestTable(), // Call helper to create the table
-		funnelOrder:       []string{},           // Initialize empty order slice
-		spinner:           sp,                   // Add initialized spinner
-		isCreating:        false,                // Initialize isCreating flag
-		viewport:          viewport,
-		logger:            logger,
+	m := model{
+		width:              0,        // Placeholder, actual width will be set later
+		height:             0,        // Placeholder, actual height will be set later
+		state:              viewList, // Start in list view
+		funnelNameInput:    nameInput,
+		funnelTargetInput:  targetInput,
+		funnelPortInput:    portInput,
+		inputFocusIndex:    0, // Focus name input first
+		funnelRegistry:     funnelRegistry,
+		table:              createInitialTable(), // Call helper to create the table
+		requestTable:       createRequestTable(), // Call helper to create the table
+		funnelOrder:        []string{},           // Initialize empty order slice
+		spinner:            sp,                   // Add initialized spinner
+		isCreating:         false,                // Initialize isCreating flag
+		viewport:           viewport,
+		startupFunnels:     opts.StartupFunnels,
+		pendingFunnels:     len(opts.StartupFunnels),
+		maxRequests:        opts.MaxRequests,
+		readOnly:           opts.ReadOnly,
+		server:             opts.Server,
+		historyInput:       historyInput,
+		filterInput:        filterInput,
+		bodyViewport:       bodyViewport,
+		bodyTabIndex:       1, // the response is usually what's interesting
+		composeMethodInput: composeMethodInput,
+		composePathInput:   composePathInput,
+		composeHeadersArea: composeHeadersArea,
+		composeBodyArea:    composeBodyArea,
+		logger:             logger,
 	}
+	// the registry may already hold funnels, e.g. archived captures from the store
+	m.refreshFunnelTable()
+	return m
 }
 
 // Helper function to create the initial table model
@@ -221,6 +355,7 @@ func createInitialTable() table.Model {
 	columns := []table.Column{
 		{Title: "Name"},         // Removed fixed width
 		{Title: "Local Target"}, // Removed fixed width
+		{Title: "Inspect"},
 	}
 
 	t := table.New(
@@ -263,7 +398,171 @@ func createRequestTable() table.Model {
 }
 
 func (m model) Init() tea.Cmd {
-	return textinput.Blink // Start the cursor blinking
+	cmds := []tea.Cmd{textinput.Blink} // Start the cursor blinking
+	for _, opts := range m.startupFunnels {
+		cmds = append(cmds, createStartupFunnelCmd(opts, m.logger))
+	}
+	return tea.Batch(cmds...)
+}
+
+// refreshFunnelTable rebuilds the funnel table rows and the funnelOrder slice from the registry.
+func (m *model) refreshFunnelTable() {
+	funnels := m.funnelRegistry.Funnels()
+	// sort by name so rows don't jump around
+	sort.SliceStable(funnels, func(i, j int) bool {
+		return funnels[i].Name() < funnels[j].Name()
+	})
+
+	ids := make([]string, 0, len(funnels))
+	rows := make([]table.Row, 0, len(funnels))
+	for _, funnel := range funnels {
+		ids = append(ids, funnel.ID())
+		rows = append(rows, table.Row{
+			funnel.Name(),        // Column 1: Name
+			funnel.LocalTarget(), // Column 2: Local Target
+			inspectLabel(funnel), // Column 3: Inspect
+		})
+	}
+	m.funnelOrder = ids // Keep the order slice in sync with the rows
+	m.table.SetRows(rows)
+}
+
+// clampFunnelCursor keeps the funnel table's cursor on a row after rows are removed.
+func (m *model) clampFunnelCursor() {
+	rows := m.table.Rows()
+	if m.table.Cursor() >= len(rows) && len(rows) > 0 {
+		m.table.SetCursor(len(rows) - 1)
+	} else if len(rows) == 0 {
+		m.table.SetCursor(0)
+	}
+}
+
+// inspectLabel describes whether a funnel's traffic is captured.
+func inspectLabel(f funnel.Funnel) string {
+	if f.Archived {
+		return "archived"
+	}
+	if f.Inspect() {
+		return "on"
+	}
+	return "off"
+}
+
+// maxDetailFrames is how many of the most recent websocket frames or server-sent events the request detail shows.
+const maxDetailFrames = 20
+
+// formatWebSocketFrames renders the last limit frames, newest first, one per line of at most width characters.
+func formatWebSocketFrames(frames []funnel.WebSocketFrame, limit int, width int) string {
+	if len(frames) == 0 {
+		return "  (No frames yet)"
+	}
+
+	var builder strings.Builder
+	for i := len(frames) - 1; i >= 0 && i >= len(frames)-limit; i-- {
+		frame := frames[i]
+		line := fmt.Sprintf("  %s %s %-6s %9s  ",
+			frame.Timestamp.Format("15:04:05.000"),
+			frame.Direction.Arrow(),
+			frame.Type(),
+			util.FormatBytes(frame.Size),
+		)
+		builder.WriteString(line)
+		builder.WriteString(frame.Preview(max(width-lipgloss.Width(line), 10)))
+		builder.WriteString("\n")
+	}
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// formatSSEEvents renders the last limit events, newest first, one per line of at most width characters.
+func formatSSEEvents(events []funnel.SSEEvent, limit int, width int) string {
+	if len(events) == 0 {
+		return "  (No events yet)"
+	}
+
+	var builder strings.Builder
+	for i := len(events) - 1; i >= 0 && i >= len(events)-limit; i-- {
+		event := events[i]
+		line := fmt.Sprintf("  %s %-10s %9s  ",
+			event.Timestamp.Format("15:04:05.000"),
+			event.Event,
+			util.FormatBytes(event.Size),
+		)
+		data := strings.ReplaceAll(event.Data, "\n", " ")
+		if maxData := max(width-lipgloss.Width(line), 10); len([]rune(data)) > maxData {
+			data = string([]rune(data)[:maxData]) + "…"
+		} else if event.Truncated() {
+			data += "…"
+		}
+		builder.WriteString(line)
+		builder.WriteString(data)
+		builder.WriteString("\n")
+	}
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// bodyView is a request or response body as the request detail shows it.
+type bodyView struct {
+	body   funnel.FormattedBody
+	notes  []string // e.g. only part of the body was captured
+	raw    bool     // shown as captured, even though it could be formatted
+	loaded bool
+}
+
+func newBodyView(body []byte, headers http.Header, size int64, truncated bool, streaming bool, raw bool) bodyView {
+	view := bodyView{raw: raw, loaded: true}
+	if raw {
+		view.body = funnel.RawBody(body, headers)
+	} else {
+		view.body = funnel.FormatBody(body, headers)
+	}
+	if truncated {
+		view.notes = append(view.notes, "Body "+funnel.BodySizeLabel(size, len(body), true))
+	}
+	if e := view.body.DecodeError; e != "" {
+		view.notes = append(view.notes, strings.ToUpper(e[:1])+e[1:])
+	}
+	if streaming {
+		view.notes = append(view.notes, "Streaming, the body so far is shown.")
+	}
+	return view
+}
+
+// Describe says how the body is shown, e.g. "json, formatted, gzip decoded".
+func (b bodyView) Describe() string {
+	var description string
+	switch {
+	case !b.loaded:
+		return ""
+	case b.body.Format == funnel.BodyEmpty:
+		return "no body"
+	case b.body.Format == funnel.BodyImage:
+		description = b.body.MediaType + ", hex dump"
+	case b.body.Format == funnel.BodyBinary:
+		description = "binary, hex dump"
+	case b.body.Pretty:
+		description = string(b.body.Format) + ", formatted"
+	case b.raw:
+		description = "raw"
+	default:
+		description = string(b.body.Format)
+	}
+	if b.body.Encoding != "" {
+		description += ", " + b.body.Encoding + " decoded"
+	}
+	return description
+}
+
+// Content is the text shown in the body viewport.
+func (b bodyView) Content() string {
+	text := b.body.Text
+	if b.body.Format == funnel.BodyEmpty {
+		text = "(No body)"
+	}
+	if len(b.notes) == 0 {
+		return text
+	}
+	notes := lipgloss.NewStyle().Italic(true).Foreground(subtleGrey).Render(strings.Join(b.notes, "\n"))
+	return notes + "\n\n" + text
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -278,10 +577,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			// Prevent quitting if in create view AND an input is focused
-			if m.state == viewCreate && (m.funnelNameInput.Focused() || m.funnelTargetInput.Focused()) {
+			if m.state == viewCreate && (m.funnelNameInput.Focused() || m.funnelTargetInput.Focused() || m.funnelPortInput.Focused()) {
 				// Let the input handler process 'q'
 				break // Fall through to view-specific handlers
 			}
+			if (m.state == viewHistory || m.state == viewCompose || m.filterInput.Focused()) && msg.String() == "q" {
+				break // Let the focused input handle 'q'
+			}
 			// Prevent quitting globally if in request detail view (let view handler decide)
 			if m.state == viewRequestDetail && msg.String() == "q" {
 				break // Fall through to view-specific handlers (which will ignore 'q')
@@ -289,13 +591,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		case "?":
 			// Don't open help from help view or create view
-			if m.state != viewHelp && m.state != viewCreate {
+			if m.state != viewHelp && m.state != viewCreate && m.state != viewHistory && m.state != viewCompose && !m.filterInput.Focused() {
 				m.previousState = m.state
 				m.state = viewHelp
 				// Ensure focused elements are blurred when entering help
 				m.table.Blur()
 				m.funnelNameInput.Blur()
 				m.funnelTargetInput.Blur()
+				m.funnelPortInput.Blur()
 				// Potentially add blurring for other focusable elements if added later
 				return m, nil
 			}
@@ -304,70 +607,120 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Handle funnel creation results globally, regardless of view
 	case funnelCreatedMsg:
 		m.funnelRegistry.AddFunnel(msg.funnel)
-		// Rebuild the table rows AND the funnelOrder slice from the registry
-		rows := []table.Row{}
-		newOrder := []string{}
-		// Note: Iterating over map doesn't guarantee order, but table handles selection separately.
-		// We build the order slice *as we build the rows* to ensure they match.
-		for id, funnel := range m.funnelRegistry.Funnels {
-			newOrder = append(newOrder, id) // Add ID to the order list
-			rows = append(rows, table.Row{
-				funnel.Name(),        // Column 1: Name (assuming funnel.Name() exists)
-				funnel.LocalTarget(), // Column 2: Local Target
-			})
+		m.refreshFunnelTable()
+
+		if msg.startup {
+			// don't disturb whatever view the user is in
+			m.pendingFunnels--
+			return m, nil
 		}
-		m.funnelOrder = newOrder // Update the model's order slice
-		m.table.SetRows(rows)
 
 		m.state = viewList   // Switch back to list view on success
 		m.createErrMsg = ""  // Clear any previous error
 		m.isCreating = false // Ensure creating flag is reset
 		m.funnelNameInput.Blur()
 		m.funnelTargetInput.Blur()
+		m.funnelPortInput.Blur()
 		m.table.Focus()
 		return m, nil // No command needed from focusing
 
 	case funnelCreateErrMsg:
+		if msg.startup {
+			m.pendingFunnels--
+			m.statusMessage = "Error creating funnel " + msg.Error()
+			m.tickerActive = true
+			return m, tea.Tick(5*time.Second, func(t time.Time) tea.Msg {
+				return clearStatusMsg{}
+			})
+		}
+
 		m.createErrMsg = msg.Error() // Store the error message
 		m.isCreating = false         // Ensure creating flag is reset
 		// Stay in the create view so the user can see the error
 		// Ensure the correct input is focused if we were in create view
 		if m.state == viewCreate {
-			cmds := []tea.Cmd{}
-			if m.inputFocusIndex == 0 {
-				cmds = append(cmds, m.funnelNameInput.Focus())
-				m.funnelTargetInput.Blur()
-			} else {
-				m.funnelNameInput.Blur()
-				cmds = append(cmds, m.funnelTargetInput.Focus())
-			}
-			return m, tea.Batch(cmds...)
+			return m, m.focusCreateInput(m.inputFocusIndex)
 		}
 		return m, nil // No further command needed right now
 
 	case funnelDeletedMsg:
 		// Rebuild table rows and funnelOrder after successful deletion
-		rows := []table.Row{}
-		newOrder := []string{}
-		for id, funnel := range m.funnelRegistry.Funnels {
-			newOrder = append(newOrder, id)
-			rows = append(rows, table.Row{
-				funnel.Name(),
-				funnel.LocalTarget(),
-			})
-		}
-		m.funnelOrder = newOrder // Update the order slice
-		m.table.SetRows(rows)
-		// Ensure cursor is valid after deletion
-		if m.table.Cursor() >= len(rows) && len(rows) > 0 {
-			m.table.SetCursor(len(rows) - 1)
-		} else if len(rows) == 0 {
-			m.table.SetCursor(0)
-		}
+		m.refreshFunnelTable()
+		m.clampFunnelCursor()
 		// Refocus the table
 		m.table.Focus()
 		return m, nil // No command needed from focusing
 
+	case funnel.FunnelChangedMsg:
+		// funnels also come and go from the web inspector and the API
+		m.refreshFunnelTable()
+		m.clampFunnelCursor()
+		if msg.Change != funnel.FunnelRemoved {
+			return m, nil
+		}
+		var viewing string
+		switch m.state {
+		case viewDetail, viewRequestDetail:
+			viewing = m.detailedFunnelID
+		case viewConfirmDelete:
+			viewing = m.deletingFunnelID
+		case viewHistory:
+			viewing = m.historyFunnelID
+		case viewCompose:
+			viewing = m.composeFunnelID
+		}
+		if viewing != msg.FunnelId {
+			return m, nil
+		}
+		// the funnel being looked at is gone, there's nothing left to show
+		m.state = viewList
+		m.detailedFunnelID = ""
+		m.deletingFunnelID = ""
+		m.historyFunnelID = ""
+		m.composeFunnelID = ""
+		m.requestTable.Blur()
+		m.table.Focus()
+		m.statusMessage = "The funnel was deleted"
+		m.tickerActive = true
+		return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+			return clearStatusMsg{}
+		})
+
+	case funnel.ErrorMsg:
+		m.statusMessage = "Error: " + msg.Err.Error()
+		m.tickerActive = true
+		return m, tea.Tick(5*time.Second, func(t time.Time) tea.Msg { // Show errors longer
+			return clearStatusMsg{}
+		})
+
+	case util.EventsDropped:
+		// fell behind on events, rebuild whatever they would have updated
+		m.refreshFunnelTable()
+		m.clampFunnelCursor()
+		if m.state == viewDetail && m.detailTabIndex == 1 {
+			m.populateRequestTable()
+		}
+		return m, nil
+
+	case funnelInspectChangedMsg:
+		m.refreshFunnelTable()
+		state := "off, traffic passes straight through"
+		if msg.inspect {
+			state = "on, requests are captured"
+		}
+		m.statusMessage = "Inspection " + state
+		m.tickerActive = true
+		return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+			return clearStatusMsg{}
+		})
+
+	case funnelInspectErrMsg:
+		m.statusMessage = msg.Error()
+		m.tickerActive = true
+		return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg { // Show errors longer
+			return clearStatusMsg{}
+		})
+
 	case funnelDeleteErrMsg:
 		// TODO: Display the delete error message (e.g., in a status bar/footer)
 		// For now, just log it or ignore, as we're already back in list view
@@ -383,9 +736,30 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil // No command needed after processing the request msg
 
+	case funnel.RequestsClearedMsg:
+		if m.state == viewDetail && m.detailTabIndex == 1 && m.detailedFunnelID == msg.FunnelId {
+			m.populateRequestTable()
+		}
+		return m, nil
+
+	case funnel.CaptureUpdateMsg:
+		// Frames and events are read from the shared logs on render, but the body and duration are refreshed here
+		if m.state == viewRequestDetail && m.selectedRequest != nil && m.selectedRequest.ID == msg.RequestId {
+			if f, err := m.funnelRegistry.GetFunnel(msg.FunnelId); err == nil && f.Requests != nil {
+				if request := f.Requests.Find(msg.RequestId); request != nil {
+					m.selectedRequest = request
+					m.syncBodyViewport()
+				}
+			}
+		}
+		if m.state == viewDetail && m.detailTabIndex == 1 {
+			m.populateRequestTable()
+		}
+		return m, nil
+
 	// Handle Clipboard Messages & Status Clearing
 	case clipboardWriteSuccessMsg:
-		m.statusMessage = "URL Copied!"
+		m.statusMessage = msg.what + " copied!"
 		m.tickerActive = true
 		return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
 			return clearStatusMsg{}
@@ -398,6 +772,60 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return clearStatusMsg{}
 		})
 
+	case harExportedMsg:
+		m.statusMessage = fmt.Sprintf("Exported %d requests to %s", msg.count, msg.path)
+		m.tickerActive = true
+		return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+			return clearStatusMsg{}
+		})
+
+	case harExportErrMsg:
+		m.statusMessage = msg.Error()
+		m.tickerActive = true
+		return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+			return clearStatusMsg{}
+		})
+
+	case requestReplayedMsg:
+		// show the replay in place of the request it came from
+		if m.state == viewRequestDetail && m.selectedRequest != nil && m.selectedRequest.ID == msg.request.ReplayOf {
+			m.selectedRequest = msg.request
+			m.bodyViewport.GotoTop()
+			m.syncBodyViewport()
+		}
+		if msg.request.Error != "" {
+			m.statusMessage = fmt.Sprintf("Replayed, %s", msg.request.ErrorKind.Description())
+		} else {
+			m.statusMessage = fmt.Sprintf("Replayed, the target responded %d", msg.request.StatusCode())
+		}
+		m.tickerActive = true
+		return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+			return clearStatusMsg{}
+		})
+
+	case requestComposedMsg:
+		// show the response, esc goes back to the request log as usual
+		m.isSending = false
+		m.focusComposeInput(-1)
+		m.selectedRequest = msg.request
+		m.detailTabIndex = 1
+		m.state = viewRequestDetail
+		m.bodyViewport.GotoTop()
+		m.syncBodyViewport()
+		return m, nil
+
+	case composeErrMsg:
+		m.isSending = false
+		m.composeErrMsg = msg.err.Error()
+		return m, nil
+
+	case requestReplayErrMsg:
+		m.statusMessage = msg.Error()
+		m.tickerActive = true
+		return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+			return clearStatusMsg{}
+		})
+
 	case clearStatusMsg:
 		m.statusMessage = ""
 		m.tickerActive = false
@@ -425,20 +853,22 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if totalWidth < 0 {
 			totalWidth = 0
 		}
-		nameWidth := int(float64(totalWidth) * 0.3) // 30% for Name
-		targetWidth := totalWidth - nameWidth - 4   // for some reason we need the extra 4
+		nameWidth := int(float64(totalWidth) * 0.3)              // 30% for Name
+		inspectWidth := 8                                        // on/off
+		targetWidth := totalWidth - nameWidth - inspectWidth - 6 // for some reason we need the extra padding
 
 		// Create new column definitions with calculated widths
 		newColumns := []table.Column{
 			{Title: "Name", Width: nameWidth},
 			{Title: "Local Target", Width: targetWidth},
+			{Title: "Inspect", Width: inspectWidth},
 		}
 		m.table.SetColumns(newColumns)
 
 		timestampWidth := 12
 		statusWidth := 7
 		methodWidth := 7
-		typeWidth := 8
+		typeWidth := 10 // fits the error markers, e.g. "✗ refused"
 		durationWidth := 10
 
 		bufferWidth := 10
@@ -456,6 +886,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		m.requestTable.SetColumns(requestColumns)
 
+		m.composePathInput.Width = max(tableWidth-6, 10)
+		m.composeHeadersArea.SetWidth(tableWidth)
+		m.composeBodyArea.SetWidth(tableWidth)
+		m.syncBodyViewport()
+
 		return m, nil
 	}
 
@@ -473,6 +908,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateHelpView(msg) // Add call to new update function
 	case viewRequestDetail:
 		return m.updateRequestDetailView(msg)
+	case viewHistory:
+		return m.updateHistoryView(msg)
+	case viewCompose:
+		return m.updateComposeView(msg)
 	}
 
 	return m, nil
@@ -486,13 +925,19 @@ func (m model) updateListView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "n": // Switch to create view
+			if m.readOnly {
+				m.statusMessage = "Funnels can't be created while viewing files"
+				m.tickerActive = true
+				return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+					return clearStatusMsg{}
+				})
+			}
 			m.state = viewCreate
 			// Reset fields and focus
 			m.funnelNameInput.Reset()
 			m.funnelTargetInput.Reset()
-			m.inputFocusIndex = 0
-			m.funnelNameInput.Focus()
-			m.funnelTargetInput.Blur()
+			m.funnelPortInput.Reset()
+			m.focusCreateInput(0)
 			m.createErrMsg = ""
 			// Make sure table loses focus when switching away
 			m.table.Blur()
@@ -517,11 +962,39 @@ func (m model) updateListView(msg tea.Msg) (tea.Model, tea.Cmd) {
 				funnel, err := m.funnelRegistry.GetFunnel(funnelID)
 				if err == nil {
 					remoteURL := funnel.RemoteTarget()
-					return m, copyToClipboardCmd(remoteURL)
+					return m, copyToClipboardCmd(remoteURL, "URL")
 				} // else: funnel not found? log error? do nothing?
 			}
 			return m, nil // Do nothing if index invalid or funnel lookup fails
 
+		case "i": // Toggle inspection for the selected funnel
+			selectedIndex := m.table.Cursor()
+			if selectedIndex >= 0 && selectedIndex < len(m.funnelOrder) {
+				funnelID := m.funnelOrder[selectedIndex]
+				funnel, err := m.funnelRegistry.GetFunnel(funnelID)
+				if err == nil {
+					return m, setInspectCmd(funnelID, !funnel.Inspect(), m.funnelRegistry)
+				}
+			}
+			return m, nil
+
+		case "h": // Change how many requests the selected funnel keeps
+			selectedIndex := m.table.Cursor()
+			if selectedIndex >= 0 && selectedIndex < len(m.funnelOrder) {
+				funnelID := m.funnelOrder[selectedIndex]
+				funnel, err := m.funnelRegistry.GetFunnel(funnelID)
+				if err == nil {
+					m.historyFunnelID = funnelID
+					m.historyErrMsg = ""
+					m.historyInput.SetValue(strconv.Itoa(funnel.Requests.MaxLength()))
+					m.historyInput.CursorEnd()
+					m.state = viewHistory
+					m.table.Blur()
+					return m, tea.Batch(m.historyInput.Focus(), textinput.Blink)
+				}
+			}
+			return m, nil
+
 		case "enter", " ": // View details
 			selectedIndex := m.table.Cursor()
 			if selectedIndex >= 0 && selectedIndex < len(m.funnelOrder) {
@@ -529,6 +1002,7 @@ func (m model) updateListView(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = viewDetail
 				m.detailTabIndex = 0 // Default to Info tab
 				m.table.Blur()       // Unfocus table
+				m.clearRequestFilter()
 				// Populate the request table immediately upon entering detail view
 				m.populateRequestTable()
 				return m, nil
@@ -571,64 +1045,55 @@ func (m model) updateCreateView(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.Type {
 		case tea.KeyEsc:
 			m.state = viewList
-			m.funnelNameInput.Blur()
-			m.funnelTargetInput.Blur()
+			m.focusCreateInput(-1)
 			m.createErrMsg = ""
 			m.table.Focus() // Focus table when going back
 			return m, nil   // No command needed
 
 		case tea.KeyTab, tea.KeyUp, tea.KeyDown, tea.KeyShiftTab:
 			// Don't switch focus if we are about to submit
-			if m.inputFocusIndex == 1 && (msg.Type == tea.KeyDown || msg.Type == tea.KeyTab) {
+			if m.inputFocusIndex == createInputCount-1 && (msg.Type == tea.KeyDown || msg.Type == tea.KeyTab) {
 				break // Let enter handle submission
 			}
 
 			isUp := msg.Type == tea.KeyUp || msg.Type == tea.KeyShiftTab || (msg.Type == tea.KeyTab && msg.Alt)
 
-			// Cycle focus
+			// Cycle focus, wrapping around
+			next := m.inputFocusIndex + 1
 			if isUp {
-				m.inputFocusIndex--
-			} else {
-				m.inputFocusIndex++
-			}
-
-			// Wrap focus
-			if m.inputFocusIndex > 1 {
-				m.inputFocusIndex = 0
-			}
-			if m.inputFocusIndex < 0 {
-				m.inputFocusIndex = 1
+				next = m.inputFocusIndex - 1
 			}
+			next = (next + createInputCount) % createInputCount
 
-			if m.inputFocusIndex == 0 {
-				cmds = append(cmds, m.funnelNameInput.Focus())
-				m.funnelTargetInput.Blur()
-			} else {
-				m.funnelNameInput.Blur()
-				cmds = append(cmds, m.funnelTargetInput.Focus())
-			}
 			// Don't process the key further; return here
-			return m, tea.Batch(cmds...)
+			return m, m.focusCreateInput(next)
 
 		case tea.KeyEnter:
-			// Only submit if the target input is focused
-			if m.inputFocusIndex == 1 {
+			// Only submit if the last input is focused
+			if m.inputFocusIndex == createInputCount-1 {
+				remotePort, err := parseRemotePort(m.funnelPortInput.Value())
+				if err != nil {
+					m.createErrMsg = err.Error()
+					return m, nil
+				}
 				funnelName := m.funnelNameInput.Value()
 				funnelTarget := m.funnelTargetInput.Value()
 				// TODO: Add input validation here
 				m.isCreating = true
 				m.createErrMsg = ""
-				m.funnelNameInput.Blur()
-				m.funnelTargetInput.Blur()
-				cmds = append(cmds, createFunnelCmd(funnelName, funnelTarget, m.logger))
+				m.focusCreateInput(-1)
+				cmds = append(cmds, createFunnelCmd(funnel.EphemeralFunnelOptions{
+					Name:        funnelName,
+					Target:      funnelTarget,
+					RemotePort:  remotePort,
+					Inspect:     true,
+					MaxRequests: m.maxRequests,
+				}, m.logger))
 				cmds = append(cmds, m.spinner.Tick) // Start the spinner
 				return m, tea.Batch(cmds...)
 			} else {
-				// If enter is pressed on the first input, move focus to the second
-				m.inputFocusIndex = 1
-				m.funnelNameInput.Blur()
-				cmds = append(cmds, m.funnelTargetInput.Focus())
-				return m, tea.Batch(cmds...)
+				// If enter is pressed on an earlier input, move focus to the next one
+				return m, m.focusCreateInput(m.inputFocusIndex + 1)
 			}
 			// If not submitting, fall through to let the input handle the key if needed (though usually not for Enter)
 			// break // prevent fallthrough to input update
@@ -645,15 +1110,237 @@ func (m model) updateCreateView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Handle character input for the focused field
 	// Update the corresponding text input model and store the command
 	var inputCmd tea.Cmd
-	if m.inputFocusIndex == 0 {
+	switch m.inputFocusIndex {
+	case 0:
 		m.funnelNameInput, inputCmd = m.funnelNameInput.Update(msg)
-	} else {
+	case 1:
 		m.funnelTargetInput, inputCmd = m.funnelTargetInput.Update(msg)
+	case 2:
+		m.funnelPortInput, inputCmd = m.funnelPortInput.Update(msg)
 	}
 
 	return m, inputCmd // Return the command from the input update
 }
 
+// createInputCount is the number of inputs in the create view: name, target and remote port.
+const createInputCount = 3
+
+// focusCreateInput focuses the create view input at index and blurs the others.
+// An index of -1 blurs all of them.
+func (m *model) focusCreateInput(index int) tea.Cmd {
+	inputs := []*textinput.Model{&m.funnelNameInput, &m.funnelTargetInput, &m.funnelPortInput}
+	var cmd tea.Cmd
+	for i, input := range inputs {
+		if i == index {
+			cmd = input.Focus()
+		} else {
+			input.Blur()
+		}
+	}
+	if index >= 0 {
+		m.inputFocusIndex = index
+	}
+	return cmd
+}
+
+// parseRemotePort parses the remote port input, defaulting to 443 when empty.
+func parseRemotePort(value string) (uint16, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 443, nil
+	}
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid remote port %q", value)
+	}
+	if err := funnel.ValidateRemotePort(uint16(port)); err != nil {
+		return 0, err
+	}
+	return uint16(port), nil
+}
+
+// updateHistoryView handles updates when the history length prompt is active.
+func (m model) updateHistoryView(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.Type {
+		case tea.KeyEsc:
+			m.state = viewList
+			m.historyInput.Blur()
+			m.historyFunnelID = ""
+			m.table.Focus()
+			return m, nil
+
+		case tea.KeyEnter:
+			n, err := strconv.Atoi(strings.TrimSpace(m.historyInput.Value()))
+			if err != nil || n < 1 {
+				m.historyErrMsg = "history must be a whole number of at least 1"
+				return m, nil
+			}
+			funnel, err := m.funnelRegistry.GetFunnel(m.historyFunnelID)
+			if err != nil {
+				m.historyErrMsg = err.Error()
+				return m, nil
+			}
+			funnel.Requests.SetMaxLength(n)
+
+			m.state = viewList
+			m.historyInput.Blur()
+			m.historyFunnelID = ""
+			m.table.Focus()
+			m.statusMessage = fmt.Sprintf("Keeping the last %d requests of %s", n, funnel.Name())
+			m.tickerActive = true
+			return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+				return clearStatusMsg{}
+			})
+		}
+	}
+
+	var cmd tea.Cmd
+	m.historyInput, cmd = m.historyInput.Update(msg)
+	return m, cmd
+}
+
+// composeInputCount is the number of inputs in the compose view: method, path, headers and body.
+const composeInputCount = 4
+
+// focusComposeInput focuses the compose view input at index and blurs the others.
+// An index of -1 blurs all of them.
+func (m *model) focusComposeInput(index int) tea.Cmd {
+	m.composeFocusIndex = index
+	m.composeMethodInput.Blur()
+	m.composePathInput.Blur()
+	m.composeHeadersArea.Blur()
+	m.composeBodyArea.Blur()
+	switch index {
+	case 0:
+		return m.composeMethodInput.Focus()
+	case 1:
+		return m.composePathInput.Focus()
+	case 2:
+		return m.composeHeadersArea.Focus()
+	case 3:
+		return m.composeBodyArea.Focus()
+	}
+	return nil
+}
+
+// openCompose switches to the compose view for the funnel being viewed, filled in
+// from base, or with an empty GET request when base is nil.
+func (m model) openCompose(base *funnel.CaptureRequestResponse) (tea.Model, tea.Cmd) {
+	f, err := m.funnelRegistry.GetFunnel(m.detailedFunnelID)
+	if err != nil {
+		return m, nil
+	}
+	if f.Archived || base != nil && base.WebSocket != nil {
+		if f.Archived {
+			m.statusMessage = "Requests can't be sent through archived funnels"
+		} else {
+			m.statusMessage = "Websocket connections can't be edited"
+		}
+		m.tickerActive = true
+		return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+			return clearStatusMsg{}
+		})
+	}
+
+	composed := funnel.ComposedRequest{Method: http.MethodGet, Path: "/"}
+	m.composeBasedOn = ""
+	m.composeNotice = ""
+	if base != nil {
+		composed, err = funnel.NewComposedRequest(f, *base)
+		if err != nil {
+			m.statusMessage = err.Error()
+			m.tickerActive = true
+			return m, tea.Tick(3*time.Second, func(t time.Time) tea.Msg {
+				return clearStatusMsg{}
+			})
+		}
+		m.composeBasedOn = base.ID
+		if base.Request.BodyTruncated {
+			m.composeNotice = "The original body was " + funnel.BodySizeLabel(base.Request.BodySize, len(base.Request.Body), true) + ", only the captured part is sent."
+		}
+	}
+
+	m.composeMethodInput.SetValue(composed.Method)
+	m.composePathInput.SetValue(composed.Path)
+	m.composeHeadersArea.SetValue(funnel.FormatHeaderLines(composed.Headers))
+	m.composeBodyArea.SetValue(string(composed.Body))
+	m.composeFunnelID = m.detailedFunnelID
+	m.composeErrMsg = ""
+	m.composeReturnState = m.state
+	m.state = viewCompose
+	m.requestTable.Blur()
+	return m, tea.Batch(m.focusComposeInput(0), textinput.Blink)
+}
+
+// updateComposeView handles updates when the compose view is active.
+func (m model) updateComposeView(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// If sending, only handle spinner ticks
+	if m.isSending {
+		if msg, ok := msg.(spinner.TickMsg); ok {
+			m.spinner, cmd = m.spinner.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc":
+			m.focusComposeInput(-1)
+			m.composeErrMsg = ""
+			m.state = m.composeReturnState
+			if m.state == viewDetail {
+				m.requestTable.Focus()
+			}
+			return m, nil
+
+		case "tab":
+			return m, m.focusComposeInput((m.composeFocusIndex + 1) % composeInputCount)
+
+		case "shift+tab":
+			return m, m.focusComposeInput((m.composeFocusIndex - 1 + composeInputCount) % composeInputCount)
+
+		case "enter":
+			// the text areas take new lines, enter only moves on from the single line inputs
+			if m.composeFocusIndex < 2 {
+				return m, m.focusComposeInput(m.composeFocusIndex + 1)
+			}
+
+		case "ctrl+s":
+			headers, err := funnel.ParseHeaderLines(m.composeHeadersArea.Value())
+			if err != nil {
+				m.composeErrMsg = err.Error()
+				return m, nil
+			}
+			request := funnel.ComposedRequest{
+				Method:  m.composeMethodInput.Value(),
+				Path:    m.composePathInput.Value(),
+				Headers: headers,
+				Body:    []byte(m.composeBodyArea.Value()),
+			}
+			m.isSending = true
+			m.composeErrMsg = ""
+			return m, tea.Batch(sendComposedCmd(m.server, m.composeFunnelID, request, m.composeBasedOn), m.spinner.Tick)
+		}
+	}
+
+	// Let the focused field process the key, or its cursor blink
+	switch m.composeFocusIndex {
+	case 0:
+		m.composeMethodInput, cmd = m.composeMethodInput.Update(msg)
+	case 1:
+		m.composePathInput, cmd = m.composePathInput.Update(msg)
+	case 2:
+		m.composeHeadersArea, cmd = m.composeHeadersArea.Update(msg)
+	case 3:
+		m.composeBodyArea, cmd = m.composeBodyArea.Update(msg)
+	}
+	return m, cmd
+}
+
 // updateConfirmDeleteView handles updates when the confirmation view is active.
 func (m model) updateConfirmDeleteView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
@@ -683,6 +1370,10 @@ func (m model) updateConfirmDeleteView(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m model) updateDetailView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd // Declare cmd here to potentially capture it from table update
 
+	if m.filterInput.Focused() {
+		return m.updateRequestFilter(msg)
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
@@ -725,11 +1416,55 @@ func (m model) updateDetailView(msg tea.Msg) (tea.Model, tea.Cmd) {
 				funnel, err := m.funnelRegistry.GetFunnel(m.detailedFunnelID)
 				if err == nil {
 					remoteURL := funnel.RemoteTarget()
-					return m, copyToClipboardCmd(remoteURL)
+					return m, copyToClipboardCmd(remoteURL, "URL")
 				} // else: funnel not found? log error?
 			}
 			return m, nil // Do nothing if not on info tab or error
 
+		case "e": // Export the request log as a HAR file
+			if m.detailTabIndex == 1 {
+				return m, exportHARCmd(m.detailedFunnelID, m.funnelRegistry, m.requestFilter)
+			}
+			return m, nil
+
+		case "/": // Filter the request log
+			if m.detailTabIndex == 1 {
+				m.requestTable.Blur()
+				return m, tea.Batch(m.filterInput.Focus(), textinput.Blink)
+			}
+			return m, nil
+
+		case "r": // Replay the selected request
+			if m.detailTabIndex == 1 {
+				selectedRow := m.requestTable.SelectedRow()
+				if len(selectedRow) == 0 {
+					return m, nil
+				}
+				return m, replayRequestCmd(m.server, m.detailedFunnelID, selectedRow[len(selectedRow)-1])
+			}
+			return m, nil
+
+		case "m": // Edit the selected request and resend it
+			if m.detailTabIndex == 1 {
+				selectedRow := m.requestTable.SelectedRow()
+				if len(selectedRow) == 0 {
+					return m, nil
+				}
+				funnel, err := m.funnelRegistry.GetFunnel(m.detailedFunnelID)
+				if err == nil && funnel.Requests != nil {
+					if request := funnel.Requests.Find(selectedRow[len(selectedRow)-1]); request != nil {
+						return m.openCompose(request)
+					}
+				}
+			}
+			return m, nil
+
+		case "n": // Compose a new request
+			if m.detailTabIndex == 1 {
+				return m.openCompose(nil)
+			}
+			return m, nil
+
 		case "enter":
 			if m.detailTabIndex == 1 {
 				selectedRow := m.requestTable.SelectedRow()
@@ -739,22 +1474,14 @@ func (m model) updateDetailView(msg tea.Msg) (tea.Model, tea.Cmd) {
 				selectedRequestID := selectedRow[len(selectedRow)-1] // id is always the last column
 
 				funnel, err := m.funnelRegistry.GetFunnel(m.detailedFunnelID)
-				if err == nil {
-					// Find the request by ID in the linked list
-					node := funnel.Requests.Head
-					found := false
-					for node != nil {
-						if node.Request.ID == selectedRequestID {
-							m.selectedRequest = &node.Request
-							m.state = viewRequestDetail
-							m.requestTable.Blur() // Unfocus table when leaving
-							found = true
-							break
-						}
-						node = node.Next
-					}
-
-					if found {
+				if err == nil && funnel.Requests != nil {
+					// Keep a copy, the list may be updated while we're looking at it
+					if request := funnel.Requests.Find(selectedRequestID); request != nil {
+						m.selectedRequest = request
+						m.state = viewRequestDetail
+						m.requestTable.Blur() // Unfocus table when leaving
+						m.bodyViewport.GotoTop()
+						m.syncBodyViewport()
 						return m, nil
 					}
 				}
@@ -774,6 +1501,48 @@ func (m model) updateDetailView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// updateRequestFilter handles keys while the request log filter is being typed.
+// The log is filtered as the expression changes, whenever it parses.
+func (m model) updateRequestFilter(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "enter":
+			m.filterInput.Blur()
+			m.requestTable.Focus()
+			return m, nil
+
+		case "esc":
+			m.clearRequestFilter()
+			m.populateRequestTable()
+			m.requestTable.Focus()
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.filterInput, cmd = m.filterInput.Update(msg)
+	if m.filterInput.Value() != m.requestFilter.String() {
+		filter, err := funnel.ParseFilter(m.filterInput.Value())
+		if err != nil {
+			m.filterErrMsg = err.Error()
+		} else {
+			m.filterErrMsg = ""
+			m.requestFilter = filter
+			m.populateRequestTable()
+			m.requestTable.GotoTop()
+		}
+	}
+	return m, cmd
+}
+
+// clearRequestFilter removes the request log filter, the caller re-populates the table.
+func (m *model) clearRequestFilter() {
+	m.filterInput.Blur()
+	m.filterInput.SetValue("")
+	m.requestFilter = funnel.Filter{}
+	m.filterErrMsg = ""
+}
+
 // updateHelpView handles updates when the help view is active.
 func (m model) updateHelpView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
@@ -805,11 +1574,47 @@ func (m model) updateRequestDetailView(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.selectedRequest = nil // Clear the selected request
 			m.requestTable.Focus()  // Refocus the request table
 			return m, nil
+
+		case "r": // Replay the request, the replay is shown once it completes
+			if m.selectedRequest != nil {
+				return m, replayRequestCmd(m.server, m.detailedFunnelID, m.selectedRequest.ID)
+			}
+			return m, nil
+
+		case "m": // Edit the request and resend it
+			if m.selectedRequest != nil {
+				return m.openCompose(m.selectedRequest)
+			}
+			return m, nil
+
+		case "c", "h", "w", "g": // Copy the request as a curl or HTTPie command, raw HTTP or Go
+			if m.selectedRequest != nil {
+				format := map[string]funnel.SnippetFormat{
+					"c": funnel.SnippetCurl,
+					"h": funnel.SnippetHTTPie,
+					"w": funnel.SnippetRaw,
+					"g": funnel.SnippetGo,
+				}[msg.String()]
+				return m, copySnippetCmd(format, *m.selectedRequest)
+			}
+			return m, nil
+
+		case "tab", "shift+tab": // Switch between the request and response body
+			m.bodyTabIndex = 1 - m.bodyTabIndex
+			m.bodyViewport.GotoTop()
+			m.syncBodyViewport()
+			return m, nil
+
+		case "v": // Toggle between the formatted and raw body
+			m.bodyRaw = !m.bodyRaw
+			m.syncBodyViewport()
+			return m, nil
 		}
 	}
-	// Handle other message types (like window resize) if needed.
-	// Currently, no other actions are handled in this basic version.
-	return m, nil
+	// Anything else scrolls the body
+	var cmd tea.Cmd
+	m.bodyViewport, cmd = m.bodyViewport.Update(msg)
+	return m, cmd
 }
 
 // View renders the TUI's UI. It's called after every Update.
@@ -840,6 +1645,10 @@ func (m model) View() string {
 		mainContent = m.viewHelpView(contentHeight)
 	case viewRequestDetail:
 		mainContent = m.viewRequestDetailView(contentHeight)
+	case viewHistory:
+		mainContent = m.viewHistoryView(contentHeight)
+	case viewCompose:
+		mainContent = m.viewComposeView(contentHeight)
 	}
 
 	finalView := lipgloss.JoinVertical(lipgloss.Left,
@@ -887,6 +1696,9 @@ func (m model) viewListView(contentHeight int) string {
 			Foreground(subtleGrey). // Subtle grey color
 			MarginLeft(2)           // Indent slightly
 		emptyMessage := emptyStyle.Render("No active funnels. Press 'n' to create one.")
+		if m.pendingFunnels > 0 {
+			emptyMessage = emptyStyle.Render(fmt.Sprintf("Starting %d configured funnel(s)...", m.pendingFunnels))
+		}
 
 		return m.renderContent(title, emptyMessage, contentHeight, 1)
 	}
@@ -910,6 +1722,7 @@ func (m model) viewCreateView(contentHeight int) string {
 	title := "Create New Funnel"
 	nameInputView := m.funnelNameInput.View()
 	targetInputView := m.funnelTargetInput.View()
+	portInputView := m.funnelPortInput.View()
 
 	// Style for contextual help text (like the empty list view)
 	helpTextStyle := lipgloss.NewStyle().
@@ -921,9 +1734,12 @@ func (m model) viewCreateView(contentHeight int) string {
 
 	// Determine contextual help text based on focus
 	var helpText string
-	if m.inputFocusIndex == 0 {
+	switch m.inputFocusIndex {
+	case 0:
 		helpText = "The tailscale node name for your funnel. Tailscale will automatically convert it to a dns-safe version, and will append a suffix if the name is already taken in your tailnet."
-	} else {
+	case 2:
+		helpText = "The public port for your funnel, one of 443 (default), 8443 or 10000.  Your tailnet policy must allow funnels on the chosen port."
+	default:
 		helpText = "The local HTTP server address to forward traffic to.  Examples are:\n8000\nlocalhost:8000\nhttp://localhost:8000\nhttps://localhost:8000  (for local HTTPS)\nhttps+insecure://localhost:8000  (for local HTTPS with self-signed cert)"
 	}
 	renderedHelpText := helpTextStyle.Render(helpText)
@@ -942,6 +1758,7 @@ func (m model) viewCreateView(contentHeight int) string {
 	content := lipgloss.JoinVertical(lipgloss.Left,
 		nameInputView,
 		targetInputView,
+		portInputView,
 		renderedHelpText,  // Add the contextual help text here
 		statusOrErrorView, // Spinner or error message
 	)
@@ -949,6 +1766,73 @@ func (m model) viewCreateView(contentHeight int) string {
 	return m.renderContent(title, content, contentHeight, 1)
 }
 
+// viewHistoryView renders the prompt for a funnel's history length.
+func (m model) viewHistoryView(contentHeight int) string {
+	funnelName := m.historyFunnelID
+	funnel, err := m.funnelRegistry.GetFunnel(m.historyFunnelID)
+	if err == nil {
+		funnelName = funnel.Name()
+	}
+
+	helpText := lipgloss.NewStyle().Italic(true).Foreground(subtleGrey).PaddingTop(1).Width(m.width - 4).
+		Render("The number of captured requests to keep for this funnel, the oldest are dropped first.  All funnels also share a capture memory budget, see --max-capture-bytes.")
+
+	var errView string
+	if m.historyErrMsg != "" {
+		errView = lipgloss.NewStyle().Foreground(lipgloss.Color("9")).PaddingTop(1).Render("Error: " + m.historyErrMsg)
+	}
+
+	content := lipgloss.JoinVertical(lipgloss.Left,
+		fmt.Sprintf("Requests to keep for '%s':", funnelName),
+		m.historyInput.View(),
+		helpText,
+		errView,
+	)
+	return m.renderContent("Capture History", content, contentHeight, 1)
+}
+
+// viewComposeView renders the request composer.
+func (m model) viewComposeView(contentHeight int) string {
+	title := "New Request"
+	if m.composeBasedOn != "" {
+		title = "Edit and Resend"
+	}
+	target := m.composeFunnelID
+	if f, err := m.funnelRegistry.GetFunnel(m.composeFunnelID); err == nil {
+		target = f.LocalTarget()
+	}
+
+	labelStyle := lipgloss.NewStyle().Bold(true)
+	hintStyle := lipgloss.NewStyle().Italic(true).Foreground(subtleGrey)
+
+	var statusOrErrorView string
+	if m.isSending {
+		statusOrErrorView = lipgloss.NewStyle().PaddingTop(1).Render(fmt.Sprintf("%s Sending request...", m.spinner.View()))
+	} else if m.composeErrMsg != "" {
+		statusOrErrorView = lipgloss.NewStyle().Foreground(lipgloss.Color("9")).PaddingTop(1).Render("Error: " + m.composeErrMsg)
+	}
+
+	bodyLabel := labelStyle.Render("Body")
+	if m.composeNotice != "" {
+		bodyLabel += "  " + hintStyle.Render(m.composeNotice)
+	}
+
+	content := lipgloss.JoinVertical(lipgloss.Left,
+		hintStyle.Width(m.width-4).Render(fmt.Sprintf("Sent to %s as though it came through the funnel, the response is captured like any other request.", target)),
+		"",
+		labelStyle.Render("Method"),
+		m.composeMethodInput.View(),
+		labelStyle.Render("Path"),
+		m.composePathInput.View(),
+		labelStyle.Render("Headers")+"  "+hintStyle.Render(`one "Name: value" per line`),
+		m.composeHeadersArea.View(),
+		bodyLabel,
+		m.composeBodyArea.View(),
+		statusOrErrorView,
+	)
+	return m.renderContent(title, content, contentHeight, 1)
+}
+
 // viewConfirmDeleteView renders the deletion confirmation prompt.
 func (m model) viewConfirmDeleteView(contentHeight int) string {
 	funnelName := m.deletingFunnelID
@@ -994,19 +1878,31 @@ func (m *model) populateRequestTable() {
 	}
 
 	rows := []table.Row{}
+	m.totalCount = 0
 	node := funnel.Requests.Head
-	for node != nil {
+	for ; node != nil; node = node.Next {
+		m.totalCount++
+		if !m.requestFilter.Match(&node.Request) {
+			continue
+		}
+		path := node.Request.Path()
+		switch node.Request.Origin() {
+		case "replay":
+			path = "↻ " + path
+		case "edited", "composed":
+			path = "✎ " + path
+		}
 		rows = append(rows, table.Row{
 			node.Request.Timestamp.Format("15:04:05"),
 			strconv.Itoa(node.Request.StatusCode()),
 			node.Request.Method(),
-			node.Request.Path(),
+			path,
 			node.Request.Type(),
-			node.Request.RoundedDuration(),
+			node.Request.DurationLabel(),
 			node.Request.ID,
 		})
-		node = node.Next
 	}
+	m.matchedCount = len(rows)
 	m.requestTable.SetRows(rows)
 }
 
@@ -1018,19 +1914,24 @@ func (m model) viewDetailView(availableHeight int) string {
 		return fmt.Sprintf("Error: Funnel %s not found.", m.detailedFunnelID)
 	}
 
+	requestLogTitle := "Request Log"
+	if !m.requestFilter.Empty() {
+		requestLogTitle = fmt.Sprintf("Request Log (%d/%d)", m.matchedCount, m.totalCount)
+	}
+
 	var row string
 	if m.detailTabIndex == 0 {
 		row = lipgloss.JoinHorizontal(
 			lipgloss.Top,
 			activeTab.Render("Info"),
-			tab.Render("Request Log"),
+			tab.Render(requestLogTitle),
 		)
 
 	} else {
 		row = lipgloss.JoinHorizontal(
 			lipgloss.Top,
 			tab.Render("Info"),
-			activeTab.Render("Request Log"),
+			activeTab.Render(requestLogTitle),
 		)
 	}
 
@@ -1046,13 +1947,37 @@ func (m model) viewDetailView(availableHeight int) string {
 	switch m.detailTabIndex {
 	case 0: // Info Tab
 		// todo: move to a view function
+		inspectInfo := "off (pass-through, requests are not captured)"
+		if funnel.Inspect() {
+			inspectInfo = "on (requests are captured)"
+		} else if funnel.Archived {
+			inspectInfo = "archived (read-only captures from an earlier run)"
+		}
+		budget := m.funnelRegistry.CaptureBudget()
+		memoryInfo := util.FormatBytes(budget.Used()) + " used by all funnels"
+		if budget.Limit() > 0 {
+			memoryInfo += " of " + util.FormatBytes(budget.Limit())
+		}
 		infoContent := fmt.Sprintf(
-			"Name:         %s\nLocal Target: %s\nPublic URL:   %s",
-			funnel.Name(), funnel.LocalTarget(), funnel.RemoteTarget(),
+			"Name:         %s\nLocal Target: %s\nPublic URL:   %s\nInspect:      %s\nHistory:      %d of the last %d requests\nMemory:       %s",
+			funnel.Name(), funnel.LocalTarget(), funnel.RemoteTarget(), inspectInfo,
+			funnel.Requests.Length, funnel.Requests.MaxLength(), memoryInfo,
 		)
 		tabContent = infoContent
 	case 1: // Requests Tab
-		tabContent = m.viewRequestLogView(tabContentHeight)
+		var notes []string
+		if !funnel.Inspect() && !funnel.Archived {
+			notes = append(notes, lipgloss.NewStyle().Italic(true).Foreground(subtleGrey).
+				Render("Inspection is off for this funnel, new requests are not captured. Press 'i' in the list view to turn it on."))
+		}
+		if m.filterInput.Focused() || !m.requestFilter.Empty() {
+			filterLine := m.filterInput.View()
+			if m.filterErrMsg != "" {
+				filterLine += "  " + lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Render(m.filterErrMsg)
+			}
+			notes = append(notes, filterLine)
+		}
+		tabContent = lipgloss.JoinVertical(lipgloss.Left, append(notes, m.viewRequestLogView(tabContentHeight-len(notes)))...)
 	}
 
 	content := lipgloss.JoinVertical(lipgloss.Left, row, tabContent)
@@ -1067,22 +1992,32 @@ func (m model) footerView() string {
 	var coreHelp string
 	switch m.state {
 	case viewList:
-		if len(m.table.Rows()) == 0 {
+		if m.readOnly {
+			coreHelp = "enter: details, d: close, q: quit, ?: help"
+		} else if len(m.table.Rows()) == 0 {
 			coreHelp = "n: new, q: quit, ?: help"
 		} else {
 			// coreHelp = "↑/↓: sel, n: new, d: del, c: copy, enter: details, q: quit, ?: help"
-			coreHelp = "n: new, d: del, q: quit, ?: help"
+			coreHelp = "n: new, d: del, i: inspect, h: history, q: quit, ?: help"
 		}
 	case viewCreate:
 		coreHelp = "tab: switch, enter: create, esc: cancel, ?: help"
 	case viewConfirmDelete:
 		coreHelp = "y: confirm, n/esc: cancel, ?: help"
 	case viewDetail:
-		coreHelp = "tab/←/→: tabs, c: copy, esc/q: back, ?: help"
+		if m.filterInput.Focused() {
+			coreHelp = "enter: keep filter, esc: clear filter"
+		} else {
+			coreHelp = "tab/←/→: tabs, /: filter, c: copy, e: export HAR, r: replay, m: edit, n: new, esc/q: back, ?: help"
+		}
 	case viewHelp: // No specific help needed when already viewing help
 		coreHelp = "esc/q: back, ←/→: scroll"
 	case viewRequestDetail:
-		coreHelp = "esc/q: back"
+		coreHelp = "tab: body, v: pretty/raw, ↑/↓: scroll, r: replay, m: edit, c/h/w/g: copy, esc: back, ?: help"
+	case viewHistory:
+		coreHelp = "enter: save, esc: cancel"
+	case viewCompose:
+		coreHelp = "tab: next field, ctrl+s: send, esc: cancel"
 	}
 
 	// Combine status message and help text
@@ -1151,15 +2086,35 @@ func (m model) viewRequestDetailView(contentHeight int) string {
 		return m.renderContent(title, "Error: No request selected.", contentHeight, 1)
 	}
 
+	// the header may have grown since the viewport was sized, e.g. with websocket frames
+	header := m.requestDetailHeader()
+	tabs := m.bodyTabsView()
+	bodyViewport := m.bodyViewport
+	bodyViewport.Height = m.bodyViewportHeight(contentHeight, header, tabs)
+
+	content := lipgloss.JoinVertical(lipgloss.Left, header, tabs, bodyViewport.View())
+	return m.renderContent(title, content, contentHeight, 1)
+}
+
+// requestDetailHeader renders everything in the request detail above the body, wrapped
+// to the width of the view.
+func (m model) requestDetailHeader() string {
+	status := strconv.Itoa(m.selectedRequest.StatusCode())
+	if m.selectedRequest.InProgress {
+		status += " (in progress)"
+	}
+
 	requestInfo := fmt.Sprintf(
-		"URL:    %s\nMethod: %s\nStatus: %d\nDuration: %s",
+		"URL:    %s\nMethod: %s\nStatus: %s\nDuration: %s\nRequest Body:  %s\nResponse Body: %s",
 		m.selectedRequest.Path(),
 		m.selectedRequest.Method(),
-		m.selectedRequest.StatusCode(),
-		m.selectedRequest.RoundedDuration(),
+		status,
+		m.selectedRequest.DurationLabel(),
+		funnel.BodySizeLabel(m.selectedRequest.Request.BodySize, len(m.selectedRequest.Request.Body), m.selectedRequest.Request.BodyTruncated),
+		funnel.BodySizeLabel(m.selectedRequest.Response.BodySize, len(m.selectedRequest.Response.Body), m.selectedRequest.Response.BodyTruncated),
 	)
 
-	formatHeaders := func(headers map[string]string) string {
+	formatHeaders := func(headers http.Header) string {
 		var builder strings.Builder
 		if len(headers) == 0 {
 			builder.WriteString("  (No headers)")
@@ -1171,10 +2126,11 @@ func (m model) viewRequestDetailView(contentHeight int) string {
 			}
 			sort.Strings(keys)
 
-			// Iterate over sorted keys
+			// Iterate over sorted keys, one line per value so repeated headers stay separate
 			for _, k := range keys {
-				v := headers[k]
-				builder.WriteString(fmt.Sprintf("  %s: %s\n", k, v))
+				for _, v := range headers[k] {
+					builder.WriteString(fmt.Sprintf("  %s: %s\n", k, v))
+				}
 			}
 			// Remove trailing newline
 			result := builder.String()
@@ -1189,19 +2145,104 @@ func (m model) viewRequestDetailView(contentHeight int) string {
 	requestHeadersTitle := lipgloss.NewStyle().Bold(true).Render("Request Headers")
 	requestHeadersContent := formatHeaders(m.selectedRequest.Request.Headers)
 
+	switch m.selectedRequest.Origin() {
+	case "replay":
+		requestInfo += "\nReplay of: " + m.selectedRequest.ReplayOf
+	case "edited":
+		requestInfo += "\nEdited from: " + m.selectedRequest.ReplayOf
+	case "composed":
+		requestInfo += "\nOrigin: composed by hand"
+	}
+
+	sections := []string{requestInfo}
+
+	if m.selectedRequest.Error != "" {
+		errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("9")) // Red
+		sections = append(sections, "\n", errorStyle.Render(fmt.Sprintf(
+			"Proxy Error: %s\n  %s", m.selectedRequest.ErrorKind.Description(), m.selectedRequest.Error)))
+	}
+
+	// Frames first for websockets, they're what's interesting once the handshake is done
+	if wsLog := m.selectedRequest.WebSocket; wsLog != nil {
+		state := "open"
+		if wsLog.Closed() {
+			state = "closed"
+		}
+		framesTitle := lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("WebSocket Frames (%d, %s)", wsLog.Count(), state))
+		sections = append(sections, "\n", framesTitle, formatWebSocketFrames(wsLog.Frames(), maxDetailFrames, m.width-4))
+	}
+	if events := m.selectedRequest.ServerSentEvents; events != nil {
+		eventsTitle := lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("Server-Sent Events (%d)", events.Count()))
+		sections = append(sections, "\n", eventsTitle, formatSSEEvents(events.Events(), maxDetailFrames, m.width-4))
+	}
+
 	// Simple vertical layout for now
-	content := lipgloss.JoinVertical(lipgloss.Left,
-		requestInfo,
+	sections = append(sections,
 		"\n", // Spacer
 		responseHeadersTitle,
 		responseHeadersContent,
 		"\n", // Spacer
 		requestHeadersTitle,
 		requestHeadersContent,
+		"", // Spacer before the body tabs
 	)
+	content := lipgloss.JoinVertical(lipgloss.Left, sections...)
+	return lipgloss.NewStyle().Width(m.detailContentWidth()).Render(content)
+}
 
-	// Use the standard renderContent helper
-	return m.renderContent(title, content, contentHeight, 1)
+// detailContentWidth is the width inside the request detail's border and padding.
+func (m model) detailContentWidth() int {
+	return max(m.width-4, 1)
+}
+
+// bodyTabsView renders the request and response body tabs, with how the body is shown.
+func (m model) bodyTabsView() string {
+	labels := []string{"Request Body", "Response Body"}
+	var tabs []string
+	for i, label := range labels {
+		if i == m.bodyTabIndex {
+			tabs = append(tabs, activeTab.Render(label))
+		} else {
+			tabs = append(tabs, tab.Render(label))
+		}
+	}
+	row := lipgloss.JoinHorizontal(lipgloss.Top, tabs...)
+	mode := lipgloss.NewStyle().Foreground(subtleGrey).Padding(0, 1).Render(m.selectedBody().Describe())
+	return lipgloss.JoinHorizontal(lipgloss.Bottom, row, mode)
+}
+
+// selectedBody returns the body of the selected tab, formatted unless raw was asked for.
+func (m model) selectedBody() bodyView {
+	if m.selectedRequest == nil {
+		return bodyView{}
+	}
+	if m.bodyTabIndex == 0 {
+		req := m.selectedRequest.Request
+		return newBodyView(req.Body, req.Headers, req.BodySize, req.BodyTruncated, false, m.bodyRaw)
+	}
+	resp := m.selectedRequest.Response
+	return newBodyView(resp.Body, resp.Headers, resp.BodySize, resp.BodyTruncated, m.selectedRequest.InProgress, m.bodyRaw)
+}
+
+// bodyViewportHeight is what's left for the body once the header and tabs are drawn,
+// with a few lines kept even when the headers fill the screen.
+func (m model) bodyViewportHeight(contentHeight int, header string, tabs string) int {
+	// the top border and the content's padding
+	available := contentHeight - 3 - lipgloss.Height(header) - lipgloss.Height(tabs)
+	return max(available, 3)
+}
+
+// syncBodyViewport sizes the body viewport and fills it with the selected body.  It's
+// called whenever the request, the tab or the terminal changes.
+func (m *model) syncBodyViewport() {
+	if m.selectedRequest == nil {
+		m.bodyViewport.SetContent("")
+		return
+	}
+	contentHeight := m.height - lipgloss.Height(m.footerView())
+	m.bodyViewport.Width = m.detailContentWidth()
+	m.bodyViewport.Height = m.bodyViewportHeight(contentHeight, m.requestDetailHeader(), m.bodyTabsView())
+	m.bodyViewport.SetContent(lipgloss.NewStyle().Width(m.bodyViewport.Width).Render(m.selectedBody().Content()))
 }
 
 func (m model) webUIView(width int) string {
@@ -1,25 +1,147 @@
 package util
 
-import tea "github.com/charmbracelet/bubbletea"
+import "sync"
 
-type MessageBus interface {
-	Send(msg tea.Msg)
+// subscriberQueueLength is how many events a subscriber may fall behind by before
+// newer ones are dropped, on top of those coalesced.
+const subscriberQueueLength = 1024
 
-	SetProgram(program *tea.Program)
+// Event is anything published on an EventBus, e.g. funnel.ProxyRequestMsg.
+// Subscribers switch on the types they are interested in and ignore the rest.
+type Event = any
+
+// Coalescer is implemented by events that only say something changed, e.g. that
+// a capture received more data.  While a subscriber hasn't been handed an event
+// yet, a newer one with the same key replaces it.  Events with an empty key are
+// never replaced.
+type Coalescer interface {
+	CoalesceKey() string
+}
+
+// EventsDropped is delivered to a subscriber that fell so far behind that events
+// had to be dropped, once it catches up.  Subscribers that keep state derived
+// from events should rebuild it.
+type EventsDropped struct {
+	Count int
+}
+
+// EventBus publishes the program's events to any number of subscribers.
+type EventBus interface {
+	// Publish hands the event to every subscriber without waiting for any of them.
+	Publish(event Event)
+	// Subscribe calls fn with every event published until the returned function
+	// is called.  Events are delivered in order on a goroutine of the
+	// subscriber's own, so a slow subscriber only holds up itself.
+	Subscribe(fn func(Event)) (unsubscribe func())
 }
 
-type MessageBusImpl struct {
-	program *tea.Program
+// Bus is the EventBus shared by the proxy, the web inspector and the TUI or
+// console output.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
 }
 
-func (m MessageBusImpl) Send(msg tea.Msg) {
-	m.program.Send(msg)
+// subscriber queues the events published since fn was last called.
+type subscriber struct {
+	fn      func(Event)
+	mu      sync.Mutex
+	queue   []Event
+	dropped int
+	wake    chan struct{} // signalled when the queue is no longer empty
+	done    chan struct{}
 }
 
-func (m *MessageBusImpl) SetProgram(program *tea.Program) {
-	m.program = program
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*subscriber]struct{})}
 }
 
-func NewMessageBus(program *tea.Program) MessageBus {
-	return &MessageBusImpl{program: program}
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		sub.enqueue(event)
+	}
+}
+
+func (b *Bus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	sub := &subscriber{
+		fn:   fn,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go sub.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+func (s *subscriber) enqueue(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key := coalesceKey(event); key != "" {
+		for i, queued := range s.queue {
+			if coalesceKey(queued) == key {
+				s.queue[i] = event
+				return
+			}
+		}
+	}
+	if len(s.queue) >= subscriberQueueLength {
+		s.dropped++
+		return
+	}
+	s.queue = append(s.queue, event)
+
+	select {
+	case s.wake <- struct{}{}:
+	default: // already signalled
+	}
+}
+
+func coalesceKey(event Event) string {
+	if c, ok := event.(Coalescer); ok {
+		return c.CoalesceKey()
+	}
+	return ""
+}
+
+// run delivers queued events until the subscriber is unsubscribed.
+func (s *subscriber) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+
+		s.mu.Lock()
+		events, dropped := s.queue, s.dropped
+		s.queue, s.dropped = nil, 0
+		s.mu.Unlock()
+
+		for _, event := range events {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.fn(event)
+		}
+		if dropped > 0 {
+			s.fn(EventsDropped{Count: dropped})
+		}
+	}
 }
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testEvent struct{ N int }
+
+// testUpdate coalesces with the other updates of the same ID.
+type testUpdate struct {
+	ID string
+	N  int
+}
+
+func (u testUpdate) CoalesceKey() string { return u.ID }
+
+// collect subscribes to bus, returning the channel events are delivered on.
+func collect(t *testing.T, bus *Bus) <-chan Event {
+	t.Helper()
+	events := make(chan Event, 2*subscriberQueueLength)
+	t.Cleanup(bus.Subscribe(func(e Event) { events <- e }))
+	return events
+}
+
+// receive waits for n events.
+func receive(t *testing.T, events <-chan Event, n int) []Event {
+	t.Helper()
+	var got []Event
+	for range n {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d events, got %v", n, got)
+		}
+	}
+	return got
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	first, second := collect(t, bus), collect(t, bus)
+
+	for n := range 3 {
+		bus.Publish(testEvent{n})
+	}
+
+	want := []Event{testEvent{0}, testEvent{1}, testEvent{2}}
+	if diff := cmp.Diff(want, receive(t, first, 3)); diff != "" {
+		t.Errorf("First subscriber mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, receive(t, second, 3)); diff != "" {
+		t.Errorf("Second subscriber mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	started, release := make(chan struct{}), make(chan struct{})
+	events := make(chan Event, 2*subscriberQueueLength)
+	t.Cleanup(bus.Subscribe(func(e Event) {
+		if e == (testEvent{-1}) {
+			close(started)
+			<-release
+		}
+		events <- e
+	}))
+
+	bus.Publish(testEvent{-1})
+	<-started
+	// the subscriber is stuck handling the first event, publishing must not wait for it
+	for n := range 5 {
+		bus.Publish(testUpdate{ID: "a", N: n})
+	}
+	bus.Publish(testUpdate{ID: "b"})
+	for n := range subscriberQueueLength + 10 {
+		bus.Publish(testEvent{n})
+	}
+	close(release)
+
+	got := receive(t, events, 1+2+subscriberQueueLength-2+1)
+	want := []Event{testEvent{-1}, testUpdate{ID: "a", N: 4}, testUpdate{ID: "b"}, testEvent{0}}
+	if diff := cmp.Diff(want, got[:4]); diff != "" {
+		t.Errorf("Expected the updates to be coalesced (-want +got):\n%s", diff)
+	}
+	if last := got[len(got)-1]; last != (EventsDropped{Count: 12}) {
+		t.Errorf("Expected to be told about the dropped events, got %v", last)
+	}
+}
+
+func TestBus_Unsubscribe(t *testing.T) {
+	bus := NewBus()
+	events := make(chan Event, 1)
+	unsubscribe := bus.Subscribe(func(e Event) { events <- e })
+
+	bus.Publish(testEvent{1})
+	receive(t, events, 1)
+
+	unsubscribe()
+	unsubscribe() // may be called more than once
+	bus.Publish(testEvent{2})
+	select {
+	case e := <-events:
+		t.Errorf("Expected no events after unsubscribing, got %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
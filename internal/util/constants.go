@@ -1,5 +1,7 @@
 package util
 
+import "time"
+
 const ProgramName = "tsgrok"
 
 var ProgramVersion = "dev" // Will be overwritten by goreleaser
@@ -8,5 +10,27 @@ var Date = "unknown"       // Will be overwritten by goreleaser
 
 const DefaultPort = 4141
 
-const AuthKeyEnvVar = "TSGROK_AUTHKEY"               // env var for auth key
-const ProxyHttpPortEnvVar = "TSGROK_PROXY_HTTP_PORT" // env var for proxy http port, defaults to DefaultPort
+const DefaultMaxRequests = 100 // captured requests kept per funnel
+
+const DefaultMaxCaptureBytes = 256 << 20 // memory budget for captures across all funnels
+
+const DefaultMaxBodyBytes = 1 << 20 // bytes of each request/response body captured
+
+const DefaultMaxWebSocketFrames = 1000 // most recent frames kept per websocket connection
+
+const WebSocketPreviewBytes = 1024 // bytes of each websocket frame payload captured
+
+const DefaultMaxSSEEvents = 1000 // most recent events kept per text/event-stream response
+
+const SSEPreviewBytes = 1024 // bytes of each server-sent event's data captured
+
+const DefaultStoreMaxAge = 7 * 24 * time.Hour // stored captures older than this are pruned
+
+const DefaultStoreMaxBytes = 100 << 20 // size of the capture store before the oldest captures are pruned
+
+const ReplayTimeout = 2 * time.Minute // how long a replayed request, or one composed in the TUI, may take
+
+const AuthKeyEnvVar = "TSGROK_AUTHKEY"                   // env var for auth key
+const ProxyHttpPortEnvVar = "TSGROK_PROXY_HTTP_PORT"     // env var for proxy http port, defaults to DefaultPort
+const MaxRequestsEnvVar = "TSGROK_MAX_REQUESTS"          // env var for captured requests kept per funnel, defaults to DefaultMaxRequests
+const MaxCaptureBytesEnvVar = "TSGROK_MAX_CAPTURE_BYTES" // env var for the capture memory budget, defaults to DefaultMaxCaptureBytes
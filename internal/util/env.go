@@ -16,3 +16,19 @@ func GetProxyHttpPort() int {
 	}
 	return port
 }
+
+func GetMaxRequests() int {
+	maxRequests, err := strconv.Atoi(os.Getenv(MaxRequestsEnvVar))
+	if err != nil || maxRequests <= 0 {
+		return DefaultMaxRequests
+	}
+	return maxRequests
+}
+
+func GetMaxCaptureBytes() int64 {
+	maxBytes, err := strconv.ParseInt(os.Getenv(MaxCaptureBytesEnvVar), 10, 64)
+	if err != nil || maxBytes < 0 {
+		return DefaultMaxCaptureBytes
+	}
+	return maxBytes
+}
@@ -15,18 +15,25 @@ func (w *serverErrorLogWriter) Write(p []byte) (int, error) {
 	return w.file.Write(p)
 }
 
-func NewServerErrorLog() *stdlog.Logger {
+// StateDir returns the directory tsgrok keeps its state in, $XDG_STATE_HOME/tsgrok
+// or ~/.local/state/tsgrok.  It isn't created.
+func StateDir() (string, error) {
 	stateDir := os.Getenv("XDG_STATE_HOME")
 	if stateDir == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			panic(fmt.Sprintf("Failed to get user home directory: %v", err))
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
 		}
 		stateDir = filepath.Join(homeDir, ".local", "state")
 	}
+	return filepath.Join(stateDir, ProgramName), nil
+}
 
-	appName := "tsgrok"
-	logDir := filepath.Join(stateDir, appName)
+func NewServerErrorLog() *stdlog.Logger {
+	logDir, err := StateDir()
+	if err != nil {
+		panic(err.Error())
+	}
 	logPath := filepath.Join(logDir, "app.log")
 
 	if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -7,3 +7,8 @@ var TemplatesFS embed.FS
 
 //go:embed all:static
 var StaticFS embed.FS
+
+// OpenAPISpec describes the JSON API served under /api/v1/.
+//
+//go:embed api/openapi.json
+var OpenAPISpec []byte
@@ -2,33 +2,30 @@
 console.log("Inspector app.js loaded");
 
 document.addEventListener('DOMContentLoaded', () => {
-    const requestsLogPane = document.querySelector('.requests-log-pane');
     const requestDetailsContentWrapper = document.getElementById('request-details-content-wrapper');
 
-    // Handle selection of request items in the left pane
-    if (requestsLogPane) {
-        requestsLogPane.addEventListener('click', (event) => {
-            const targetItem = event.target.closest('.request-item');
-            if (!targetItem) {
-                return; // Click wasn't on a request-item or its child
-            }
+    // Handle selection of request items in the left pane, delegated as the pane's
+    // items are reloaded when requests come in
+    document.addEventListener('click', (event) => {
+        const targetItem = event.target.closest('.requests-log-pane .request-item');
+        if (!targetItem) {
+            return; // Click wasn't on a request-item or its child
+        }
+        selectRequestItem(targetItem.dataset.requestId);
 
-            // Remove .selected-request from previously selected item
-            const currentlySelected = requestsLogPane.querySelector('.request-item.selected-request');
-            if (currentlySelected) {
-                currentlySelected.classList.remove('selected-request');
-            }
+        // Hide initial message if it's still there
+        const initialMessage = document.getElementById('initial-detail-message');
+        if (initialMessage) {
+            initialMessage.style.display = 'none';
+        }
+    });
 
-            // Add .selected-request to the clicked item
-            targetItem.classList.add('selected-request');
-            
-            // Hide initial message if it's still there
-            const initialMessage = document.getElementById('initial-detail-message');
-            if (initialMessage) {
-                initialMessage.style.display = 'none';
-            }
-        });
-    }
+    // Keep the selected request highlighted when the request log is reloaded
+    document.body.addEventListener('htmx:afterSettle', (event) => {
+        if (event.detail.target && event.detail.target.id === 'request-list' && selectedRequestId) {
+            selectRequestItem(selectedRequestId);
+        }
+    });
 
     // Handle tab switching for content loaded by HTMX
     if (requestDetailsContentWrapper) {
@@ -48,6 +45,61 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 });
 
+let selectedRequestId = null;
+
+// selectRequestItem marks the request with the given ID as the one shown in the right pane
+function selectRequestItem(requestId) {
+    selectedRequestId = requestId;
+    document.querySelectorAll('.requests-log-pane .request-item.selected-request').forEach(item => {
+        item.classList.remove('selected-request');
+    });
+    const item = document.querySelector(`.requests-log-pane .request-item[data-request-id="${CSS.escape(requestId)}"]`);
+    if (item) {
+        item.classList.add('selected-request');
+    }
+}
+
+// Live updates: a page with a data-live-events URL follows the server-sent events
+// of it, re-dispatching each on the body as live-<event> for hx-trigger to act on
+document.addEventListener('DOMContentLoaded', () => {
+    const url = document.body.dataset.liveEvents;
+    if (!url || !window.EventSource || !window.htmx) {
+        return;
+    }
+
+    const status = document.getElementById('live-status');
+    const setStatus = (text, title) => {
+        if (status) {
+            status.hidden = false;
+            status.textContent = text;
+            status.title = title;
+        }
+    };
+
+    const source = new EventSource(url);
+    source.addEventListener('open', () => setStatus('● live', 'Updated as requests are captured'));
+    source.addEventListener('error', () => setStatus('○ offline', 'Lost the connection to tsgrok, reconnecting'));
+    ['funnel', 'request'].forEach(name => {
+        source.addEventListener(name, (event) => {
+            const detail = JSON.parse(event.data);
+            if (name === 'funnel' && detail.change === 'removed' && detail.funnel === document.body.dataset.funnelId) {
+                // nothing more will happen here, the captures shown so far stay readable
+                source.close();
+                setStatus('○ deleted', 'This funnel has been deleted');
+                return;
+            }
+            if (name === 'request' && detail.change === 'cleared') {
+                // the request list reloads below, the request shown is gone too
+                const wrapper = document.getElementById('request-details-content-wrapper');
+                if (wrapper) {
+                    wrapper.innerHTML = '<p id="initial-detail-message" class="select-request-message">The requests have been cleared.</p>';
+                }
+            }
+            htmx.trigger(document.body, 'live-' + name, detail);
+        });
+    });
+});
+
 function initializeTabs(container) {
     if (!container) return;
 
@@ -117,4 +169,31 @@ document.addEventListener('click', function(event) {
             });
         }
     }
-}); 
\ No newline at end of file
+}); 
+// Delegated event listener for the buttons copying a request as curl, HTTPie etc.,
+// the snippet is only fetched when it's wanted
+document.addEventListener('click', function(event) {
+    const button = event.target.closest('.copy-snippet-button');
+    if (!button) {
+        return;
+    }
+    fetch(button.dataset.snippetUrl)
+        .then(response => {
+            if (!response.ok) {
+                throw new Error(`${response.status} ${response.statusText}`);
+            }
+            return response.text();
+        })
+        .then(text => navigator.clipboard.writeText(text))
+        .then(() => {
+            const originalText = button.textContent;
+            button.textContent = '✅ copied';
+            setTimeout(() => {
+                button.textContent = originalText;
+            }, 1500);
+        })
+        .catch(err => {
+            console.error('Failed to copy request: ', err);
+            alert('Failed to copy request. See console for details.');
+        });
+});